@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/EwvwGeN/assignment/internal/app/server"
+	"github.com/EwvwGeN/assignment/internal/backup"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+	_ "github.com/restream/reindexer/v3/bindings/cproto"
+)
+
+// Variables for config management
+var (
+	isConfig   bool
+	configPath string
+	outputPath string
+	tenant     string
+)
+
+func init() {
+	flag.BoolVar(&isConfig, "c", false, "config activation")
+	flag.StringVar(&configPath, "config-path", "configs/server.yaml", "path to config file")
+	flag.StringVar(&tenant, "tenant", "", "name of the tenant, the collection from the config if empty")
+	flag.StringVar(&outputPath, "o", "", "path to output file, stdout if empty")
+}
+
+func main() {
+	flag.Parse()
+	config, err := server.LoadConfig(isConfig, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tenant != "" {
+		config.CollectionName = server.TenantCollection(config, tenant)
+	}
+	db := reindexer.NewReindex(fmt.Sprintf("cproto://%s:%s/%s", config.DbHost, config.DbPort, config.DBname))
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.OpenNamespace(config.CollectionName, reindexer.DefaultNamespaceOptions(), models.Document{}); err != nil {
+		log.Fatal(err)
+	}
+
+	var output io.Writer = os.Stdout
+	if outputPath != "" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		output = file
+	}
+	header, err := backup.Export(db, config.CollectionName, output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Exported %d documents from %s, serial %d", header.Count, header.Collection, header.Serial)
+}
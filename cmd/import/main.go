@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/EwvwGeN/assignment/internal/app/server"
+	"github.com/EwvwGeN/assignment/internal/backup"
+)
+
+// Variables for config management
+var (
+	isConfig   bool
+	configPath string
+	inputPath  string
+	policy     string
+	dryRun     bool
+	tenant     string
+)
+
+func init() {
+	flag.BoolVar(&isConfig, "c", false, "config activation")
+	flag.StringVar(&configPath, "config-path", "configs/server.yaml", "path to config file")
+	flag.StringVar(&tenant, "tenant", "", "name of the tenant, the collection from the config if empty")
+	flag.StringVar(&inputPath, "i", "", "path to input file, stdin if empty")
+	flag.StringVar(&policy, "policy", "skip", "conflict policy: skip, overwrite or remap")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report what would be imported")
+}
+
+func main() {
+	flag.Parse()
+	conflictPolicy, err := backup.ParsePolicy(policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := server.LoadConfig(isConfig, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var input io.Reader = os.Stdin
+	var file *os.File
+	if inputPath != "" {
+		if file, err = os.Open(inputPath); err != nil {
+			log.Fatal(err)
+		}
+		input = file
+	}
+	report, err := server.Import(config, tenant, input, backup.Options{
+		Policy: conflictPolicy,
+		DryRun: dryRun,
+	})
+	// log.Fatal does not run the deferred calls
+	if file != nil {
+		file.Close()
+	}
+	// The documents are written even if their changes are not published, so the report is printed anyway
+	if report != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		encoder.Encode(report)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
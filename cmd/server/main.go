@@ -2,12 +2,9 @@ package main
 
 import (
 	"flag"
-	"io/ioutil"
 	"log"
 
 	"github.com/EwvwGeN/assignment/internal/app/server"
-	"github.com/joho/godotenv"
-	"gopkg.in/yaml.v2"
 )
 
 // Variables for config management
@@ -23,28 +20,10 @@ func init() {
 
 func main() {
 	flag.Parse()
-	config := getConfig()
-	server := server.NewServer(config)
-	server.Start()
-}
-
-func getConfig() *server.Config {
-	godotenv.Load()
-	config := server.NewConfig()
-	// If the "-c" attribute was received, we return the standard config
-	if !isConfig {
-		return config
-	}
-
-	file, err := ioutil.ReadFile(configPath)
+	config, err := server.LoadConfig(isConfig, configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	// Adding the config fields from the file
-	err = yaml.Unmarshal(file, config)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return config
+	server := server.NewServer(config)
+	server.Start()
 }
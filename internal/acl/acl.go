@@ -0,0 +1,260 @@
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Permissions of the rule
+const (
+	Read   = "read"
+	Write  = "write"
+	Delete = "delete"
+	// Grants every other permission and changing the rules
+	Admin = "admin"
+)
+
+// Beginning of the principal that matches a role instead of an actor
+const GroupPrefix = "group:"
+
+var (
+	UnknownPermission = errors.New("Unknown permission")
+	EmptyPrincipal    = errors.New("Rule must have a principal")
+)
+
+// Client of the request: its actor and the roles of its token
+type Principal struct {
+	Actor  string
+	Groups []string
+}
+
+func (principal *Principal) matches(rule *models.AccessRule) bool {
+	if rule.Principal == principal.Actor {
+		return true
+	}
+	if group := strings.TrimPrefix(rule.Principal, GroupPrefix); group != rule.Principal {
+		for _, value := range principal.Groups {
+			if value == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Permissions granted on a document. A document without rules on the path from the root is not
+// restricted, otherwise only the permissions granted on the path are allowed
+type Access struct {
+	restricted bool
+	granted    map[string]bool
+}
+
+func (access Access) Allows(permission string) bool {
+	return !access.restricted || access.granted[permission] || access.granted[Admin]
+}
+
+// Checks whether the permission is granted by a rule, a document without rules grants nothing
+func (access Access) Grants(permission string) bool {
+	return access.granted[permission] || access.granted[Admin]
+}
+
+// Rules are kept in memory, every check walks the path of the document without queries to the database
+type Store struct {
+	sync.RWMutex
+	db        *reindexer.Reindexer
+	namespace string
+	rules     map[int64][]*models.AccessRule
+}
+
+func NewStore(db *reindexer.Reindexer, prefix string) *Store {
+	return &Store{
+		db:        db,
+		namespace: prefix + "_acl",
+		rules:     map[int64][]*models.AccessRule{},
+	}
+}
+
+// Opening the namespace and loading all rules
+func (store *Store) Open() error {
+	if err := store.db.OpenNamespace(store.namespace, reindexer.DefaultNamespaceOptions(), models.AccessRule{}); err != nil {
+		return err
+	}
+	iterator := store.db.Query(store.namespace).Exec()
+	defer iterator.Close()
+	rules := []*models.AccessRule{}
+	for iterator.Next() {
+		rules = append(rules, iterator.Object().(*models.AccessRule))
+	}
+	if err := iterator.Error(); err != nil {
+		return err
+	}
+	store.Load(rules)
+	return nil
+}
+
+// Replacing all rules in memory with the ones of the namespace
+func (store *Store) Refresh() error {
+	iterator := store.db.Query(store.namespace).Sort("id", false).Exec()
+	defer iterator.Close()
+	rules := map[int64][]*models.AccessRule{}
+	for iterator.Next() {
+		rule := iterator.Object().(*models.AccessRule)
+		rules[rule.DocId] = append(rules[rule.DocId], rule)
+	}
+	if err := iterator.Error(); err != nil {
+		return err
+	}
+	store.Lock()
+	defer store.Unlock()
+	store.rules = rules
+	return nil
+}
+
+// Adding the stored rules to the ones in memory
+func (store *Store) Load(rules []*models.AccessRule) {
+	store.Lock()
+	defer store.Unlock()
+	for _, rule := range rules {
+		store.rules[rule.DocId] = append(store.rules[rule.DocId], rule)
+	}
+}
+
+// Rules set on the document itself
+func (store *Store) Rules(docId int64) []*models.AccessRule {
+	store.RLock()
+	defer store.RUnlock()
+	return append([]*models.AccessRule{}, store.rules[docId]...)
+}
+
+// Replacing the rules of the document in one transaction. An empty list removes the restriction
+// set on the document
+func (store *Store) Replace(docId int64, rules []*models.AccessRule) error {
+	for _, rule := range rules {
+		if rule.Principal == "" {
+			return EmptyPrincipal
+		}
+		for _, permission := range rule.Permissions {
+			switch permission {
+			case Read, Write, Delete, Admin:
+			default:
+				return fmt.Errorf("%w: %s", UnknownPermission, permission)
+			}
+		}
+	}
+	store.Lock()
+	defer store.Unlock()
+	tx, err := store.db.BeginTx(store.namespace)
+	if err != nil {
+		return err
+	}
+	tx.Query().WhereInt64("doc_id", reindexer.EQ, docId).Delete()
+	for _, rule := range rules {
+		rule.Id = 0
+		rule.DocId = docId
+		if err := tx.Insert(rule, "id=serial()"); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Ids are assigned on insert in the transaction, so the rules are read again
+	iterator := store.db.Query(store.namespace).WhereInt64("doc_id", reindexer.EQ, docId).Sort("id", false).Exec()
+	defer iterator.Close()
+	saved := []*models.AccessRule{}
+	for iterator.Next() {
+		saved = append(saved, iterator.Object().(*models.AccessRule))
+	}
+	if len(saved) == 0 {
+		delete(store.rules, docId)
+	} else {
+		store.rules[docId] = saved
+	}
+	return iterator.Error()
+}
+
+// Reading the rules of the documents from the namespace again, after another replica changed them
+func (store *Store) Reload(docIds []int64) error {
+	if len(docIds) == 0 {
+		return nil
+	}
+	iterator := store.db.Query(store.namespace).WhereInt64("doc_id", reindexer.EQ, docIds...).Sort("id", false).Exec()
+	defer iterator.Close()
+	rules := map[int64][]*models.AccessRule{}
+	for iterator.Next() {
+		rule := iterator.Object().(*models.AccessRule)
+		rules[rule.DocId] = append(rules[rule.DocId], rule)
+	}
+	if err := iterator.Error(); err != nil {
+		return err
+	}
+	store.Lock()
+	defer store.Unlock()
+	for _, id := range docIds {
+		if len(rules[id]) == 0 {
+			delete(store.rules, id)
+		} else {
+			store.rules[id] = rules[id]
+		}
+	}
+	return nil
+}
+
+// Removing the rules of the deleted documents
+func (store *Store) Forget(ids []int64) error {
+	store.Lock()
+	defer store.Unlock()
+	stored := []int64{}
+	for _, id := range ids {
+		if _, exist := store.rules[id]; exist {
+			stored = append(stored, id)
+			delete(store.rules, id)
+		}
+	}
+	if len(stored) == 0 {
+		return nil
+	}
+	_, err := store.db.Query(store.namespace).WhereInt64("doc_id", reindexer.EQ, stored...).Delete()
+	return err
+}
+
+// Adding the rules of the document to the access of its parent. A nil principal is not restricted
+func (store *Store) Inherit(parent Access, principal *Principal, docId int64) Access {
+	if principal == nil {
+		return parent
+	}
+	store.RLock()
+	defer store.RUnlock()
+	rules := store.rules[docId]
+	if len(rules) == 0 {
+		return parent
+	}
+	access := Access{restricted: true, granted: map[string]bool{}}
+	for permission := range parent.granted {
+		access.granted[permission] = true
+	}
+	for _, rule := range rules {
+		if !principal.matches(rule) {
+			continue
+		}
+		for _, permission := range rule.Permissions {
+			access.granted[permission] = true
+		}
+	}
+	return access
+}
+
+// Access to the last document of the path, the path starts with the root
+func (store *Store) Resolve(principal *Principal, path []int64) Access {
+	access := Access{}
+	for _, id := range path {
+		access = store.Inherit(access, principal, id)
+	}
+	return access
+}
@@ -0,0 +1,55 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+func newTestStore(rules ...*models.AccessRule) *Store {
+	store := NewStore(nil, "test")
+	store.Load(rules)
+	return store
+}
+
+func TestResolveInheritsRules(t *testing.T) {
+	store := newTestStore(
+		&models.AccessRule{DocId: 1, Principal: "jwt:alice", Permissions: []string{Read}},
+		&models.AccessRule{DocId: 2, Principal: GroupPrefix + "editors", Permissions: []string{Write}},
+		&models.AccessRule{DocId: 3, Principal: "jwt:bob", Permissions: []string{Admin}},
+	)
+	alice := &Principal{Actor: "jwt:alice", Groups: []string{"editors"}}
+	bob := &Principal{Actor: "jwt:bob"}
+	tests := []struct {
+		name       string
+		principal  *Principal
+		path       []int64
+		permission string
+		allowed    bool
+	}{
+		{"granted on the document", alice, []int64{1}, Read, true},
+		{"not granted on the document", alice, []int64{1}, Write, false},
+		{"granted to the group below", alice, []int64{1, 2}, Write, true},
+		{"inherited from the parent", alice, []int64{1, 2, 3}, Read, true},
+		{"other principal", bob, []int64{1}, Read, false},
+		{"admin grants everything", bob, []int64{1, 2, 3}, Delete, true},
+		{"without rules on the path", bob, []int64{4, 5}, Delete, true},
+		{"not restricted principal", nil, []int64{1, 2}, Delete, true},
+	}
+	for _, test := range tests {
+		if allowed := store.Resolve(test.principal, test.path).Allows(test.permission); allowed != test.allowed {
+			t.Errorf("%s: got %v, want %v", test.name, allowed, test.allowed)
+		}
+	}
+}
+
+func TestGrantsNeedsRule(t *testing.T) {
+	store := newTestStore(&models.AccessRule{DocId: 1, Principal: "jwt:alice", Permissions: []string{Admin}})
+	alice := &Principal{Actor: "jwt:alice"}
+	if access := store.Resolve(alice, []int64{2}); !access.Allows(Admin) || access.Grants(Admin) {
+		t.Fatal("document without rules grants the admin permission")
+	}
+	if !store.Resolve(alice, []int64{1, 2}).Grants(Write) {
+		t.Fatal("admin rule does not grant the other permissions")
+	}
+}
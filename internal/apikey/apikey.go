@@ -0,0 +1,184 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Permissions of the key
+const (
+	DocsRead  = "docs:read"
+	DocsWrite = "docs:write"
+	// Grants every other scope
+	Admin = "admin"
+)
+
+// Beginning of every generated key
+const keyPrefix = "ak_"
+
+// Length of the shown part of the key
+const shownLength = 10
+
+var (
+	UnknownScope = errors.New("Unknown scope")
+	EmptyScopes  = errors.New("Key must have at least one scope")
+	KeyNotExist  = errors.New("Key doesnt exist")
+	InvalidKey   = errors.New("Invalid key")
+)
+
+// Checks whether the list of scopes grants the scope
+func HasScope(scopes []string, scope string) bool {
+	for _, value := range scopes {
+		if value == scope || value == Admin {
+			return true
+		}
+	}
+	return false
+}
+
+func checkScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return EmptyScopes
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case DocsRead, DocsWrite, Admin:
+		default:
+			return fmt.Errorf("%w: %s", UnknownScope, scope)
+		}
+	}
+	return nil
+}
+
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func generate() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return keyPrefix + hex.EncodeToString(buffer), nil
+}
+
+type Store struct {
+	db        *reindexer.Reindexer
+	namespace string
+}
+
+func NewStore(db *reindexer.Reindexer, prefix string) *Store {
+	return &Store{
+		db:        db,
+		namespace: prefix + "_api_keys",
+	}
+}
+
+func (store *Store) Open() error {
+	return store.db.OpenNamespace(store.namespace, reindexer.DefaultNamespaceOptions(), models.ApiKey{})
+}
+
+// Creating a key bound to the tenant, an empty tenant is the collection from the configuration.
+// The returned string is the only place where the key is available
+func (store *Store) Create(name, tenant string, scopes []string) (string, *models.ApiKey, error) {
+	if err := checkScopes(scopes); err != nil {
+		return "", nil, err
+	}
+	key, err := generate()
+	if err != nil {
+		return "", nil, err
+	}
+	item := &models.ApiKey{
+		Name:      name,
+		Hash:      Hash(key),
+		Prefix:    key[:shownLength],
+		Scopes:    scopes,
+		Tenant:    tenant,
+		CreatedAt: time.Now().UnixNano(),
+	}
+	if _, err := store.db.Insert(store.namespace, item, "id=serial()"); err != nil {
+		return "", nil, err
+	}
+	return key, item, nil
+}
+
+func (store *Store) List() ([]*models.ApiKey, error) {
+	iterator := store.db.Query(store.namespace).Sort("id", false).Exec()
+	defer iterator.Close()
+	result := []*models.ApiKey{}
+	for iterator.Next() {
+		result = append(result, iterator.Object().(*models.ApiKey))
+	}
+	return result, iterator.Error()
+}
+
+func (store *Store) get(id int64) (*models.ApiKey, error) {
+	item, found := store.db.Query(store.namespace).WhereInt64("id", reindexer.EQ, id).Get()
+	if !found {
+		return nil, KeyNotExist
+	}
+	return item.(*models.ApiKey), nil
+}
+
+func (store *Store) Revoke(id int64) error {
+	item, err := store.get(id)
+	if err != nil {
+		return err
+	}
+	item.Revoked = true
+	_, err = store.db.Update(store.namespace, item)
+	return err
+}
+
+// Revoking all keys of the removed tenant, so they do not work with a new tenant of the same name
+func (store *Store) RevokeTenant(tenant string) error {
+	iterator := store.db.Query(store.namespace).
+		WhereString("tenant", reindexer.EQ, tenant).
+		Set("revoked", true).
+		Update()
+	defer iterator.Close()
+	return iterator.Error()
+}
+
+// Replacing the key with a new one with the same name and scopes, the old key stops working
+func (store *Store) Rotate(id int64) (string, *models.ApiKey, error) {
+	item, err := store.get(id)
+	if err != nil {
+		return "", nil, err
+	}
+	if item.Revoked {
+		return "", nil, KeyNotExist
+	}
+	key, err := generate()
+	if err != nil {
+		return "", nil, err
+	}
+	item.Hash = Hash(key)
+	item.Prefix = key[:shownLength]
+	item.RotatedAt = time.Now().UnixNano()
+	if _, err := store.db.Update(store.namespace, item); err != nil {
+		return "", nil, err
+	}
+	return key, item, nil
+}
+
+// Finding an active key by its value
+func (store *Store) Authenticate(key string) (*models.ApiKey, error) {
+	item, found := store.db.Query(store.namespace).Where("hash", reindexer.EQ, Hash(key)).Get()
+	if !found {
+		return nil, InvalidKey
+	}
+	apiKey := item.(*models.ApiKey)
+	if apiKey.Revoked {
+		return nil, InvalidKey
+	}
+	return apiKey, nil
+}
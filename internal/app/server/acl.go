@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/acl"
+	"github.com/EwvwGeN/assignment/internal/apikey"
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+)
+
+// Interval of reading all access rules again when several replicas share the database
+const aclRefreshInterval = time.Minute
+
+// Principal of the request for the checks of the access rules. Returns nil, so nothing is restricted,
+// when authentication is disabled or the client has the admin scope
+func (server *Server) principal(ctx *gin.Context) *acl.Principal {
+	if !server.config.AuthEnabled || apikey.HasScope(ctx.GetStringSlice(scopesKey), apikey.Admin) {
+		return nil
+	}
+	return &acl.Principal{
+		Actor:  ctx.GetString(actorKey),
+		Groups: ctx.GetStringSlice(rolesKey),
+	}
+}
+
+// Ids from the root down to the document
+func (server *Server) docPath(id int64) []int64 {
+	path := []int64{}
+	visited := map[int64]bool{}
+	for id != 0 && !visited[id] {
+		visited[id] = true
+		path = append([]int64{id}, path...)
+		doc, found := server.findDoc(id)
+		if !found {
+			break
+		}
+		id = doc.ParentId
+	}
+	return path
+}
+
+func (server *Server) docAccess(principal *acl.Principal, id int64) acl.Access {
+	if principal == nil {
+		return acl.Access{}
+	}
+	return server.acl.Resolve(principal, server.docPath(id))
+}
+
+// Checking the permission on every document of the subtree, the access is the one of its top document
+func (server *Server) checkSubtree(principal *acl.Principal, id int64, access acl.Access, permission string) error {
+	if !access.Allows(permission) {
+		return fmt.Errorf("%w: File Id:%d", Forbidden, id)
+	}
+	doc, found := server.findDoc(id)
+	if !found {
+		return nil
+	}
+	for _, childId := range doc.ChildList {
+		if err := server.checkSubtree(principal, childId, server.acl.Inherit(access, principal, childId), permission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The document is deleted with its subtree and removed from the children of its parent
+func (server *Server) checkDeleteAccess(principal *acl.Principal, id, parentId int64) error {
+	if principal == nil {
+		return nil
+	}
+	if parentId != 0 && !server.docAccess(principal, parentId).Allows(acl.Write) {
+		return fmt.Errorf("%w: File Id:%d", Forbidden, parentId)
+	}
+	return server.checkSubtree(principal, id, server.docAccess(principal, id), acl.Delete)
+}
+
+// Rules are changed only by the clients with the admin scope or with the admin permission granted
+// by a rule, so a document without rules can not be taken by any writer
+func (server *Server) canChangeRules(principal *acl.Principal, id int64) bool {
+	return principal == nil || server.docAccess(principal, id).Grants(acl.Admin)
+}
+
+// Checking the event against the rules of its document, the ancestors of the event are its path.
+// Returns false if the principal can not read the document, so the event is not sent to it
+func (server *Server) canReadEvent(principal *acl.Principal, event *events.Event) bool {
+	if principal == nil {
+		return true
+	}
+	path := make([]int64, 0, len(event.Ancestors)+1)
+	for i := len(event.Ancestors) - 1; i >= 0; i-- {
+		path = append(path, event.Ancestors[i])
+	}
+	return server.acl.Resolve(principal, append(path, event.DocId)).Allows(acl.Read)
+}
+
+// Events the principal can read, in the same order
+func (server *Server) readableEvents(principal *acl.Principal, list []*events.Event) []*events.Event {
+	if principal == nil {
+		return list
+	}
+	result := make([]*events.Event, 0, len(list))
+	for _, event := range list {
+		if server.canReadEvent(principal, event) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// Status of the response for an error of the document update
+func accessStatus(err error, status int) int {
+	if errors.Is(err, Forbidden) {
+		return http.StatusForbidden
+	}
+	return status
+}
+
+// Reading all rules again from time to time, so a lost message of the bus does not keep the old rules
+func (server *Server) refreshAccessRules(ctx context.Context) {
+	ticker := time.NewTicker(aclRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := server.acl.Refresh(); err != nil {
+			log.Printf("Can not reload access rules: %s", err)
+		}
+	}
+}
+
+// Removing the rules of the documents deleted by the published changes
+func (server *Server) forgetAccessRules(list []*events.Event) {
+	ids := []int64{}
+	for _, event := range list {
+		if event.Type == events.DELETE {
+			ids = append(ids, event.DocId)
+		}
+	}
+	if err := server.acl.Forget(ids); err != nil {
+		log.Printf("Can not remove access rules of the deleted documents: %s", err)
+	}
+	server.publishRules(ids)
+}
+
+type aclRule struct {
+	Principal   string   `json:"Principal"`
+	Permissions []string `json:"Permissions"`
+}
+
+// Returning the rules of the document and the rules inherited from its ancestors
+func (server *Server) getAcl() gin.HandlerFunc {
+	return server.checkExist(func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetAcl").Start(ctx.Request.Context(), "Get acl handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		id := ctx.GetInt64("id")
+		if !server.docAccess(server.principal(ctx), id).Allows(acl.Admin) {
+			render(ctx, http.StatusForbidden, gin.H{"error": Forbidden.Error()})
+			return
+		}
+		path := server.docPath(id)
+		inherited := []*models.AccessRule{}
+		for _, ancestorId := range path[:len(path)-1] {
+			inherited = append(inherited, server.acl.Rules(ancestorId)...)
+		}
+		render(ctx, http.StatusOK, gin.H{
+			"Rules":     server.acl.Rules(id),
+			"Inherited": inherited,
+		})
+	})
+}
+
+// Replacing the rules of the document, an empty list removes them
+func (server *Server) setAcl() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("SetAcl").Start(ctx.Request.Context(), "Set acl handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		var request []aclRule
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		server.checkExist(func(ctx *gin.Context) {
+			id := ctx.GetInt64("id")
+			if !server.canChangeRules(server.principal(ctx), id) {
+				render(ctx, http.StatusForbidden, gin.H{"error": Forbidden.Error()})
+				return
+			}
+			rules := make([]*models.AccessRule, 0, len(request))
+			for _, rule := range request {
+				rules = append(rules, &models.AccessRule{Principal: rule.Principal, Permissions: rule.Permissions})
+			}
+			if err := server.acl.Replace(id, rules); err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, acl.UnknownPermission) || errors.Is(err, acl.EmptyPrincipal) {
+					status = http.StatusBadRequest
+				}
+				render(ctx, status, gin.H{"error": err.Error()})
+				return
+			}
+			server.publishRules([]int64{id})
+			render(ctx, http.StatusOK, gin.H{"Rules": server.acl.Rules(id)})
+		})(ctx)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/acl"
+	"github.com/EwvwGeN/assignment/internal/cache"
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+// Tree 1 -> (2 -> 3, 4) without rules above the document 3 that only bob may change,
+// and tree 10 -> 11 where carol may delete the child but not change the parent
+func newAclServer(t *testing.T) *Server {
+	t.Helper()
+	server := &Server{
+		cache: cache.NewCache(cache.Options{LifeTime: time.Minute}),
+		acl:   acl.NewStore(nil, "test"),
+	}
+	t.Cleanup(server.cache.Close)
+	for _, doc := range []*models.Document{
+		{Id: 1, ChildList: []int64{2, 4}},
+		{Id: 2, ParentId: 1, ChildList: []int64{3}},
+		{Id: 3, ParentId: 2},
+		{Id: 4, ParentId: 1},
+		{Id: 10, ChildList: []int64{11}},
+		{Id: 11, ParentId: 10},
+	} {
+		server.cache.AddDoc(doc)
+	}
+	server.acl.Load([]*models.AccessRule{
+		{DocId: 3, Principal: "jwt:bob", Permissions: []string{acl.Admin}},
+		{DocId: 10, Principal: "jwt:carol", Permissions: []string{acl.Read}},
+		{DocId: 10, Principal: acl.GroupPrefix + "ops", Permissions: []string{acl.Admin}},
+		{DocId: 11, Principal: "jwt:carol", Permissions: []string{acl.Delete}},
+	})
+	return server
+}
+
+var (
+	alice = &acl.Principal{Actor: "jwt:alice"}
+	bob   = &acl.Principal{Actor: "jwt:bob"}
+	carol = &acl.Principal{Actor: "jwt:carol"}
+	ops   = &acl.Principal{Actor: "jwt:dave", Groups: []string{"ops"}}
+)
+
+func TestCheckDeleteAccess(t *testing.T) {
+	server := newAclServer(t)
+	tests := []struct {
+		name      string
+		principal *acl.Principal
+		id        int64
+		parentId  int64
+		allowed   bool
+	}{
+		{"restricted document in the subtree", alice, 2, 1, false},
+		{"restricted document itself", alice, 3, 2, false},
+		{"subtree without rules", alice, 4, 1, true},
+		{"delete on the whole subtree", bob, 2, 1, true},
+		{"no write on the parent", carol, 11, 10, false},
+		{"admin of the parent", ops, 11, 10, true},
+		{"not restricted principal", nil, 2, 1, true},
+	}
+	for _, test := range tests {
+		err := server.checkDeleteAccess(test.principal, test.id, test.parentId)
+		if (err == nil) != test.allowed || (err != nil && !errors.Is(err, Forbidden)) {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
+
+func TestCheckChildAccessOfRemovedSubtrees(t *testing.T) {
+	server := newAclServer(t)
+	if err := server.checkChildAccess(alice, 1, []int64{2}, nil); !errors.Is(err, Forbidden) {
+		t.Fatalf("removal of the child with a restricted descendant got error %v", err)
+	}
+	if err := server.checkChildAccess(alice, 1, []int64{4}, nil); err != nil {
+		t.Fatalf("removal of the child without rules got error %v", err)
+	}
+	if err := server.checkChildAccess(bob, 1, []int64{2}, nil); err != nil {
+		t.Fatalf("removal of the child with a permitted descendant got error %v", err)
+	}
+	if err := server.checkChildAccess(alice, 2, nil, []int64{11}); !errors.Is(err, Forbidden) {
+		t.Fatalf("adding the child that can not be changed got error %v", err)
+	}
+}
+
+func TestCanChangeRules(t *testing.T) {
+	server := newAclServer(t)
+	tests := []struct {
+		name      string
+		principal *acl.Principal
+		id        int64
+		allowed   bool
+	}{
+		{"document without rules", alice, 1, false},
+		{"admin scope", nil, 1, true},
+		{"admin rule on the document", bob, 3, true},
+		{"admin rule of the group on the parent", ops, 11, true},
+		{"rule without admin", carol, 11, false},
+	}
+	for _, test := range tests {
+		if allowed := server.canChangeRules(test.principal, test.id); allowed != test.allowed {
+			t.Errorf("%s: got %v, want %v", test.name, allowed, test.allowed)
+		}
+	}
+}
+
+func TestReadableEvents(t *testing.T) {
+	server := newAclServer(t)
+	list := []*events.Event{
+		{Seq: 1, Type: events.UPDATE, DocId: 3, Ancestors: []int64{2, 1}, Fields: map[string]interface{}{"Body": "secret"}},
+		{Seq: 2, Type: events.CREATE, DocId: 4, Ancestors: []int64{1}},
+		{Seq: 3, Type: events.DELETE, DocId: 11, Ancestors: []int64{10}},
+	}
+	tests := []struct {
+		name      string
+		principal *acl.Principal
+		seqs      []uint64
+	}{
+		{"restricted document", alice, []uint64{2}},
+		{"granted document", bob, []uint64{1, 2}},
+		{"inherited read", carol, []uint64{2, 3}},
+		{"not restricted principal", nil, []uint64{1, 2, 3}},
+	}
+	for _, test := range tests {
+		got := []uint64{}
+		for _, event := range server.readableEvents(test.principal, list) {
+			got = append(got, event.Seq)
+		}
+		if len(got) != len(test.seqs) {
+			t.Errorf("%s: got events %v, want %v", test.name, got, test.seqs)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.seqs[i] {
+				t.Errorf("%s: got events %v, want %v", test.name, got, test.seqs)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/EwvwGeN/assignment/internal/apikey"
+	"github.com/gin-gonic/gin"
+	"github.com/restream/reindexer/v3"
+	"go.opentelemetry.io/otel"
+)
+
+type apiKeyRequest struct {
+	Name   string   `json:"Name"`
+	Tenant string   `json:"Tenant"`
+	Scopes []string `json:"Scopes"`
+}
+
+// Creating a key, a key with the tenant works only with this tenant. The key itself is returned only in this response
+func (server *Server) createApiKey() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("CreateApiKey").Start(ctx.Request.Context(), "Create api key handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		var request apiKeyRequest
+		if err := ctx.ShouldBindJSON(&request); err != nil || request.Name == "" {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		if request.Tenant != "" {
+			if _, found := server.db.Query(server.tenants.namespace).WhereString("name", reindexer.EQ, request.Tenant).Get(); !found {
+				render(ctx, http.StatusBadRequest, gin.H{"error": TenantNotExist.Error()})
+				return
+			}
+		}
+		key, item, err := server.apiKeys.Create(request.Name, request.Tenant, request.Scopes)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		item.Hash = ""
+		render(ctx, http.StatusCreated, gin.H{"Key": key, "ApiKey": item})
+	}
+}
+
+func (server *Server) getApiKeys() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetApiKeys").Start(ctx.Request.Context(), "Get api keys handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		items, err := server.apiKeys.List()
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		for _, item := range items {
+			item.Hash = ""
+		}
+		render(ctx, http.StatusOK, items)
+	}
+}
+
+func (server *Server) revokeApiKey() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("RevokeApiKey").Start(ctx.Request.Context(), "Revoke api key handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		if err := server.apiKeys.Revoke(id); err != nil {
+			render(ctx, apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		render(ctx, http.StatusOK, gin.H{"message": "ok"})
+	}
+}
+
+// Replacing the key with a new one, the new key is returned only in this response
+func (server *Server) rotateApiKey() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("RotateApiKey").Start(ctx.Request.Context(), "Rotate api key handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		key, item, err := server.apiKeys.Rotate(id)
+		if err != nil {
+			render(ctx, apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		item.Hash = ""
+		render(ctx, http.StatusOK, gin.H{"Key": key, "ApiKey": item})
+	}
+}
+
+func apiKeyErrorStatus(err error) int {
+	if errors.Is(err, apikey.KeyNotExist) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/audit"
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+)
+
+// Keys of the values kept in the gin context
+const (
+	// Identity of the client, set by the authentication
+	actorKey     = "actor"
+	requestIdKey = "requestId"
+	// Changes of the documents made by the request
+	auditKey = "audit"
+	// Ids of the documents written by the request without the details of the changes
+	auditDocsKey = "auditDocs"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+const anonymousActor = "anonymous"
+
+// Taking the request id from the header or generating a new one and returning it in the response
+func (server *Server) requestId() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(requestIdHeader)
+		if id == "" || len(id) > 128 {
+			buffer := make([]byte, 16)
+			rand.Read(buffer)
+			id = hex.EncodeToString(buffer)
+		}
+		ctx.Set(requestIdKey, id)
+		ctx.Header(requestIdHeader, id)
+		ctx.Next()
+	}
+}
+
+// Writing an audit record after every request that can change data
+func (server *Server) auditTrail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+		ctx.Next()
+		actor := ctx.GetString(actorKey)
+		if actor == "" {
+			actor = anonymousActor
+		}
+		entry := &audit.Entry{
+			Actor:     actor,
+			ClientIp:  ctx.ClientIP(),
+			RequestId: ctx.GetString(requestIdKey),
+			Method:    ctx.Request.Method,
+			Endpoint:  ctx.FullPath(),
+			Path:      ctx.Request.URL.Path,
+			Status:    ctx.Writer.Status(),
+			Time:      time.Now().UTC(),
+		}
+		if changes, exist := ctx.Get(auditKey); exist {
+			entry.Changes = changes.([]audit.Change)
+			for _, change := range entry.Changes {
+				entry.DocIds = append(entry.DocIds, change.DocId)
+			}
+		}
+		if docIds, exist := ctx.Get(auditDocsKey); exist {
+			entry.DocIds = append(entry.DocIds, docIds.([]int64)...)
+		}
+		if err := server.auditServer(ctx).audit.Record(entry); err != nil {
+			log.Printf("Can not write audit record: %s", err)
+		}
+	}
+}
+
+// Server keeping the audit of the request: the one of the named collection of the path, of the tenant or the main one
+func (server *Server) auditServer(ctx *gin.Context) *Server {
+	if collectionServer, ok := ctx.Value(collectionKey).(*Server); ok {
+		return collectionServer
+	}
+	return server.requestServer(ctx)
+}
+
+// Saving the changes of the request for the audit record, the values before are taken from the snapshot
+func auditChanges(ctx *gin.Context, before map[int64]*models.Document, list []*events.Event) {
+	changes := make([]audit.Change, 0, len(list))
+	for _, event := range list {
+		change := audit.Change{DocId: event.DocId}
+		var beforeFields map[string]interface{}
+		if doc := before[event.DocId]; doc != nil {
+			beforeFields = documentFields(doc)
+		}
+		switch event.Type {
+		case events.CREATE:
+			change.After = event.Fields
+		case events.UPDATE:
+			change.After = event.Fields
+			if beforeFields != nil {
+				change.Before = map[string]interface{}{}
+				for field := range event.Fields {
+					change.Before[field] = beforeFields[field]
+				}
+			}
+		case events.DELETE:
+			change.Before = beforeFields
+		}
+		changes = append(changes, change)
+	}
+	ctx.Set(auditKey, changes)
+}
+
+// Searching the audit records by actor, document and time range. Time is passed in RFC 3339
+func (server *Server) getAudit() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetAudit").Start(ctx.Request.Context(), "Get audit handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		var filter audit.Filter
+		var err error
+		filter.Actor = ctx.Query("actor")
+		if filter.DocId, err = strconv.ParseInt(ctx.DefaultQuery("doc", "0"), 10, 64); err != nil {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if from := ctx.Query("from"); from != "" {
+			if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+				ctx.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+		}
+		if to := ctx.Query("to"); to != "" {
+			if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+				ctx.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+		}
+		filter.Page, err = strconv.Atoi(ctx.DefaultQuery("page", "0"))
+		if err != nil || filter.Page < 0 {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		filter.Limit, err = strconv.Atoi(ctx.DefaultQuery("limit", "10"))
+		if err != nil || filter.Limit < 0 {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		entries, err := server.audit.Find(filter)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		render(ctx, http.StatusOK, entries)
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"crypto"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/apikey"
+	"github.com/EwvwGeN/assignment/internal/jwt"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Keys of the granted scopes, the roles and the verified token in the gin context
+const (
+	scopesKey   = "scopes"
+	rolesKey    = "roles"
+	identityKey = "identity"
+	// Tenant the API key is bound to
+	keyTenantKey = "keyTenant"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// Query parameter with the API key, accepted only by the routes of the streams
+const apiKeyParam = "api_key"
+
+// Routes the browsers open with EventSource and WebSocket, which can not set headers
+var apiKeyParamRoutes = map[string]bool{
+	"/events": true,
+	"/ws":     true,
+}
+
+// Keys of the clients. The server uses the store of the database, the tests use their own keys
+type apiKeyStore interface {
+	Open() error
+	Create(name, tenant string, scopes []string) (string, *models.ApiKey, error)
+	List() ([]*models.ApiKey, error)
+	Revoke(id int64) error
+	RevokeTenant(tenant string) error
+	Rotate(id int64) (string, *models.ApiKey, error)
+	Authenticate(key string) (*models.ApiKey, error)
+}
+
+// Actor of the key set in the configuration
+const configAdminActor = "apikey:config-admin"
+
+// Actor of the stored key. The names of the keys may repeat, so the actor is built from the id,
+// the rotated key keeps it
+func apiKeyActor(id int64) string {
+	return "apikey:" + strconv.FormatInt(id, 10)
+}
+
+// Taking the API key from the X-API-Key header, the "Authorization: ApiKey <key>" header or,
+// for the streams that can not set headers such as EventSource, from the "api_key" query parameter.
+// The parameter is ignored by the other routes, so the key does not get to the logs of their URLs
+func apiKeyFromRequest(ctx *gin.Context) string {
+	if key := ctx.GetHeader(apiKeyHeader); key != "" {
+		return key
+	}
+	if scheme, key, found := strings.Cut(ctx.GetHeader("Authorization"), " "); found && strings.EqualFold(scheme, "ApiKey") {
+		return strings.TrimSpace(key)
+	}
+	if apiKeyParamRoutes[ctx.FullPath()] {
+		return ctx.Query(apiKeyParam)
+	}
+	return ""
+}
+
+// Creating the verifier of the bearer tokens from the keys in the configuration. Returns nil
+// if no key is configured, then bearer tokens are rejected
+func newJwtVerifier(config *Config) (*jwt.Verifier, error) {
+	keys := map[string]crypto.PublicKey{}
+	var pemKeys []crypto.PublicKey
+	if config.JwtPublicKeyFile != "" {
+		var err error
+		pemKeys, err = jwt.LoadPEM(config.JwtPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.JwtJwksFile != "" {
+		jwksKeys, err := jwt.LoadJWKS(config.JwtJwksFile)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range jwksKeys {
+			keys[kid] = key
+		}
+	}
+	if config.JwtHs256Secret == "" && len(keys) == 0 && len(pemKeys) == 0 {
+		return nil, nil
+	}
+	return jwt.NewVerifier(jwt.Options{
+		HmacSecret:        config.JwtHs256Secret,
+		Keys:              keys,
+		UnnamedKeys:       pemKeys,
+		Issuer:            config.JwtIssuer,
+		Audience:          config.JwtAudience,
+		RolesClaim:        config.JwtRolesClaim,
+		Leeway:            time.Duration(config.JwtLeeway) * time.Second,
+		RequireExpiration: config.JwtRequireExp,
+	}), nil
+}
+
+// Taking the token from the "Authorization: Bearer <token>" header
+func bearerFromRequest(ctx *gin.Context) string {
+	if scheme, token, found := strings.Cut(ctx.GetHeader("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// Roles that have the names of the scopes grant them, as well as the values of the standard "scope" claim
+func tokenScopes(identity *jwt.Identity) []string {
+	scopes := []string{}
+	for _, value := range append(append([]string{}, identity.Roles...), strings.Fields(claimString(identity.Claims, "scope"))...) {
+		switch value {
+		case apikey.DocsRead, apikey.DocsWrite, apikey.Admin:
+			scopes = append(scopes, value)
+		}
+	}
+	return scopes
+}
+
+func claimString(claims jwt.Claims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// Verified token of the request, nil if the client used an API key or no credentials
+func requestIdentity(ctx *gin.Context) *jwt.Identity {
+	identity, _ := ctx.Value(identityKey).(*jwt.Identity)
+	return identity
+}
+
+// Identifying the client by the credentials of the request. Requests without credentials pass
+// unauthenticated, the routes check the scopes themselves
+func (server *Server) authenticate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !server.config.AuthEnabled {
+			ctx.Next()
+			return
+		}
+		if token := bearerFromRequest(ctx); token != "" {
+			server.authenticateToken(ctx, token)
+			return
+		}
+		key := apiKeyFromRequest(ctx)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if server.config.AdminApiKey != "" && subtle.ConstantTimeCompare([]byte(apikey.Hash(key)), []byte(apikey.Hash(server.config.AdminApiKey))) == 1 {
+			ctx.Set(actorKey, configAdminActor)
+			ctx.Set(scopesKey, []string{apikey.Admin})
+			ctx.Next()
+			return
+		}
+		apiKey, err := server.apiKeys.Authenticate(key)
+		if err != nil {
+			ctx.Header("WWW-Authenticate", "ApiKey")
+			ctx.Abort()
+			render(ctx, http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.Set(actorKey, apiKeyActor(apiKey.Id))
+		ctx.Set(scopesKey, apiKey.Scopes)
+		ctx.Set(keyTenantKey, apiKey.Tenant)
+		ctx.Next()
+	}
+}
+
+func (server *Server) authenticateToken(ctx *gin.Context, token string) {
+	if server.jwtVerifier == nil {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		ctx.Abort()
+		render(ctx, http.StatusUnauthorized, gin.H{"error": jwt.UnknownKey.Error()})
+		return
+	}
+	identity, err := server.jwtVerifier.Verify(token)
+	if err != nil {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		ctx.Abort()
+		render(ctx, http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Set(actorKey, "jwt:"+identity.Subject)
+	ctx.Set(rolesKey, identity.Roles)
+	ctx.Set(scopesKey, tokenScopes(identity))
+	ctx.Set(identityKey, identity)
+	ctx.Next()
+}
+
+// Letting the request through only if the client has the scope. Does nothing when authentication is disabled
+func (server *Server) requireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !server.config.AuthEnabled {
+			ctx.Next()
+			return
+		}
+		if _, exist := ctx.Get(actorKey); !exist {
+			ctx.Header("WWW-Authenticate", "ApiKey, Bearer")
+			ctx.Abort()
+			render(ctx, http.StatusUnauthorized, gin.H{"error": Unauthorized.Error()})
+			return
+		}
+		if !apikey.HasScope(ctx.GetStringSlice(scopesKey), scope) {
+			ctx.Abort()
+			render(ctx, http.StatusForbidden, gin.H{"error": Forbidden.Error()})
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/apikey"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Keys kept in memory with the checks of the store of the database
+type memoryKeys struct {
+	keys map[string]*models.ApiKey
+}
+
+func (store *memoryKeys) Open() error { return nil }
+
+func (store *memoryKeys) Create(name, tenant string, scopes []string) (string, *models.ApiKey, error) {
+	id := int64(len(store.keys) + 1)
+	key := "ak_" + name + "_" + strconv.FormatInt(id, 10)
+	item := &models.ApiKey{Id: id, Name: name, Hash: apikey.Hash(key), Scopes: scopes, Tenant: tenant}
+	store.keys[item.Hash] = item
+	return key, item, nil
+}
+
+func (store *memoryKeys) List() ([]*models.ApiKey, error) {
+	items := []*models.ApiKey{}
+	for _, item := range store.keys {
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (store *memoryKeys) Revoke(id int64) error {
+	for _, item := range store.keys {
+		if item.Id == id {
+			item.Revoked = true
+			return nil
+		}
+	}
+	return apikey.KeyNotExist
+}
+
+func (store *memoryKeys) RevokeTenant(tenant string) error {
+	for _, item := range store.keys {
+		if item.Tenant == tenant {
+			item.Revoked = true
+		}
+	}
+	return nil
+}
+
+func (store *memoryKeys) Rotate(id int64) (string, *models.ApiKey, error) {
+	return "", nil, apikey.KeyNotExist
+}
+
+func (store *memoryKeys) Authenticate(key string) (*models.ApiKey, error) {
+	item, exist := store.keys[apikey.Hash(key)]
+	if !exist || item.Revoked {
+		return nil, apikey.InvalidKey
+	}
+	return item, nil
+}
+
+func newAuthServer(t *testing.T) (*Server, *gin.Engine, map[string]string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	keys := &memoryKeys{keys: map[string]*models.ApiKey{}}
+	server := &Server{
+		config:  &Config{AuthEnabled: true, AdminApiKey: "config-admin-key"},
+		apiKeys: keys,
+	}
+	values := map[string]string{}
+	// The keys get the ids in this order, the second writer has the same name as the first one
+	for _, created := range []struct {
+		value  string
+		name   string
+		scopes []string
+	}{
+		{"reader", "reader", []string{apikey.DocsRead}},
+		{"writer", "writer", []string{apikey.DocsRead, apikey.DocsWrite}},
+		{"revoked", "revoked", []string{apikey.DocsRead}},
+		{"other writer", "writer", []string{apikey.DocsRead, apikey.DocsWrite}},
+	} {
+		key, item, _ := keys.Create(created.name, "", created.scopes)
+		values[created.value] = key
+		if created.value == "revoked" {
+			keys.Revoke(item.Id)
+		}
+	}
+	router := gin.New()
+	router.Use(server.authenticate())
+	ok := func(ctx *gin.Context) { ctx.String(http.StatusOK, ctx.GetString(actorKey)) }
+	router.GET("/docs", server.requireScope(apikey.DocsRead), ok)
+	router.POST("/docs", server.requireScope(apikey.DocsWrite), ok)
+	router.GET("/events", server.requireScope(apikey.DocsRead), ok)
+	router.GET("/admin/api-keys", server.requireScope(apikey.Admin), ok)
+	return server, router, values
+}
+
+func TestAuthentication(t *testing.T) {
+	_, router, keys := newAuthServer(t)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header map[string]string
+		status int
+		actor  string
+	}{
+		{"missing key", http.MethodGet, "/docs", nil, http.StatusUnauthorized, ""},
+		{"bad key", http.MethodGet, "/docs", map[string]string{apiKeyHeader: "ak_unknown"}, http.StatusUnauthorized, ""},
+		{"revoked key", http.MethodGet, "/docs", map[string]string{apiKeyHeader: keys["revoked"]}, http.StatusUnauthorized, ""},
+		{"wrong scope", http.MethodPost, "/docs", map[string]string{apiKeyHeader: keys["reader"]}, http.StatusForbidden, ""},
+		{"not admin", http.MethodGet, "/admin/api-keys", map[string]string{apiKeyHeader: keys["writer"]}, http.StatusForbidden, ""},
+		{"header key", http.MethodPost, "/docs", map[string]string{apiKeyHeader: keys["writer"]}, http.StatusOK, "apikey:2"},
+		{"key with the same name", http.MethodPost, "/docs", map[string]string{apiKeyHeader: keys["other writer"]}, http.StatusOK, "apikey:4"},
+		{"authorization key", http.MethodGet, "/docs", map[string]string{"Authorization": "ApiKey " + keys["reader"]}, http.StatusOK, "apikey:1"},
+		{"config admin key", http.MethodGet, "/admin/api-keys", map[string]string{apiKeyHeader: "config-admin-key"}, http.StatusOK, configAdminActor},
+		{"query key on stream", http.MethodGet, "/events?api_key=" + keys["reader"], nil, http.StatusOK, "apikey:1"},
+		{"query key elsewhere", http.MethodGet, "/docs?api_key=" + keys["reader"], nil, http.StatusUnauthorized, ""},
+		{"bearer without verifier", http.MethodGet, "/docs", map[string]string{"Authorization": "Bearer token"}, http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.target, nil)
+			for name, value := range test.header {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, test.status, recorder.Body.String())
+			}
+			if test.actor != "" && recorder.Body.String() != test.actor {
+				t.Fatalf("got actor %q, want %q", recorder.Body.String(), test.actor)
+			}
+		})
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	tests := map[string]string{
+		"/docs":                        "/docs",
+		"/docs?pretty":                 "/docs?pretty",
+		"/events?api_key=ak_secret":    "/events?api_key=" + redacted,
+		"/ws?root=1&api_key=ak_secret": "/ws?api_key=" + redacted + "&root=1",
+		"/events?api_key=%zz":          "/events?" + redacted,
+	}
+	for path, want := range tests {
+		if got := redactPath(path); got != want {
+			t.Errorf("redactPath(%q) = %q, want %q", path, got, want)
+		}
+		if strings.Contains(redactPath(path), "ak_secret") {
+			t.Errorf("key is not redacted in %q", path)
+		}
+	}
+}
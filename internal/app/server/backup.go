@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/EwvwGeN/assignment/internal/backup"
+	"github.com/EwvwGeN/assignment/internal/cache"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/restream/reindexer/v3"
+	"go.opentelemetry.io/otel"
+)
+
+// Streaming the whole collection in NDJSON
+func (server *Server) exportDocs() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("ExportDocs").Start(ctx.Request.Context(), "Export docs handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		server.db.WithContext(ctx.Request.Context())
+		ctx.Header("Content-Type", "application/x-ndjson")
+		ctx.Header("Content-Disposition", "attachment; filename=\""+server.config.CollectionName+".ndjson\"")
+		ctx.Status(http.StatusOK)
+		if _, err := backup.Export(server.db, server.config.CollectionName, ctx.Writer); err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Loading the NDJSON dump into the collection. The query parameters "policy" and "dry_run" set the import options
+func (server *Server) importDocs() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("ImportDocs").Start(ctx.Request.Context(), "Import docs handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		server.db.WithContext(ctx.Request.Context())
+		policy, err := backup.ParsePolicy(ctx.DefaultQuery("policy", string(backup.Skip)))
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		report, err := server.importDump(ctx.Request.Body, backup.Options{
+			Policy: policy,
+			DryRun: dryRun,
+		})
+		if err != nil {
+			render(ctx, quotaStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+		ctx.Set(auditDocsKey, report.Written)
+		render(ctx, http.StatusOK, report)
+	}
+}
+
+// Loading the dump into the collection. The documents are checked against the schema and the quotas,
+// the written documents are published as created and updated ones
+func (server *Server) importDump(r io.Reader, options backup.Options) (*backup.Report, error) {
+	// The lock is held until the documents are committed, so the count includes the concurrent documents
+	defer server.lockQuota()()
+	options.Check = func(report *backup.Report, docs []*models.Document) error {
+		bodies := make([]string, 0, len(docs))
+		for _, doc := range docs {
+			if err := server.checkSchema(doc.Body); err != nil {
+				return fmt.Errorf("Document %d: %w", doc.Id, err)
+			}
+			bodies = append(bodies, doc.Body)
+		}
+		return server.checkQuota(report.Inserted, bodies...)
+	}
+	var marker int64
+	options.Mark = func(tx *reindexer.Tx, report *backup.Report) error {
+		created := make([]int64, 0, len(report.Created))
+		for _, doc := range report.Created {
+			created = append(created, doc.Id)
+		}
+		var err error
+		marker, err = server.markChange(tx, replacedActions(report), created)
+		return err
+	}
+	report, err := backup.Import(server.db, server.config.CollectionName, r, options)
+	if err != nil || report.DryRun {
+		return report, err
+	}
+	// Cached copies of overwritten documents are no longer valid
+	server.delFromCache(report.Written...)
+	server.publishChanges(marker, replacedActions(report), report.Created)
+	return report, nil
+}
+
+// Overwritten documents of the import as updates of all their fields
+func replacedActions(report *backup.Report) map[int64]map[cache.Action]map[string]interface{} {
+	actions := make(map[int64]map[cache.Action]map[string]interface{}, len(report.Replaced))
+	for _, doc := range report.Replaced {
+		actions[doc.Id] = map[cache.Action]map[string]interface{}{cache.UPDATE: documentFields(doc)}
+	}
+	return actions
+}
+
+// Importing the dump into the collection of the configuration or of its tenant without starting the server.
+// The dump is checked and published like the one of /admin/import, the changes get into the change log
+// and the webhook outbox of the collection
+func Import(config *Config, tenant string, r io.Reader, options backup.Options) (*backup.Report, error) {
+	collection := *config
+	if tenant != "" {
+		collection.CollectionName = TenantCollection(config, tenant)
+	}
+	db := reindexer.NewReindex(fmt.Sprintf("cproto://%s:%s/%s", config.DbHost, config.DbPort, config.DBname), reindexer.WithCreateDBIfMissing())
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	if tenant != "" {
+		if err := db.OpenNamespace(tenantsNamespace(config), reindexer.DefaultNamespaceOptions(), models.Tenant{}); err != nil {
+			return nil, err
+		}
+		if _, found := db.Query(tenantsNamespace(config)).WhereString("name", reindexer.EQ, tenant).Get(); !found {
+			return nil, fmt.Errorf("%w: %s", TenantNotExist, tenant)
+		}
+	}
+	importer := newCollectionServer(db, &collection)
+	// The replicas listen to the bus of the main collection
+	bus, err := (&Server{config: config, db: db}).newInvalidationBus()
+	if err != nil {
+		log.Printf("Can not connect to the invalidation bus, the replicas keep the cached documents: %s", err)
+	}
+	importer.bus = bus
+	if err := importer.openCollection(); err != nil {
+		return nil, err
+	}
+	defer importer.closeCollection()
+	report, err := importer.importDump(r, options)
+	if err != nil {
+		return report, err
+	}
+	// Nothing retries the changes after the exit
+	if err := importer.publisher.retry(); err != nil {
+		return report, fmt.Errorf("%w: %s", ChangesNotPublished, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+)
+
+// Registering the routes to inspect and invalidate the cache of the collection
+func (server *Server) cacheRoutes(cacheGroupe *gin.RouterGroup, dispatch func(func(*Server) gin.HandlerFunc) gin.HandlerFunc) {
+	cacheGroupe.GET("", dispatch((*Server).getCacheStats))
+	cacheGroupe.DELETE("", dispatch((*Server).flushCache))
+	cacheGroupe.GET("/:id", dispatch((*Server).getCacheEntry))
+	cacheGroupe.DELETE("/:id", dispatch((*Server).invalidateCacheEntry))
+	cacheGroupe.DELETE("/:id/subtree", dispatch((*Server).invalidateCacheSubtree))
+}
+
+// Removing the document and all its descendants from the cache. The children are taken both
+// from the cache and from the database, so the documents moved or deleted since caching are removed too
+func (server *Server) invalidateSubtree(id int64, visited map[int64]bool) int {
+	if visited[id] {
+		return 0
+	}
+	visited[id] = true
+	removed := 0
+	children := []int64{}
+	if entry, cached := server.cache.Entry(id); cached {
+		children = append(children, entry.Document.ChildList...)
+		removed++
+	}
+	if doc, found := server.getFromBD(id); found {
+		children = append(children, doc.ChildList...)
+	}
+	server.cache.DelDoc(id)
+	for _, child := range children {
+		removed += server.invalidateSubtree(child, visited)
+	}
+	return removed
+}
+
+func (server *Server) getCacheStats() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetCacheStats").Start(ctx.Request.Context(), "Get cache stats handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		render(ctx, http.StatusOK, server.cache.Stats())
+	}
+}
+
+func (server *Server) getCacheEntry() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetCacheEntry").Start(ctx.Request.Context(), "Get cache entry handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		entry, cached := server.cache.Entry(id)
+		if !cached {
+			render(ctx, http.StatusNotFound, gin.H{"error": DocumentNotCached.Error()})
+			return
+		}
+		render(ctx, http.StatusOK, entry)
+	}
+}
+
+func (server *Server) invalidateCacheEntry() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("InvalidateCacheEntry").Start(ctx.Request.Context(), "Invalidate cache entry handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		_, cached := server.cache.Entry(id)
+		server.delFromCache(id)
+		removed := 0
+		if cached {
+			removed = 1
+		}
+		render(ctx, http.StatusOK, gin.H{"message": "ok", "removed": removed})
+	}
+}
+
+func (server *Server) invalidateCacheSubtree() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("InvalidateCacheSubtree").Start(ctx.Request.Context(), "Invalidate cache subtree handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		visited := map[int64]bool{}
+		removed := server.invalidateSubtree(id, visited)
+		ids := make([]int64, 0, len(visited))
+		for visitedId := range visited {
+			ids = append(ids, visitedId)
+		}
+		server.publishInvalidation(ids)
+		render(ctx, http.StatusOK, gin.H{"message": "ok", "removed": removed})
+	}
+}
+
+func (server *Server) flushCache() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("FlushCache").Start(ctx.Request.Context(), "Flush cache handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		server.flushCaches()
+		render(ctx, http.StatusOK, gin.H{"message": "ok"})
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/restream/reindexer/v3"
+	"go.opentelemetry.io/otel"
+)
+
+// Orders of the child documents in the big documents
+const (
+	sortDesc = "desc"
+	sortAsc  = "asc"
+)
+
+// Key of the server of the named collection of the request in the gin context
+const collectionKey = "collection"
+
+// Name of the named collection, the namespaces of its stores start with it
+func (server *Server) namedCollection(name string) string {
+	return server.config.CollectionName + "_collection_" + name
+}
+
+// Returning the server of the named collection, opening its namespaces if it is the first request to it
+func (server *Server) collectionServer(name string) (*Server, error) {
+	return server.collections.open(name, func() (*Server, error) {
+		item, found := server.db.Query(server.collections.namespace).WhereString("name", reindexer.EQ, name).Get()
+		if !found {
+			return nil, CollectionNotExist
+		}
+		collection := item.(*models.Collection)
+		bodyPattern, err := regexp.Compile(collection.Schema.BodyPattern)
+		if err != nil {
+			return nil, err
+		}
+		config := *server.config
+		config.CollectionName = server.namedCollection(collection.Name)
+		if collection.NestingLevel > 0 {
+			config.NestingLevel = collection.NestingLevel
+		}
+		collectionServer := newCollectionServer(server.db, &config)
+		collectionServer.apiKeys = server.apiKeys
+		collectionServer.jwtVerifier = server.jwtVerifier
+		collectionServer.bus = server.bus
+		collectionServer.collection = collection
+		collectionServer.bodyPattern = bodyPattern
+		return collectionServer, nil
+	})
+}
+
+// Running the handler of the server of the named collection from the path
+func (server *Server) namedCollectionHandler(handler func(*Server) gin.HandlerFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		collectionServer, err := server.requestServer(ctx).collectionServer(ctx.Param("name"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, CollectionNotExist) {
+				status = http.StatusNotFound
+			}
+			render(ctx, status, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.Set(collectionKey, collectionServer)
+		handler(collectionServer)(ctx)
+	}
+}
+
+// Sorting the first level of the child documents in the order of the collection
+func (server *Server) sortChildren(bigDoc *models.BigDocument) {
+	if bigDoc.ChildList == nil {
+		return
+	}
+	ascending := server.collection != nil && server.collection.SortOrder == sortAsc
+	sort.Slice(bigDoc.ChildList, func(i, j int) bool {
+		if ascending {
+			return bigDoc.ChildList[i].Sort < bigDoc.ChildList[j].Sort
+		}
+		return bigDoc.ChildList[i].Sort > bigDoc.ChildList[j].Sort
+	})
+}
+
+// Checking the body of the document against the schema of the named collection
+func (server *Server) checkSchema(body string) error {
+	if server.collection == nil {
+		return nil
+	}
+	schema := server.collection.Schema
+	if schema.MaxBodyLength > 0 && len(body) > schema.MaxBodyLength {
+		return fmt.Errorf("%w: Body is longer than %d", SchemaViolation, schema.MaxBodyLength)
+	}
+	if schema.JsonBody && !json.Valid([]byte(body)) {
+		return fmt.Errorf("%w: Body is not a valid JSON", SchemaViolation)
+	}
+	if schema.BodyPattern != "" && !server.bodyPattern.MatchString(body) {
+		return fmt.Errorf("%w: Body does not match the pattern", SchemaViolation)
+	}
+	return nil
+}
+
+// Checking the bodies of all nodes of the tree
+func (server *Server) checkTreeSchema(bigDoc *models.BigDocument) error {
+	if err := server.checkSchema(bigDoc.Body); err != nil {
+		return err
+	}
+	for i := range bigDoc.ChildList {
+		if err := server.checkTreeSchema(&bigDoc.ChildList[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (server *Server) getCollections() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetCollections").Start(ctx.Request.Context(), "Get collections handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		iterator := server.db.Query(server.collections.namespace).Sort("name", false).Exec()
+		defer iterator.Close()
+		collections := []*models.Collection{}
+		for iterator.Next() {
+			collections = append(collections, iterator.Object().(*models.Collection))
+		}
+		if err := iterator.Error(); err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		render(ctx, http.StatusOK, collections)
+	}
+}
+
+// Registering the named collection and opening its namespaces
+func (server *Server) createCollection() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("CreateCollection").Start(ctx.Request.Context(), "Create collection handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		var collection models.Collection
+		if err := ctx.ShouldBindJSON(&collection); err != nil || collection.NestingLevel < 0 || collection.Schema.MaxBodyLength < 0 {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		if !namePattern.MatchString(collection.Name) {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidCollection.Error()})
+			return
+		}
+		switch collection.SortOrder {
+		case "":
+			collection.SortOrder = sortDesc
+		case sortDesc, sortAsc:
+		default:
+			render(ctx, http.StatusBadRequest, gin.H{"error": UnknownSortOrder.Error()})
+			return
+		}
+		if _, err := regexp.Compile(collection.Schema.BodyPattern); err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("%s: %w", InvalidRequest.Error(), err).Error()})
+			return
+		}
+		collection.CreatedAt = time.Now().UnixNano()
+		count, err := server.db.Insert(server.collections.namespace, &collection)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if count == 0 {
+			render(ctx, http.StatusConflict, gin.H{"error": CollectionExist.Error()})
+			return
+		}
+		if _, err := server.collectionServer(collection.Name); err != nil {
+			render(ctx, http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		render(ctx, http.StatusCreated, collection)
+	}
+}
+
+// Removing the named collection and dropping all its namespaces
+func (server *Server) deleteCollection() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("DeleteCollection").Start(ctx.Request.Context(), "Delete collection handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		name := ctx.Param("name")
+		count, err := server.db.Query(server.collections.namespace).WhereString("name", reindexer.EQ, name).Delete()
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if count == 0 {
+			render(ctx, http.StatusNotFound, gin.H{"error": CollectionNotExist.Error()})
+			return
+		}
+		server.collections.close(name)
+		if err := dropCollection(server.db, server.namedCollection(name)); err != nil {
+			render(ctx, http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		render(ctx, http.StatusOK, gin.H{"message": "ok"})
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckSchema(t *testing.T) {
+	server := &Server{
+		collection: &models.Collection{Schema: models.CollectionSchema{
+			MaxBodyLength: 20,
+			BodyPattern:   `^\{"title":`,
+			JsonBody:      true,
+		}},
+		bodyPattern: regexp.MustCompile(`^\{"title":`),
+	}
+	tests := map[string]error{
+		`{"title":"note"}`:               nil,
+		`{"title":"longer than twenty"}`: SchemaViolation,
+		`{"title":`:                      SchemaViolation,
+		`{"name":"note"}`:                SchemaViolation,
+	}
+	for body, want := range tests {
+		if err := server.checkSchema(body); !errors.Is(err, want) {
+			t.Errorf("checkSchema(%q) = %v, want %v", body, err, want)
+		}
+	}
+	if err := (&Server{}).checkSchema("any body"); err != nil {
+		t.Errorf("body of the collection without a schema is rejected: %v", err)
+	}
+}
+
+func TestAuditServerOfNamedCollection(t *testing.T) {
+	main, tenant, collection := &Server{}, &Server{}, &Server{}
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if main.auditServer(ctx) != main {
+		t.Fatal("request without a tenant and a collection is not audited by the main server")
+	}
+	ctx.Set(tenantKey, tenant)
+	if main.auditServer(ctx) != tenant {
+		t.Fatal("request of the tenant is not audited by the server of the tenant")
+	}
+	// The collection of the tenant keeps its own audit
+	ctx.Set(collectionKey, collection)
+	if main.auditServer(ctx) != collection {
+		t.Fatal("request to the named collection is not audited by the server of the collection")
+	}
+}
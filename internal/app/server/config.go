@@ -1,34 +1,132 @@
 package server
 
 import (
+	"io/ioutil"
 	"os"
 	"strconv"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	ApiHost               string `yaml:"api_host"`
-	APiPort               string `yaml:"api_port"`
-	DbHost                string `yaml:"db_host"`
-	DbPort                string `yaml:"db_port"`
-	DBname                string `yaml:"db_name"`
-	CollectionName        string `yaml:"collection_name"`
-	NestingLevel          int    `yaml:"nesting_level"`
-	CachelifeTime         int    `yaml:"cache_life_time_m"`
-	CacheCleaningInterval int    `yaml:"cache_cleaning_interval_m"`
+	ApiHost                  string `yaml:"api_host"`
+	APiPort                  string `yaml:"api_port"`
+	DbHost                   string `yaml:"db_host"`
+	DbPort                   string `yaml:"db_port"`
+	DBname                   string `yaml:"db_name"`
+	CollectionName           string `yaml:"collection_name"`
+	NestingLevel             int    `yaml:"nesting_level"`
+	CachelifeTime            int    `yaml:"cache_life_time_m"`
+	CacheCleaningInterval    int    `yaml:"cache_cleaning_interval_m"`
+	CacheMaxEntries          int    `yaml:"cache_max_entries"`
+	CacheMaxBytes            int    `yaml:"cache_max_bytes"`
+	CachePolicy              string `yaml:"cache_policy"`
+	CacheNegativeTtl         int    `yaml:"cache_negative_ttl_s"`
+	CacheWarmupRoots         int    `yaml:"cache_warmup_roots"`
+	CacheWarmupTrees         int    `yaml:"cache_warmup_trees"`
+	CacheSnapshotFile        string `yaml:"cache_snapshot_file"`
+	InvalidationTransport    string `yaml:"invalidation_transport"`
+	InvalidationPollInterval int    `yaml:"invalidation_poll_interval_ms"`
+	InvalidationListen       string `yaml:"invalidation_listen"`
+	InvalidationPeers        string `yaml:"invalidation_peers"`
+	InvalidationSecret       string `yaml:"invalidation_secret"`
+	EventHistorySize         int    `yaml:"event_history_size"`
+	WebhookMaxAttempts       int    `yaml:"webhook_max_attempts"`
+	WebhookBackoff           int    `yaml:"webhook_backoff_s"`
+	WebhookMaxBackoff        int    `yaml:"webhook_max_backoff_m"`
+	WebhookTimeout           int    `yaml:"webhook_timeout_s"`
+	AuthEnabled              bool   `yaml:"auth_enabled"`
+	AdminApiKey              string `yaml:"admin_api_key"`
+	JwtHs256Secret           string `yaml:"jwt_hs256_secret"`
+	JwtPublicKeyFile         string `yaml:"jwt_public_key_file"`
+	JwtJwksFile              string `yaml:"jwt_jwks_file"`
+	JwtIssuer                string `yaml:"jwt_issuer"`
+	JwtAudience              string `yaml:"jwt_audience"`
+	JwtRolesClaim            string `yaml:"jwt_roles_claim"`
+	JwtLeeway                int    `yaml:"jwt_leeway_s"`
+	JwtRequireExp            bool   `yaml:"jwt_require_exp"`
+	TenantsEnabled           bool   `yaml:"tenants_enabled"`
+	TenantHeader             string `yaml:"tenant_header"`
+	TenantDomain             string `yaml:"tenant_domain"`
+	TenantClaim              string `yaml:"tenant_claim"`
+	RateLimitReadRps         int    `yaml:"rate_limit_read_rps"`
+	RateLimitReadBurst       int    `yaml:"rate_limit_read_burst"`
+	RateLimitWriteRps        int    `yaml:"rate_limit_write_rps"`
+	RateLimitWriteBurst      int    `yaml:"rate_limit_write_burst"`
+	QuotaMaxDocs             int    `yaml:"quota_max_docs"`
+	QuotaMaxBodyBytes        int    `yaml:"quota_max_body_bytes"`
+	IdempotencyTtl           int    `yaml:"idempotency_ttl_h"`
 }
 
 func NewConfig() *Config {
 	return &Config{
-		ApiHost:               getEnv("API_HOST", "0.0.0.0"),
-		APiPort:               getEnv("API_PORT", "8080"),
-		DbHost:                getEnv("DB_HOST", "0.0.0.0"),
-		DbPort:                getEnv("DB_PORT", "6534"),
-		DBname:                getEnv("DB_NAME", "testdb"),
-		CollectionName:        getEnv("COLLECTION_NAME", "documents"),
-		NestingLevel:          func() int { value, _ := strconv.Atoi(getEnv("NESTING_LEVEL", "2")); return value }(),
-		CachelifeTime:         func() int { value, _ := strconv.Atoi(getEnv("CACHE_LIVE_TIME_M", "15")); return value }(),
-		CacheCleaningInterval: func() int { value, _ := strconv.Atoi(getEnv("CACHE_CLEANIN_INTERVAL_M", "15")); return value }(),
+		ApiHost:                  getEnv("API_HOST", "0.0.0.0"),
+		APiPort:                  getEnv("API_PORT", "8080"),
+		DbHost:                   getEnv("DB_HOST", "0.0.0.0"),
+		DbPort:                   getEnv("DB_PORT", "6534"),
+		DBname:                   getEnv("DB_NAME", "testdb"),
+		CollectionName:           getEnv("COLLECTION_NAME", "documents"),
+		NestingLevel:             func() int { value, _ := strconv.Atoi(getEnv("NESTING_LEVEL", "2")); return value }(),
+		CachelifeTime:            func() int { value, _ := strconv.Atoi(getEnv("CACHE_LIVE_TIME_M", "15")); return value }(),
+		CacheCleaningInterval:    func() int { value, _ := strconv.Atoi(getEnv("CACHE_CLEANIN_INTERVAL_M", "15")); return value }(),
+		CacheMaxEntries:          func() int { value, _ := strconv.Atoi(getEnv("CACHE_MAX_ENTRIES", "0")); return value }(),
+		CacheMaxBytes:            func() int { value, _ := strconv.Atoi(getEnv("CACHE_MAX_BYTES", "0")); return value }(),
+		CachePolicy:              getEnv("CACHE_POLICY", "lru"),
+		CacheNegativeTtl:         func() int { value, _ := strconv.Atoi(getEnv("CACHE_NEGATIVE_TTL_S", "5")); return value }(),
+		CacheWarmupRoots:         func() int { value, _ := strconv.Atoi(getEnv("CACHE_WARMUP_ROOTS", "0")); return value }(),
+		CacheWarmupTrees:         func() int { value, _ := strconv.Atoi(getEnv("CACHE_WARMUP_TREES", "0")); return value }(),
+		CacheSnapshotFile:        getEnv("CACHE_SNAPSHOT_FILE", ""),
+		InvalidationTransport:    getEnv("INVALIDATION_TRANSPORT", ""),
+		InvalidationPollInterval: func() int { value, _ := strconv.Atoi(getEnv("INVALIDATION_POLL_INTERVAL_MS", "500")); return value }(),
+		InvalidationListen:       getEnv("INVALIDATION_LISTEN", ":7946"),
+		InvalidationPeers:        getEnv("INVALIDATION_PEERS", ""),
+		InvalidationSecret:       getEnv("INVALIDATION_SECRET", ""),
+		EventHistorySize:         func() int { value, _ := strconv.Atoi(getEnv("EVENT_HISTORY_SIZE", "1000")); return value }(),
+		WebhookMaxAttempts:       func() int { value, _ := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "8")); return value }(),
+		WebhookBackoff:           func() int { value, _ := strconv.Atoi(getEnv("WEBHOOK_BACKOFF_S", "1")); return value }(),
+		WebhookMaxBackoff:        func() int { value, _ := strconv.Atoi(getEnv("WEBHOOK_MAX_BACKOFF_M", "10")); return value }(),
+		WebhookTimeout:           func() int { value, _ := strconv.Atoi(getEnv("WEBHOOK_TIMEOUT_S", "10")); return value }(),
+		AuthEnabled:              func() bool { value, _ := strconv.ParseBool(getEnv("AUTH_ENABLED", "false")); return value }(),
+		AdminApiKey:              getEnv("ADMIN_API_KEY", ""),
+		JwtHs256Secret:           getEnv("JWT_HS256_SECRET", ""),
+		JwtPublicKeyFile:         getEnv("JWT_PUBLIC_KEY_FILE", ""),
+		JwtJwksFile:              getEnv("JWT_JWKS_FILE", ""),
+		JwtIssuer:                getEnv("JWT_ISSUER", ""),
+		JwtAudience:              getEnv("JWT_AUDIENCE", ""),
+		JwtRolesClaim:            getEnv("JWT_ROLES_CLAIM", "roles"),
+		JwtLeeway:                func() int { value, _ := strconv.Atoi(getEnv("JWT_LEEWAY_S", "60")); return value }(),
+		JwtRequireExp:            func() bool { value, _ := strconv.ParseBool(getEnv("JWT_REQUIRE_EXP", "true")); return value }(),
+		TenantsEnabled:           func() bool { value, _ := strconv.ParseBool(getEnv("TENANTS_ENABLED", "false")); return value }(),
+		TenantHeader:             getEnv("TENANT_HEADER", "X-Tenant-Id"),
+		TenantDomain:             getEnv("TENANT_DOMAIN", ""),
+		TenantClaim:              getEnv("TENANT_CLAIM", "tenant"),
+		RateLimitReadRps:         func() int { value, _ := strconv.Atoi(getEnv("RATE_LIMIT_READ_RPS", "0")); return value }(),
+		RateLimitReadBurst:       func() int { value, _ := strconv.Atoi(getEnv("RATE_LIMIT_READ_BURST", "0")); return value }(),
+		RateLimitWriteRps:        func() int { value, _ := strconv.Atoi(getEnv("RATE_LIMIT_WRITE_RPS", "0")); return value }(),
+		RateLimitWriteBurst:      func() int { value, _ := strconv.Atoi(getEnv("RATE_LIMIT_WRITE_BURST", "0")); return value }(),
+		QuotaMaxDocs:             func() int { value, _ := strconv.Atoi(getEnv("QUOTA_MAX_DOCS", "0")); return value }(),
+		QuotaMaxBodyBytes:        func() int { value, _ := strconv.Atoi(getEnv("QUOTA_MAX_BODY_BYTES", "0")); return value }(),
+		IdempotencyTtl:           func() int { value, _ := strconv.Atoi(getEnv("IDEMPOTENCY_TTL_H", "24")); return value }(),
+	}
+}
+
+// Reading the config from the environment and the .env file. If fromFile is set, the fields
+// of the yaml file are added on top of it
+func LoadConfig(fromFile bool, path string) (*Config, error) {
+	godotenv.Load()
+	config := NewConfig()
+	if !fromFile {
+		return config, nil
+	}
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(file, config); err != nil {
+		return nil, err
 	}
+	return config, nil
 }
 
 // Retrieves a variable from the environment
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("COLLECTION_NAME", "from_env")
+	t.Setenv("NESTING_LEVEL", "5")
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := ioutil.WriteFile(path, []byte("collection_name: from_file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(false, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CollectionName != "from_env" || config.NestingLevel != 5 {
+		t.Fatalf("got collection %q and nesting level %d from the environment", config.CollectionName, config.NestingLevel)
+	}
+	config, err = LoadConfig(true, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CollectionName != "from_file" || config.NestingLevel != 5 {
+		t.Fatalf("got collection %q and nesting level %d with the file", config.CollectionName, config.NestingLevel)
+	}
+	if _, err := LoadConfig(true, filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("missing file is not reported")
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/cache"
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+)
+
+// Size of the channel of one subscriber
+const subscriptionBuffer = 256
+
+// Interval of the comments that keep the event stream alive
+const heartbeatInterval = 15 * time.Second
+
+// Maximum number of changes in one response
+const maxChangesLimit = 1000
+
+// Committed changes of the collection under their numbers. The server uses the log of the database,
+// the tests use their own events
+type changeLog interface {
+	Open() error
+	Head() uint64
+	Append(list []*events.Event) error
+	Since(since uint64, limit int) ([]*events.Event, error)
+}
+
+// Turning the actions applied by the saver and the created documents into events. Must be called
+// after the commit of the transaction, so that the cache already holds the new state
+func (server *Server) changeEvents(actions map[int64]map[cache.Action]map[string]interface{}, created []*models.Document) []*events.Event {
+	createdIds := make(map[int64]bool, len(created))
+	for _, doc := range created {
+		createdIds[doc.Id] = true
+	}
+	// Parents of the deleted documents, the cascade of deletion is walked through them
+	deletedParents := map[int64]int64{}
+	for id, action := range actions {
+		if properties, deleted := action[cache.DELETE]; deleted {
+			parentId, _ := properties["ParentId"].(int64)
+			deletedParents[id] = parentId
+		}
+	}
+
+	result := []*events.Event{}
+	for _, doc := range created {
+		result = append(result, &events.Event{
+			Type:      events.CREATE,
+			DocId:     doc.Id,
+			Ancestors: server.ancestors(doc.ParentId, deletedParents),
+			Fields:    documentFields(doc),
+		})
+	}
+
+	updated := []*events.Event{}
+	deleted := []*events.Event{}
+	for id, action := range actions {
+		if _, ok := action[cache.DELETE]; ok {
+			deleted = append(deleted, &events.Event{
+				Type:      events.DELETE,
+				DocId:     id,
+				Ancestors: server.ancestors(deletedParents[id], deletedParents),
+			})
+			continue
+		}
+		if createdIds[id] || action[cache.UPDATE] == nil {
+			continue
+		}
+		doc, found := server.findDoc(id)
+		if !found {
+			continue
+		}
+		updated = append(updated, &events.Event{
+			Type:      events.UPDATE,
+			DocId:     id,
+			Ancestors: server.ancestors(doc.ParentId, deletedParents),
+			Fields:    action[cache.UPDATE],
+		})
+	}
+	sortEvents(updated)
+	sortEvents(deleted)
+	result = append(result, updated...)
+	return append(result, deleted...)
+}
+
+func documentFields(doc *models.Document) map[string]interface{} {
+	fields := map[string]interface{}{}
+	jsonByte, _ := json.Marshal(doc)
+	json.Unmarshal(jsonByte, &fields)
+	return fields
+}
+
+func sortEvents(list []*events.Event) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].DocId < list[j].DocId
+	})
+}
+
+// Collecting the ids from the parent up to the root. Deleted documents are looked up among the deleted parents
+func (server *Server) ancestors(parentId int64, deletedParents map[int64]int64) []int64 {
+	result := []int64{}
+	visited := map[int64]bool{}
+	for parentId != 0 && !visited[parentId] {
+		visited[parentId] = true
+		result = append(result, parentId)
+		if grandParentId, deleted := deletedParents[parentId]; deleted {
+			parentId = grandParentId
+			continue
+		}
+		doc, found := server.findDoc(parentId)
+		if !found {
+			break
+		}
+		parentId = doc.ParentId
+	}
+	return result
+}
+
+// Turning the changes of the commit into events and passing them to the publisher together with the marker
+// of the commit. The returned events get their numbers only when they are written to the change log
+func (server *Server) publishChanges(marker int64, actions map[int64]map[cache.Action]map[string]interface{}, created []*models.Document) []*events.Event {
+	list := server.changeEvents(actions, created)
+	if err := server.publisher.add(marker, list); err != nil {
+		log.Printf("%s, %d changes are waiting", err, server.publisher.backlog())
+	}
+	return list
+}
+
+// Streaming committed changes as server-sent events. The "root" query parameter limits the stream
+// to the subtree of the document
+func (server *Server) streamEvents() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("StreamEvents").Start(ctx.Request.Context(), "Stream events handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		root, err := strconv.ParseInt(ctx.DefaultQuery("root", "0"), 10, 64)
+		if err != nil || root < 0 {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		if root != 0 {
+			if _, found := server.findDoc(root); !found {
+				render(ctx, http.StatusNotFound, gin.H{"error": DocumentNotExist.Error()})
+				return
+			}
+		}
+		principal := server.principal(ctx)
+		sub := server.events.Subscribe(events.SubtreeFilter(root), subscriptionBuffer)
+		defer sub.Close()
+
+		ctx.Header("Content-Type", "text/event-stream")
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Header("Connection", "keep-alive")
+		ctx.Status(http.StatusOK)
+		ctx.Writer.Flush()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Request.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(ctx.Writer, ": ping\n\n")
+			case event, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				if !server.canReadEvent(principal, event) {
+					continue
+				}
+				data, _ := json.Marshal(event)
+				fmt.Fprintf(ctx.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+			}
+			ctx.Writer.Flush()
+		}
+	}
+}
+
+// Returning the committed changes with a number greater than "since" for incremental synchronization.
+// The client repeats the request with "since" equal to "Last" until it reaches "Head"
+func (server *Server) getChanges() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetChanges").Start(ctx.Request.Context(), "Get changes handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		since, err := strconv.ParseUint(ctx.DefaultQuery("since", "0"), 10, 64)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+		if err != nil || limit <= 0 || limit > maxChangesLimit {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		head := server.changes.Head()
+		list, err := server.changes.Since(since, limit)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		// Last counts the hidden changes too, so the client does not read them again
+		last := since
+		if len(list) != 0 {
+			last = list[len(list)-1].Seq
+		}
+		render(ctx, http.StatusOK, gin.H{
+			"Changes": server.readableEvents(server.principal(ctx), list),
+			"Last":    last,
+			"Head":    head,
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/gin-gonic/gin"
+)
+
+func TestEventStream(t *testing.T) {
+	server := newAclServer(t)
+	server.config = &Config{AuthEnabled: true}
+	server.events = events.NewBroker(10)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/events", func(ctx *gin.Context) { ctx.Set(actorKey, alice.Actor) }, server.streamEvents())
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+
+	response, err := http.Get(httpServer.URL + "/events?root=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d and content type %q", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	// The headers are sent after the subscription, so all these events reach the stream
+	server.events.Publish([]*events.Event{
+		{Type: events.UPDATE, DocId: 3, Ancestors: []int64{2, 1}},
+		{Type: events.UPDATE, DocId: 11, Ancestors: []int64{10}},
+		{Type: events.CREATE, DocId: 4, Ancestors: []int64{1}},
+	})
+	// The document 3 is hidden from alice, the document 11 is outside of the subtree
+	reader := bufio.NewReader(response.Body)
+	lines := []string{}
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("got lines %q before the error: %v", lines, err)
+		}
+		if strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "event:") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	if got := strings.Join(lines, " "); got != "id: 3 event: create" {
+		t.Fatalf("got %q, want the creation of the document 4 only", got)
+	}
+}
@@ -3,12 +3,15 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
-	"sort"
 	"strconv"
 	"sync"
 
+	"github.com/EwvwGeN/assignment/internal/acl"
+	"github.com/EwvwGeN/assignment/internal/backup"
 	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/outline"
 	"github.com/EwvwGeN/assignment/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/restream/reindexer/v3"
@@ -31,47 +34,136 @@ func (server *Server) createDoc() gin.HandlerFunc {
 			childs = util.ArrToInt64(jsonData["ChildList"].([]interface{}))
 			newDocument.ChildList = nil
 		}
+		if err := server.checkSchema(newDocument.Body); err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
+			return
+		}
+		// The lock is held until the document is committed, so the count includes the concurrent documents
+		defer server.lockQuota()()
+		if err := server.checkQuota(1, newDocument.Body); err != nil {
+			render(ctx, quotaStatus(err, http.StatusInternalServerError), gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
+			return
+		}
 		// Checking the possibility of using child documents
 		if err := server.checkChild(0, childs); err != nil {
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: Can not add childs: %w", err).Error()})
+			render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: Can not add childs: %w", err).Error()})
+			return
+		}
+		id, err := server.newIds(1)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
-		server.db.Insert(server.config.CollectionName, &newDocument, "id=serial()")
+		newDocument.Id = id
+		if _, err := server.db.Insert(server.config.CollectionName, &newDocument); err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		// The new id may be remembered as missing
+		server.delFromCache(newDocument.Id)
 		// Writing to the json id of the created document
 		jsonData["Id"] = newDocument.Id
 
-		tx, err := server.db.BeginTx(server.config.CollectionName)
+		tx, err := server.beginTx()
 		if err != nil {
+			server.delFromDB(newDocument.Id)
 			ctx.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
-
-		actionSaver := server.cache.NewActionSaver()
+		// The document is inserted outside of the transaction, so it is removed if the transaction fails
+		tx.onRollback(func() {
+			server.delFromDB(newDocument.Id)
+			server.delFromCache(newDocument.Id)
+		})
+		defer tx.Rollback()
+		tx.create(newDocument.Id)
 
 		// Updating child documents of a document
-		if err := server.updateChild(tx, actionSaver.Channel, jsonData); err != nil {
-			ctx.IndentedJSON(http.StatusNotFound, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
+		if err := server.updateChild(server.principal(ctx), tx, jsonData); err != nil {
+			render(ctx, accessStatus(err, http.StatusNotFound), gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
 			return
 		}
 		// Updating the list of child documents
-		server.innerUpdateFields(tx, actionSaver.Channel, newDocument.Id, map[string]interface{}{
+		server.innerUpdateFields(tx, newDocument.Id, map[string]interface{}{
 			"ChildList": childs,
 		})
-		if err != nil {
-			ctx.IndentedJSON(http.StatusNotFound, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
-			return
-		}
+		before := tx.before()
 		if err := tx.Commit(); err != nil {
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
+			render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
 			return
 		}
-		actionSaver.Commit()
 		// Getting the document again to get all the changed fields and upload it to the cache
 		doc, _ := server.findDoc(newDocument.Id)
-		ctx.IndentedJSON(http.StatusCreated, doc)
+		auditChanges(ctx, before, server.publishChanges(tx.marker, tx.actions(), []*models.Document{doc}))
+		render(ctx, http.StatusCreated, doc)
 	})
 }
 
+// Creating all documents of the tree passed in the structure of the big document
+func (server *Server) createBigDoc() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("CreateBigDoc").Start(ctx.Request.Context(), "Create big doc handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		server.db.WithContext(ctx.Request.Context())
+		var bigDocument models.BigDocument
+		data, _ := ioutil.ReadAll(ctx.Request.Body)
+		if err := unmarshalBody(ctx.ContentType(), data, &bigDocument); err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		server.createTree(ctx, &bigDocument)
+	}
+}
+
+// Creating all documents of the tree passed as an OPML or markdown outline
+func (server *Server) importOutline() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("ImportOutline").Start(ctx.Request.Context(), "Import outline handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		server.db.WithContext(ctx.Request.Context())
+		data, _ := ioutil.ReadAll(ctx.Request.Body)
+		var bigDocument *models.BigDocument
+		var err error
+		switch ctx.Query("format") {
+		case "opml":
+			bigDocument, err = outline.FromOPML(data)
+		case "markdown":
+			bigDocument, err = outline.FromMarkdown(data)
+		default:
+			render(ctx, http.StatusBadRequest, gin.H{"error": UnknownFormat.Error()})
+			return
+		}
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("%s: %w", InvalidRequest.Error(), err).Error()})
+			return
+		}
+		server.createTree(ctx, bigDocument)
+	}
+}
+
+// Checking the height of the tree, inserting it and responding with the created big document
+func (server *Server) createTree(ctx *gin.Context, bigDocument *models.BigDocument) {
+	if bigDocHeight(bigDocument) > server.config.NestingLevel {
+		render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", DeplthLevel).Error()})
+		return
+	}
+	if err := server.checkTreeSchema(bigDocument); err != nil {
+		render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
+		return
+	}
+	docs, marker, err := server.insertBigDoc(bigDocument)
+	if err != nil {
+		render(ctx, quotaStatus(err, http.StatusBadRequest), gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
+		return
+	}
+	auditChanges(ctx, nil, server.publishChanges(marker, nil, docs))
+	bigDoc := server.bigDoc(docs[0])
+	server.sortChildren(&bigDoc)
+	render(ctx, http.StatusCreated, bigDoc)
+}
+
 func (server *Server) getAllDocs() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		traceCtx, span_one := otel.Tracer("GetAllDocs").Start(ctx.Request.Context(), "Get all docs handler")
@@ -88,16 +180,26 @@ func (server *Server) getAllDocs() gin.HandlerFunc {
 			ctx.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		query := server.db.Query(server.config.CollectionName)
+		query := backup.Documents(server.db, server.config.CollectionName)
 		if page != 0 {
 			query = query.Limit(limit).Offset((page - 1) * limit)
 		}
+		principal := server.principal(ctx)
 		iterator := query.Exec()
 		defer iterator.Close()
+		docs := []*models.Document{}
 		for iterator.Next() {
 			elem := iterator.Object().(*models.Document)
-			ctx.IndentedJSON(http.StatusOK, elem)
+			if !server.docAccess(principal, elem.Id).Allows(acl.Read) {
+				continue
+			}
+			docs = append(docs, elem)
 		}
+		if err := iterator.Error(); err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		render(ctx, http.StatusOK, docs)
 	}
 }
 
@@ -119,23 +221,30 @@ func (server *Server) getAllBigDocs() gin.HandlerFunc {
 			ctx.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		query := server.db.Query(server.config.CollectionName).Where("ParentId", reindexer.EQ, 0)
+		query := backup.Documents(server.db, server.config.CollectionName).Where("ParentId", reindexer.EQ, 0)
 		if page != 0 {
 			query = query.Limit(limit).Offset((page - 1) * limit)
 		}
 
+		principal := server.principal(ctx)
 		iterator := query.Exec()
 		defer iterator.Close()
+		bigDocs := []models.BigDocument{}
 		for iterator.Next() {
 			elem := iterator.Object().(*models.Document)
-			bigDoc := server.bigDoc(elem)
-			if bigDoc.ChildList != nil {
-				sort.Slice(bigDoc.ChildList, func(i, j int) bool {
-					return bigDoc.ChildList[i].Sort > bigDoc.ChildList[j].Sort
-				})
+			access := server.acl.Inherit(acl.Access{}, principal, elem.Id)
+			if !access.Allows(acl.Read) {
+				continue
 			}
-			ctx.IndentedJSON(http.StatusOK, bigDoc)
+			bigDoc := server.readableTree(principal, server.rootTree(elem), access)
+			server.sortChildren(&bigDoc)
+			bigDocs = append(bigDocs, bigDoc)
 		}
+		if err := iterator.Error(); err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		render(ctx, http.StatusOK, bigDocs)
 	}
 }
 
@@ -146,17 +255,51 @@ func (server *Server) getBigDocById() gin.HandlerFunc {
 		*ctx.Request = *ctx.Request.WithContext(traceCtx)
 		server.db.WithContext(ctx.Request.Context())
 		id := ctx.GetInt64("id")
-		doc, _ := server.findDoc(id)
-		for doc.ParentId != 0 {
-			doc, _ = server.findDoc(doc.ParentId)
+		principal := server.principal(ctx)
+		path := server.docPath(id)
+		if !server.acl.Resolve(principal, path).Allows(acl.Read) {
+			render(ctx, http.StatusForbidden, gin.H{"error": Forbidden.Error()})
+			return
 		}
-		bigDoc := server.bigDoc(doc)
-		if bigDoc.ChildList != nil {
-			sort.Slice(bigDoc.ChildList, func(i, j int) bool {
-				return bigDoc.ChildList[i].Sort > bigDoc.ChildList[j].Sort
-			})
+		// The tree starts from the upper document the client can read
+		access := acl.Access{}
+		top := 0
+		for i, ancestorId := range path {
+			access = server.acl.Inherit(access, principal, ancestorId)
+			if access.Allows(acl.Read) {
+				id = ancestorId
+				top = i
+				break
+			}
+		}
+		var node *models.BigDocument
+		// Only the trees of the real roots are cached, the path may be broken by a concurrent change
+		if root, found := server.findDoc(path[0]); found && root.ParentId == 0 {
+			node = findTreeNode(server.rootTree(root), path[:top+1])
+		}
+		var bigDoc models.BigDocument
+		if node != nil {
+			bigDoc = server.readableTree(principal, node, access)
+		} else {
+			doc, _ := server.findDoc(id)
+			bigDoc = server.readableBigDoc(principal, doc, access)
+		}
+		server.sortChildren(&bigDoc)
+		switch ctx.DefaultQuery("format", "json") {
+		case "json":
+			render(ctx, http.StatusOK, bigDoc)
+		case "opml":
+			data, err := outline.ToOPML(&bigDoc)
+			if err != nil {
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			ctx.Data(http.StatusOK, "text/x-opml; charset=utf-8", data)
+		case "markdown":
+			ctx.Data(http.StatusOK, "text/markdown; charset=utf-8", outline.ToMarkdown(&bigDoc))
+		default:
+			render(ctx, http.StatusBadRequest, gin.H{"error": UnknownFormat.Error()})
 		}
-		ctx.IndentedJSON(http.StatusOK, bigDoc)
 	})
 }
 
@@ -167,8 +310,12 @@ func (server *Server) getDocById() gin.HandlerFunc {
 		*ctx.Request = *ctx.Request.WithContext(traceCtx)
 		server.db.WithContext(ctx.Request.Context())
 		id := ctx.GetInt64("id")
+		if !server.docAccess(server.principal(ctx), id).Allows(acl.Read) {
+			render(ctx, http.StatusForbidden, gin.H{"error": Forbidden.Error()})
+			return
+		}
 		doc, _ := server.findDoc(id)
-		ctx.IndentedJSON(http.StatusOK, doc)
+		render(ctx, http.StatusOK, doc)
 	})
 }
 
@@ -182,26 +329,48 @@ func (server *Server) updateDoc() gin.HandlerFunc {
 		id := ctx.GetInt64("id")
 		jsonData = ctx.GetStringMap("data")
 		jsonData["Id"] = id
+		principal := server.principal(ctx)
+		if !server.docAccess(principal, id).Allows(acl.Write) {
+			render(ctx, http.StatusForbidden, gin.H{"error": Forbidden.Error()})
+			return
+		}
+		if body, exist := jsonData["Body"].(string); exist {
+			if err := server.checkSchema(body); err != nil {
+				render(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err := server.checkQuota(0, body); err != nil {
+				render(ctx, quotaStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+				return
+			}
+		}
 
-		actionSaver := server.cache.NewActionSaver()
-		tx, _ := server.db.BeginTx(server.config.CollectionName)
-		if err := server.updateChild(tx, actionSaver.Channel, jsonData); err != nil {
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		tx, err := server.beginTx()
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		if err := server.updateChild(principal, tx, jsonData); err != nil {
+			render(ctx, accessStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
 			return
 		}
 
-		if err := server.updateDocFields(tx, actionSaver.Channel, id, jsonData); err != nil {
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := server.updateDocFields(tx, id, jsonData); err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		before := tx.before()
 		if err := tx.Commit(); err != nil {
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
+			render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
 			return
 		}
-		actionSaver.Commit()
+		list := server.publishChanges(tx.marker, tx.actions(), nil)
+		server.forgetAccessRules(list)
+		auditChanges(ctx, before, list)
 
-		ctx.IndentedJSON(http.StatusOK, gin.H{"message": "ok"})
+		render(ctx, http.StatusOK, gin.H{"message": "ok"})
 	}))
 }
 
@@ -214,44 +383,50 @@ func (server *Server) deleteDoc() gin.HandlerFunc {
 		var jsonData map[string]interface{}
 		id := ctx.GetInt64("id")
 		jsonData = ctx.GetStringMap("data")
-		actionSaver := server.cache.NewActionSaver()
-		tx, _ := server.db.BeginTx(server.config.CollectionName)
+		if err := server.checkDeleteAccess(server.principal(ctx), id, int64(jsonData["ParentId"].(float64))); err != nil {
+			render(ctx, http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		tx, err := server.beginTx()
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
 		upperWg := new(sync.WaitGroup)
 		upperWg.Add(2)
 		// Start two goroutine to delete the lower documents and update the upper ones
 		go func(upperWg *sync.WaitGroup) {
 			defer upperWg.Done()
-			server.innerDelete(tx, actionSaver.Channel, id)
+			server.innerDelete(tx, id)
 		}(upperWg)
 
 		go func(upperWg *sync.WaitGroup) {
 			defer upperWg.Done()
 			parentId := int64(jsonData["ParentId"].(float64))
 			if parentId != 0 {
-				parentDoc, _ := server.findDoc(parentId)
+				parentDoc, found := tx.findDoc(parentId)
+				if !found {
+					return
+				}
 				// Deleting the current document from child documents of the parent
-				parentChild := func() []int64 {
-					buffer := parentDoc.ChildList
-					for i, value := range buffer {
-						if value == id {
-							return append(buffer[:i], buffer[i+1:]...)
-						}
-					}
-					return nil
-				}()
-				server.innerUpdateFields(tx, actionSaver.Channel, parentId, map[string]interface{}{
+				parentChild := withoutChild(parentDoc.ChildList, id)
+				server.innerUpdateFields(tx, parentId, map[string]interface{}{
 					"ChildList": parentChild,
 				})
-				server.updateDepth(tx, actionSaver.Channel, parentDoc, parentChild)
+				server.updateDepth(tx, parentDoc, parentChild)
 			}
 		}(upperWg)
 		upperWg.Wait()
 
+		before := tx.before()
 		if err := tx.Commit(); err != nil {
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
+			render(ctx, http.StatusBadRequest, gin.H{"error": fmt.Errorf("Can not create file: %w", err).Error()})
 			return
 		}
-		actionSaver.Commit()
-		ctx.IndentedJSON(http.StatusOK, gin.H{"message": "ok"})
+		list := server.publishChanges(tx.marker, tx.actions(), nil)
+		server.forgetAccessRules(list)
+		auditChanges(ctx, before, list)
+		render(ctx, http.StatusOK, gin.H{"message": "ok"})
 	})
 }
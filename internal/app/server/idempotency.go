@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/EwvwGeN/assignment/internal/idempotency"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+	maxIdempotencyKey = 255
+	// Attempts of saving the response before the key is left reserved
+	completeAttempts = 3
+)
+
+// Saved responses of the requests. The server uses the store of the database, the tests use their own records
+type idempotencyStore interface {
+	Open() error
+	Reserve(key, fingerprint string) (*models.IdempotencyRecord, error)
+	Hold(key string) func()
+	Complete(key, fingerprint string, status int, contentType string, body []byte) error
+	Release(key string) error
+}
+
+// Writer keeping a copy of the response to save it for the repeats
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (writer *recordingWriter) Write(data []byte) (int, error) {
+	writer.body.Write(data)
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *recordingWriter) WriteString(data string) (int, error) {
+	writer.body.WriteString(data)
+	return writer.ResponseWriter.WriteString(data)
+}
+
+// Running the next handlers once for every Idempotency-Key of the client. The first response is saved
+// and replayed for the repeats, a repeat with another method, path, query, content type or body is rejected.
+// Requests without the header are not changed
+func (server *Server) idempotent() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(idempotencyHeader)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		traceCtx, span_one := otel.Tracer("Idempotency").Start(ctx.Request.Context(), "Idempotency middleware")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		if len(key) > maxIdempotencyKey {
+			ctx.Abort()
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidIdempotency.Error()})
+			return
+		}
+		data, err := ioutil.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.Abort()
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(data))
+		// The keys of different clients and endpoints do not intersect
+		client := ctx.GetString(actorKey)
+		if client == "" {
+			client = "ip:" + ctx.ClientIP()
+		}
+		scopedKey := idempotency.Hash([]byte(client), []byte(ctx.FullPath()), []byte(key))
+		fingerprint := idempotency.Hash(
+			[]byte(ctx.Request.Method),
+			[]byte(ctx.Request.URL.Path),
+			[]byte(ctx.Request.URL.RawQuery),
+			[]byte(ctx.ContentType()),
+			data,
+		)
+		record, err := server.idempotency.Reserve(scopedKey, fingerprint)
+		switch {
+		case errors.Is(err, idempotency.KeyReused):
+			ctx.Abort()
+			render(ctx, http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, idempotency.InProgress):
+			ctx.Header("Retry-After", "1")
+			ctx.Abort()
+			render(ctx, http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if record != nil {
+			ctx.Header(replayedHeader, "true")
+			ctx.Abort()
+			ctx.Data(record.Status, record.ContentType, []byte(record.Body))
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		server.runHeld(ctx, scopedKey)
+		ctx.Writer = writer.ResponseWriter
+		// Failed requests may succeed later, so they are not saved
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := server.idempotency.Release(scopedKey); err != nil {
+				log.Printf("Can not release the idempotency key: %s", err)
+			}
+			return
+		}
+		for attempt := 1; ; attempt++ {
+			err := server.idempotency.Complete(scopedKey, fingerprint, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes())
+			if err == nil {
+				return
+			}
+			// The key stays reserved until the lock timeout, so the repeats get 409 instead of running again
+			if attempt == completeAttempts {
+				log.Printf("Can not save the response of the idempotent request: %s", err)
+				return
+			}
+		}
+	}
+}
+
+// Running the next handlers while the key is held. A panicking handler releases the key,
+// so the request can be repeated, and the panic goes on to the recovery middleware
+func (server *Server) runHeld(ctx *gin.Context, key string) {
+	stopHold := server.idempotency.Hold(key)
+	defer stopHold()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if err := server.idempotency.Release(key); err != nil {
+				log.Printf("Can not release the idempotency key: %s", err)
+			}
+			panic(recovered)
+		}
+	}()
+	ctx.Next()
+}
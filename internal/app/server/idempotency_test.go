@@ -0,0 +1,195 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/idempotency"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Records kept in memory with the checks of the store of the database
+type memoryRecords struct {
+	records      map[string]*models.IdempotencyRecord
+	completeFail int
+	holding      int
+	held         int
+}
+
+func (store *memoryRecords) Open() error { return nil }
+
+func (store *memoryRecords) Reserve(key, fingerprint string) (*models.IdempotencyRecord, error) {
+	record, exist := store.records[key]
+	if !exist {
+		store.records[key] = &models.IdempotencyRecord{Key: key, Fingerprint: fingerprint}
+		return nil, nil
+	}
+	if record.Fingerprint != fingerprint {
+		return nil, idempotency.KeyReused
+	}
+	if !record.Completed {
+		return nil, idempotency.InProgress
+	}
+	return record, nil
+}
+
+func (store *memoryRecords) Hold(key string) func() {
+	store.holding++
+	return func() {
+		store.holding--
+		store.held++
+	}
+}
+
+func (store *memoryRecords) Complete(key, fingerprint string, status int, contentType string, body []byte) error {
+	if store.completeFail > 0 {
+		store.completeFail--
+		return errors.New("store is unavailable")
+	}
+	store.records[key] = &models.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		Completed:   true,
+		Status:      status,
+		ContentType: contentType,
+		Body:        string(body),
+	}
+	return nil
+}
+
+func (store *memoryRecords) Release(key string) error {
+	delete(store.records, key)
+	return nil
+}
+
+// Router counting the runs of the handler, the handler answers with the status from the query
+func newIdempotentRouter(store *memoryRecords) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	server := &Server{idempotency: store}
+	runs := 0
+	router := gin.New()
+	router.Use(gin.RecoveryWithWriter(io.Discard))
+	router.POST("/docs", server.idempotent(), func(ctx *gin.Context) {
+		runs++
+		if ctx.Query("panic") != "" {
+			panic("handler failed")
+		}
+		if store.holding != 1 {
+			ctx.String(http.StatusInternalServerError, "key is not held")
+			return
+		}
+		status, err := strconv.Atoi(ctx.DefaultQuery("status", "201"))
+		if err != nil {
+			status = http.StatusCreated
+		}
+		ctx.String(status, "run %d", runs)
+	})
+	return router, &runs
+}
+
+func idempotentRequest(router *gin.Engine, target, contentType, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	request.RemoteAddr = "192.0.2.1:1234"
+	request.Header.Set(idempotencyHeader, "job-42")
+	request.Header.Set("Content-Type", contentType)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	store := &memoryRecords{records: map[string]*models.IdempotencyRecord{}}
+	router, runs := newIdempotentRouter(store)
+	first := idempotentRequest(router, "/docs", "application/json", `{"Body":"a"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request got status %d: %s", first.Code, first.Body.String())
+	}
+	repeat := idempotentRequest(router, "/docs", "application/json", `{"Body":"a"}`)
+	if repeat.Code != http.StatusCreated || repeat.Body.String() != "run 1" || repeat.Header().Get(replayedHeader) != "true" {
+		t.Fatalf("repeat got status %d, body %q and replayed header %q", repeat.Code, repeat.Body.String(), repeat.Header().Get(replayedHeader))
+	}
+	if *runs != 1 || store.held != 1 || store.holding != 0 {
+		t.Fatalf("handler runs %d times, the key is held %d times and %d holds are not stopped", *runs, store.held, store.holding)
+	}
+}
+
+func TestIdempotencyFingerprint(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+	}{
+		{"other body", "/docs", "application/json", `{"Body":"b"}`},
+		{"other query", "/docs?format=opml", "application/json", `{"Body":"a"}`},
+		{"other content type", "/docs", "text/x-opml", `{"Body":"a"}`},
+	}
+	for _, test := range tests {
+		store := &memoryRecords{records: map[string]*models.IdempotencyRecord{}}
+		router, runs := newIdempotentRouter(store)
+		idempotentRequest(router, "/docs", "application/json", `{"Body":"a"}`)
+		if recorder := idempotentRequest(router, test.target, test.contentType, test.body); recorder.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d", test.name, recorder.Code)
+		}
+		if *runs != 1 {
+			t.Errorf("%s: handler runs %d times", test.name, *runs)
+		}
+	}
+}
+
+func TestIdempotencyOfFailedRequests(t *testing.T) {
+	store := &memoryRecords{records: map[string]*models.IdempotencyRecord{}}
+	router, runs := newIdempotentRouter(store)
+	// The server error is not saved, the request is run again
+	idempotentRequest(router, "/docs?status=503", "application/json", "{}")
+	if len(store.records) != 0 {
+		t.Fatal("key of the failed request is not released")
+	}
+	idempotentRequest(router, "/docs?status=503", "application/json", "{}")
+	if *runs != 2 {
+		t.Fatalf("handler runs %d times after the server errors", *runs)
+	}
+
+	// The response is saved after the failed attempts
+	store.completeFail = completeAttempts - 1
+	idempotentRequest(router, "/docs", "application/json", "{}")
+	if record := store.records[firstKey(store)]; record == nil || !record.Completed {
+		t.Fatal("response is not saved after the failed attempts")
+	}
+
+	// The key stays reserved when the response can not be saved
+	store.records = map[string]*models.IdempotencyRecord{}
+	store.completeFail = completeAttempts
+	idempotentRequest(router, "/docs", "application/json", "{}")
+	if recorder := idempotentRequest(router, "/docs", "application/json", "{}"); recorder.Code != http.StatusConflict {
+		t.Fatalf("repeat of the request with the unsaved response got status %d", recorder.Code)
+	}
+}
+
+func firstKey(store *memoryRecords) string {
+	for key := range store.records {
+		return key
+	}
+	return ""
+}
+
+func TestIdempotencyOfPanickingHandler(t *testing.T) {
+	store := &memoryRecords{records: map[string]*models.IdempotencyRecord{}}
+	router, runs := newIdempotentRouter(store)
+	if recorder := idempotentRequest(router, "/docs?panic=1", "application/json", "{}"); recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking handler got status %d", recorder.Code)
+	}
+	if store.holding != 0 || len(store.records) != 0 {
+		t.Fatalf("%d holds are not stopped and %d keys are reserved after the panic", store.holding, len(store.records))
+	}
+	idempotentRequest(router, "/docs?panic=1", "application/json", "{}")
+	if *runs != 2 {
+		t.Fatalf("handler runs %d times, the repeat after the panic is blocked", *runs)
+	}
+}
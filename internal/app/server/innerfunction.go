@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"sync"
 
+	"github.com/EwvwGeN/assignment/internal/acl"
+	"github.com/EwvwGeN/assignment/internal/backup"
 	"github.com/EwvwGeN/assignment/internal/cache"
 	"github.com/EwvwGeN/assignment/internal/models"
 	"github.com/EwvwGeN/assignment/internal/util"
@@ -13,16 +15,22 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-func (server *Server) updateChild(tx *reindexer.Tx, channel chan *cache.ActionProperties, jsonData map[string]interface{}) error {
+func (server *Server) updateChild(principal *acl.Principal, tx *docTx, jsonData map[string]interface{}) error {
 	if jsonData["ChildList"] == nil {
 		return nil
 	}
 	id := jsonData["Id"].(int64)
-	doc, _ := server.findDoc(id)
+	doc, found := tx.findDoc(id)
+	if !found {
+		return fmt.Errorf("%s: File Id:%d", DocumentNotExist.Error(), id)
+	}
 	docChilds := doc.ChildList
 	inputChilds := util.ArrToInt64(jsonData["ChildList"].([]interface{}))
 	// Splitting the list of child documents into a list for deletion and addition
 	delChilds, addChilds := util.Difference(docChilds, inputChilds)
+	if err := server.checkChildAccess(principal, id, delChilds, addChilds); err != nil {
+		return err
+	}
 	// Сhecking new documents for the possibility to add them
 	if err := server.checkChild(id, addChilds); err != nil {
 		return fmt.Errorf("Can not add childs: %w", err)
@@ -32,7 +40,7 @@ func (server *Server) updateChild(tx *reindexer.Tx, channel chan *cache.ActionPr
 	firstWg.Add(len(delChilds))
 	for _, childId := range delChilds {
 		go func(wg *sync.WaitGroup, childId int64) {
-			server.innerDelete(tx, channel, childId)
+			server.innerDelete(tx, childId)
 			wg.Done()
 		}(firstWg, childId)
 	}
@@ -42,13 +50,13 @@ func (server *Server) updateChild(tx *reindexer.Tx, channel chan *cache.ActionPr
 	secondWg.Add(2)
 	go func(wg *sync.WaitGroup) {
 		defer wg.Done()
-		server.updateDepth(tx, channel, doc, inputChilds)
+		server.updateDepth(tx, doc, inputChilds)
 	}(secondWg)
 
 	go func(wg *sync.WaitGroup) {
 		defer wg.Done()
 		for _, v := range addChilds {
-			server.innerUpdateFields(tx, channel, v, map[string]interface{}{
+			server.innerUpdateFields(tx, v, map[string]interface{}{
 				"ParentId": id,
 			})
 		}
@@ -58,6 +66,28 @@ func (server *Server) updateChild(tx *reindexer.Tx, channel chan *cache.ActionPr
 	return nil
 }
 
+// Removed children are deleted with their subtrees, added ones are moved from their own trees
+func (server *Server) checkChildAccess(principal *acl.Principal, id int64, delChilds, addChilds []int64) error {
+	if principal == nil {
+		return nil
+	}
+	access := server.docAccess(principal, id)
+	if !access.Allows(acl.Write) {
+		return fmt.Errorf("%w: File Id:%d", Forbidden, id)
+	}
+	for _, childId := range delChilds {
+		if err := server.checkSubtree(principal, childId, server.acl.Inherit(access, principal, childId), acl.Delete); err != nil {
+			return err
+		}
+	}
+	for _, childId := range addChilds {
+		if !server.docAccess(principal, childId).Allows(acl.Write) {
+			return fmt.Errorf("%w: File Id:%d", Forbidden, childId)
+		}
+	}
+	return nil
+}
+
 func (server *Server) checkChild(id int64, child []int64) error {
 	height, err := server.getDocHeight(id)
 	if err != nil {
@@ -111,22 +141,21 @@ func (server *Server) getDocHeight(id int64) (interface{}, error) {
 	return currentHight, nil
 }
 
-func (server *Server) innerDelete(tx *reindexer.Tx, channel chan *cache.ActionProperties, id int64) {
-	doc, _ := server.txGetFromDB(tx, id)
-	if doc.ChildList != nil {
-		for _, value := range doc.ChildList {
-			server.innerDelete(tx, channel, value)
-		}
+func (server *Server) innerDelete(tx *docTx, id int64) {
+	doc, found := tx.findDoc(id)
+	if !found {
+		return
 	}
-	server.txDelFromDB(tx, id)
-	channel <- &cache.ActionProperties{
-		DocId:  id,
-		Action: cache.DELETE,
+	for _, value := range doc.ChildList {
+		server.innerDelete(tx, value)
 	}
-}
-
-func (server *Server) delFromCache(id int64) {
-	server.cache.DelDoc(id)
+	server.txDelFromDB(tx.tx, id)
+	tx.stage(&cache.ActionProperties{
+		DocId:    id,
+		Action:   cache.DELETE,
+		Field:    "ParentId",
+		NewValue: doc.ParentId,
+	})
 }
 
 func (server *Server) txDelFromDB(tx *reindexer.Tx, id int64) {
@@ -137,31 +166,18 @@ func (server *Server) delFromDB(id int64) {
 	server.db.Query(server.config.CollectionName).Where("id", reindexer.EQ, id).Delete()
 }
 
+// Concurrent requests for the same missing document make one request to the database
 func (server *Server) findDoc(id int64) (*models.Document, bool) {
-	doc := server.getFromCache(id)
-	if doc != nil {
-		return doc, true
-	}
-	doc, found := server.getFromBD(id)
-	if found {
-		server.cache.AddDoc(doc)
-	}
-	return doc, found
-}
-
-func (server *Server) getFromCache(id int64) *models.Document {
-	return server.cache.GetDoc(id)
-}
-
-func (server *Server) txGetFromDB(tx *reindexer.Tx, id int64) (*models.Document, bool) {
-	doc, found := tx.Query().WhereInt64("id", reindexer.EQ, id).Get()
-	if !found {
-		return nil, found
-	}
-	return doc.(*models.Document), found
+	return server.cache.Load(id, func() (*models.Document, bool) {
+		return server.getFromBD(id)
+	})
 }
 
 func (server *Server) getFromBD(id int64) (*models.Document, bool) {
+	// The records with negative ids are the change markers
+	if id <= 0 {
+		return nil, false
+	}
 	query := server.db.Query(server.config.CollectionName).Where("id", reindexer.EQ, id)
 	doc, found := query.Get()
 	if !found {
@@ -171,20 +187,158 @@ func (server *Server) getFromBD(id int64) (*models.Document, bool) {
 }
 
 func (server *Server) bigDoc(input interface{}) models.BigDocument {
+	return server.readableBigDoc(nil, input.(*models.Document), acl.Access{})
+}
+
+// Building the big document without the children the principal can not read. The access is the one
+// of the document itself
+func (server *Server) readableBigDoc(principal *acl.Principal, item *models.Document, access acl.Access) models.BigDocument {
 	var bigDoc models.BigDocument
-	item := input.(*models.Document)
 	ChildList := item.ChildList
 	buffer, _ := json.Marshal(item)
 	json.Unmarshal(buffer, &bigDoc)
 	bigDoc.ChildList = nil
 	for _, childId := range ChildList {
+		childAccess := server.acl.Inherit(access, principal, childId)
+		if !childAccess.Allows(acl.Read) {
+			continue
+		}
 		childDoc, _ := server.findDoc(childId)
-		bigDoc.ChildList = append(bigDoc.ChildList, server.bigDoc(childDoc))
+		bigDoc.ChildList = append(bigDoc.ChildList, server.readableBigDoc(principal, childDoc, childAccess))
+	}
+	return bigDoc
+}
+
+// Full tree of the root document from the cache, it is assembled and cached on a miss.
+// The tree is shared and must not be changed
+func (server *Server) rootTree(root *models.Document) *models.BigDocument {
+	if tree := server.cache.GetTree(root.Id); tree != nil {
+		return tree
+	}
+	generation := server.cache.TreeGeneration(root.Id)
+	tree := server.bigDoc(root)
+	server.cache.AddTree(&tree, generation)
+	return &tree
+}
+
+// Copying the node of the cached tree without the children the principal can not read.
+// The access is the one of the node itself
+func (server *Server) readableTree(principal *acl.Principal, node *models.BigDocument, access acl.Access) models.BigDocument {
+	bigDoc := *node
+	bigDoc.ChildList = nil
+	for i := range node.ChildList {
+		child := &node.ChildList[i]
+		childAccess := server.acl.Inherit(access, principal, child.Id)
+		if !childAccess.Allows(acl.Read) {
+			continue
+		}
+		bigDoc.ChildList = append(bigDoc.ChildList, server.readableTree(principal, child, childAccess))
 	}
 	return bigDoc
 }
 
-func (server *Server) updateDepth(tx *reindexer.Tx, channel chan *cache.ActionProperties, document *models.Document, newChilds []int64) {
+// Finding the node of the document in the tree by the path from the root to it
+func findTreeNode(tree *models.BigDocument, path []int64) *models.BigDocument {
+	node := tree
+	for _, id := range path[1:] {
+		var next *models.BigDocument
+		for i := range node.ChildList {
+			if node.ChildList[i].Id == id {
+				next = &node.ChildList[i]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// Returns the height of the tree: leaves have zero height, like the Depth field of the document
+func bigDocHeight(bigDoc *models.BigDocument) int {
+	height := 0
+	for i := range bigDoc.ChildList {
+		if childHeight := bigDocHeight(&bigDoc.ChildList[i]) + 1; childHeight > height {
+			height = childHeight
+		}
+	}
+	return height
+}
+
+// Inserting every node of the tree in one transaction. The ids are reserved before the transaction,
+// ids of the input tree are ignored. The tree is checked against the storage quotas.
+// Returns the created documents, the root is the first one, and the marker of the change
+func (server *Server) insertBigDoc(bigDoc *models.BigDocument) ([]*models.Document, int64, error) {
+	defer server.lockQuota()()
+	bodies := treeBodies(bigDoc)
+	if err := server.checkQuota(len(bodies), bodies...); err != nil {
+		return nil, 0, err
+	}
+	first, err := server.newIds(len(bodies))
+	if err != nil {
+		return nil, 0, err
+	}
+	docs := treeDocs(bigDoc, first)
+	ids := make([]int64, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.Id)
+	}
+
+	tx, err := server.db.BeginTx(server.config.CollectionName)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, doc := range docs {
+		if err := tx.Insert(doc); err != nil {
+			tx.Rollback()
+			return nil, 0, err
+		}
+	}
+	marker, err := server.markChange(tx, nil, ids)
+	if err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	// The new ids may be remembered as missing
+	server.delFromCache(ids...)
+	return docs, marker, nil
+}
+
+// Documents of the nodes of the tree with the ids from the first one in the order of the nodes
+// from the root, the root is the first one
+func treeDocs(bigDoc *models.BigDocument, first int64) []*models.Document {
+	docs := []*models.Document{}
+	var addNode func(node *models.BigDocument, parentId int64) int64
+	addNode = func(node *models.BigDocument, parentId int64) int64 {
+		doc := &models.Document{
+			Id:        first + int64(len(docs)),
+			ParentId:  parentId,
+			Depth:     bigDocHeight(node),
+			Sort:      node.Sort,
+			Body:      node.Body,
+			ChildList: []int64{},
+		}
+		docs = append(docs, doc)
+		for i := range node.ChildList {
+			doc.ChildList = append(doc.ChildList, addNode(&node.ChildList[i], doc.Id))
+		}
+		return doc.Id
+	}
+	addNode(bigDoc, 0)
+	return docs
+}
+
+// Reserving the ids of the new documents, returns the first of count ids following each other
+func (server *Server) newIds(count int) (int64, error) {
+	return server.counters.Reserve(backup.IdCounter, count)
+}
+
+func (server *Server) updateDepth(tx *docTx, document *models.Document, newChilds []int64) {
 	doc := document
 	id := doc.Id
 	childs := newChilds
@@ -193,7 +347,7 @@ func (server *Server) updateDepth(tx *reindexer.Tx, channel chan *cache.ActionPr
 	maxChildDepth := -1
 	for id != 0 {
 		if len(childs) != 0 {
-			query := tx.Query().WhereInt64("id", reindexer.EQ, childs...)
+			query := tx.tx.Query().WhereInt64("id", reindexer.EQ, childs...)
 			query.AggregateMax("Depth")
 			iterator := query.Exec()
 			if len(iterator.AggResults()) != 0 {
@@ -207,31 +361,23 @@ func (server *Server) updateDepth(tx *reindexer.Tx, channel chan *cache.ActionPr
 		if maxChildDepth+1 == depth {
 			break
 		}
-		server.innerUpdateFields(tx, channel, id, map[string]interface{}{
+		server.innerUpdateFields(tx, id, map[string]interface{}{
 			"Depth": maxChildDepth + 1,
 		})
 		previousDepth = maxChildDepth + 1
 		processedСhild := id
 		id = doc.ParentId
-		parentDoc, found := server.txGetFromDB(tx, id)
+		parentDoc, found := tx.findDoc(id)
 		if !found {
 			break
 		}
 		doc = parentDoc
-		childs = func() []int64 {
-			buffer := doc.ChildList
-			for i, value := range buffer {
-				if value == processedСhild {
-					return append(buffer[:i], buffer[i+1:]...)
-				}
-			}
-			return nil
-		}()
+		childs = withoutChild(doc.ChildList, processedСhild)
 		depth = doc.Depth
 	}
 }
 
-func (server *Server) updateDocFields(tx *reindexer.Tx, channel chan *cache.ActionProperties, id int64, jsonData map[string]interface{}) error {
+func (server *Server) updateDocFields(tx *docTx, id int64, jsonData map[string]interface{}) error {
 	changedFields := make(map[string]interface{})
 	var document models.AllowedField
 	types := reflect.TypeOf(document)
@@ -241,24 +387,36 @@ func (server *Server) updateDocFields(tx *reindexer.Tx, channel chan *cache.Acti
 			changedFields[field.Name] = value
 		}
 	}
-	return server.innerUpdateFields(tx, channel, id, changedFields)
+	return server.innerUpdateFields(tx, id, changedFields)
 }
 
-// Updating document fields in a transaction and adding action to the saver for future cache update
-func (server *Server) innerUpdateFields(tx *reindexer.Tx, channel chan *cache.ActionProperties, id int64, jsonData map[string]interface{}) error {
+// Updating document fields in a transaction and staging the action for future cache update
+func (server *Server) innerUpdateFields(tx *docTx, id int64, jsonData map[string]interface{}) error {
 	var document models.Document
-	query := tx.Query().WhereInt64("id", reindexer.EQ, id)
+	query := tx.tx.Query().WhereInt64("id", reindexer.EQ, id)
 	types := reflect.TypeOf(document)
 	for key, value := range jsonData {
 		field, _ := types.FieldByName(key)
 		query.Set(field.Name, value)
-		channel <- &cache.ActionProperties{
+		tx.stage(&cache.ActionProperties{
 			DocId:    id,
 			Action:   cache.UPDATE,
 			Field:    field.Name,
 			NewValue: value,
-		}
+		})
 	}
 	query.Update()
 	return nil
 }
+
+// Copy of the child list without the child, so the list of the document is not changed
+func withoutChild(childs []int64, id int64) []int64 {
+	for i, value := range childs {
+		if value == id {
+			buffer := make([]int64, 0, len(childs)-1)
+			buffer = append(buffer, childs[:i]...)
+			return append(buffer, childs[i+1:]...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+func TestTreeDocs(t *testing.T) {
+	tree := &models.BigDocument{
+		Id:   7,
+		Body: "root",
+		ChildList: []models.BigDocument{
+			{Id: 7, Body: "first", Sort: 2, ChildList: []models.BigDocument{{Body: "nested"}}},
+			{Body: "second", Sort: 1},
+		},
+	}
+	docs := treeDocs(tree, 100)
+	want := []models.Document{
+		{Id: 100, ParentId: 0, Depth: 2, Body: "root", ChildList: []int64{101, 103}},
+		{Id: 101, ParentId: 100, Depth: 1, Sort: 2, Body: "first", ChildList: []int64{102}},
+		{Id: 102, ParentId: 101, Depth: 0, Body: "nested", ChildList: []int64{}},
+		{Id: 103, ParentId: 100, Depth: 0, Sort: 1, Body: "second", ChildList: []int64{}},
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("got %d documents, want %d", len(docs), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(*docs[i], want[i]) {
+			t.Errorf("document %d is %+v, want %+v", i, *docs[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/invalidation"
+)
+
+// Transports of the cache invalidation bus
+const (
+	invalidationReindexer = "reindexer"
+	invalidationUdp       = "udp"
+)
+
+// Creating the bus of the configured transport. Returns nil if the replicas do not share the invalidations
+func (server *Server) newInvalidationBus() (*invalidation.Bus, error) {
+	switch server.config.InvalidationTransport {
+	case "":
+		return nil, nil
+	case invalidationReindexer:
+		transport, err := invalidation.NewReindexer(server.db, server.config.CollectionName,
+			time.Duration(server.config.InvalidationPollInterval)*time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		return invalidation.NewBus(transport), nil
+	case invalidationUdp:
+		peers := []string{}
+		for _, peer := range strings.Split(server.config.InvalidationPeers, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				peers = append(peers, peer)
+			}
+		}
+		transport, err := invalidation.NewUDP(server.config.InvalidationListen, peers, server.config.InvalidationSecret)
+		if err != nil {
+			return nil, err
+		}
+		return invalidation.NewBus(transport), nil
+	}
+	return nil, fmt.Errorf("Unknown invalidation transport: %s", server.config.InvalidationTransport)
+}
+
+// Removing the documents changed by the other replicas from the cache and sending them the documents
+// changed by the commits of this one
+func (server *Server) subscribeInvalidations() {
+	if server.bus == nil {
+		return
+	}
+	collection := server.config.CollectionName
+	server.bus.Subscribe(collection, func(message invalidation.Message) {
+		if message.Rules {
+			if err := server.acl.Reload(message.Ids); err != nil {
+				log.Printf("Can not reload access rules changed by another replica: %s", err)
+			}
+			return
+		}
+		if message.Flush {
+			server.cache.Flush()
+			return
+		}
+		for _, id := range message.Ids {
+			server.cache.DelDoc(id)
+		}
+	})
+	server.cache.OnCommit(func(ids []int64) {
+		server.bus.Publish(collection, ids)
+	})
+}
+
+// Stopping the cache of the collection that is no longer used
+func (server *Server) closeCollection() {
+	server.cache.Close()
+	if server.bus != nil {
+		server.bus.Unsubscribe(server.config.CollectionName)
+	}
+}
+
+// Removing the documents from the caches of this and the other replicas
+func (server *Server) delFromCache(ids ...int64) {
+	for _, id := range ids {
+		server.cache.DelDoc(id)
+	}
+	server.publishInvalidation(ids)
+}
+
+// Removing the documents from the caches of the other replicas only
+func (server *Server) publishInvalidation(ids []int64) {
+	if server.bus != nil {
+		server.bus.Publish(server.config.CollectionName, ids)
+	}
+}
+
+// Asking the other replicas to read the changed access rules
+func (server *Server) publishRules(ids []int64) {
+	if server.bus != nil {
+		server.bus.PublishRules(server.config.CollectionName, ids)
+	}
+}
+
+// Dropping the caches of the collection on this and the other replicas
+func (server *Server) flushCaches() {
+	server.cache.Flush()
+	if server.bus != nil {
+		server.bus.PublishFlush(server.config.CollectionName)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/EwvwGeN/assignment/internal/backup"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// Creating the limiter for the rate in requests per second. Returns nil if the rate is not positive.
+// The burst defaults to the rate
+func newLimiter(rate, burst int) *ratelimit.Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return ratelimit.NewLimiter(float64(rate), burst)
+}
+
+// Limiting the requests of every client separately for reads and writes. The client is identified
+// by its actor or, without authentication, by its address. Every tenant has its own limiters,
+// so the requests to one tenant do not use the tokens of another one
+func (server *Server) rateLimit() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limited := server.requestServer(ctx)
+		limiter := limited.writeLimiter
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			limiter = limited.readLimiter
+		}
+		if limiter == nil {
+			ctx.Next()
+			return
+		}
+		key := ctx.GetString(actorKey)
+		if key == "" {
+			key = "ip:" + ctx.ClientIP()
+		}
+		if allowed, wait := limiter.Allow(key); !allowed {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			ctx.Abort()
+			render(ctx, http.StatusTooManyRequests, gin.H{"error": TooManyRequests.Error()})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// Serializing the writes that add documents while the number of the documents is limited. The returned
+// function must be called after the documents are committed, so the count of checkQuota always includes
+// the documents of the concurrent writes. The replicas of the server do not share the lock
+func (server *Server) lockQuota() func() {
+	if server.config.QuotaMaxDocs <= 0 {
+		return func() {}
+	}
+	server.quotaLock.Lock()
+	return server.quotaLock.Unlock
+}
+
+// Checking the storage quotas before writing the documents: the size of every body and the number
+// of the documents after adding the new ones. The writes adding documents must hold lockQuota
+func (server *Server) checkQuota(newDocs int, bodies ...string) error {
+	if limit := server.config.QuotaMaxBodyBytes; limit > 0 {
+		for _, body := range bodies {
+			if len(body) > limit {
+				return fmt.Errorf("%w: %d bytes", BodyTooLarge, limit)
+			}
+		}
+	}
+	if limit := server.config.QuotaMaxDocs; limit > 0 && newDocs > 0 {
+		iterator := backup.Documents(server.db, server.config.CollectionName).Limit(0).ReqTotal().Exec()
+		total := iterator.TotalCount()
+		err := iterator.Error()
+		iterator.Close()
+		if err != nil {
+			return err
+		}
+		if total+newDocs > limit {
+			return fmt.Errorf("%w: %d documents", QuotaExceeded, limit)
+		}
+	}
+	return nil
+}
+
+// Status of the response for an error of the quota check or the status for the other errors
+func quotaStatus(err error, status int) int {
+	switch {
+	case errors.Is(err, BodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, QuotaExceeded):
+		return http.StatusInsufficientStorage
+	}
+	return status
+}
+
+// Bodies of all nodes of the tree
+func treeBodies(bigDoc *models.BigDocument) []string {
+	bodies := []string{bigDoc.Body}
+	for i := range bigDoc.ChildList {
+		bodies = append(bodies, treeBodies(&bigDoc.ChildList[i])...)
+	}
+	return bodies
+}
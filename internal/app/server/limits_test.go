@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Router limiting the requests of the server and of the tenant chosen by the header
+func newLimitedRouter(server *Server, tenants map[string]*Server) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		if tenant, exist := tenants[ctx.GetHeader("X-Tenant-Id")]; exist {
+			ctx.Set(tenantKey, tenant)
+		}
+	}, server.rateLimit())
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	router.GET("/docs", ok)
+	router.POST("/docs", ok)
+	return router
+}
+
+func limitedRequest(router *gin.Engine, method, tenant string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/docs", nil)
+	request.RemoteAddr = "192.0.2.1:1234"
+	if tenant != "" {
+		request.Header.Set("X-Tenant-Id", tenant)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	server := &Server{
+		readLimiter:  newLimiter(1, 2),
+		writeLimiter: newLimiter(1, 1),
+	}
+	router := newLimitedRouter(server, nil)
+	for i := 0; i < 2; i++ {
+		if recorder := limitedRequest(router, http.MethodGet, ""); recorder.Code != http.StatusOK {
+			t.Fatalf("read %d of the burst got status %d", i+1, recorder.Code)
+		}
+	}
+	recorder := limitedRequest(router, http.MethodGet, "")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("read over the burst got status %d", recorder.Code)
+	}
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Fatalf("got Retry-After %q, want 1", retryAfter)
+	}
+	// Writes have their own bucket
+	if recorder := limitedRequest(router, http.MethodPost, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("write after the reads got status %d", recorder.Code)
+	}
+}
+
+func TestRateLimitOfTenants(t *testing.T) {
+	server := &Server{writeLimiter: newLimiter(1, 1)}
+	tenants := map[string]*Server{
+		"acme":    {writeLimiter: newLimiter(1, 1)},
+		"initech": {},
+	}
+	router := newLimitedRouter(server, tenants)
+	for _, tenant := range []string{"", "acme"} {
+		if recorder := limitedRequest(router, http.MethodPost, tenant); recorder.Code != http.StatusOK {
+			t.Fatalf("first write to %q got status %d", tenant, recorder.Code)
+		}
+		if recorder := limitedRequest(router, http.MethodPost, tenant); recorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("second write to %q got status %d", tenant, recorder.Code)
+		}
+	}
+	// The tenant without limits is not limited by the limiters of the server
+	for i := 0; i < 5; i++ {
+		if recorder := limitedRequest(router, http.MethodPost, "initech"); recorder.Code != http.StatusOK {
+			t.Fatalf("write %d to the tenant without limits got status %d", i+1, recorder.Code)
+		}
+	}
+}
+
+func TestQuotaStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w: 10 documents", QuotaExceeded), http.StatusInsufficientStorage},
+		{fmt.Errorf("%w: 100 bytes", BodyTooLarge), http.StatusRequestEntityTooLarge},
+		{DocumentNotExist, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status := quotaStatus(test.err, http.StatusBadRequest); status != test.status {
+			t.Errorf("quotaStatus(%v) = %d, want %d", test.err, status, test.status)
+		}
+	}
+}
+
+func TestCheckBodyQuota(t *testing.T) {
+	server := &Server{config: &Config{QuotaMaxBodyBytes: 4}}
+	if err := server.checkQuota(0, "body"); err != nil {
+		t.Fatalf("body fitting the quota is rejected: %v", err)
+	}
+	if err := server.checkQuota(0, "body", "large body"); quotaStatus(err, 0) != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got error %v for the body over the quota", err)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Value written to the log instead of the secret query parameters
+const redacted = "REDACTED"
+
+// Logger of gin with the default format, the API keys in the query are replaced
+func requestLogger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		var statusColor, methodColor, resetColor string
+		if param.IsOutputColor() {
+			statusColor = param.StatusCodeColor()
+			methodColor = param.MethodColor()
+			resetColor = param.ResetColor()
+		}
+		if param.Latency > time.Minute {
+			param.Latency = param.Latency.Truncate(time.Second)
+		}
+		return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			statusColor, param.StatusCode, resetColor,
+			param.Latency,
+			param.ClientIP,
+			methodColor, param.Method, resetColor,
+			redactPath(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// Path with the query where the value of the API key is replaced
+func redactPath(path string) string {
+	base, rawQuery, found := strings.Cut(path, "?")
+	if !found || !strings.Contains(rawQuery, apiKeyParam) {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return base + "?" + redacted
+	}
+	if _, exist := query[apiKeyParam]; !exist {
+		return path
+	}
+	query.Set(apiKeyParam, redacted)
+	return base + "?" + query.Encode()
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/cache"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Name of the counter of the change markers
+const markerCounter = "marker"
+
+// Age after which the marker left by another replica is published again
+const markerTimeout = time.Minute
+
+// Record of the committed change written to the namespace of the documents in the transaction of the change,
+// so the change can not be committed without it. The markers have negative ids and are never read as documents.
+// The marker is removed when the events of the change are in the change log and the webhook outbox. The marker
+// of a replica that stopped before that is published again by another one, so a change is published at least once
+//
+// Updated: changed fields of the updated documents
+//
+// Deleted: parents of the deleted documents
+//
+// Time: unix time in nanoseconds of the commit or of the last takeover of the marker
+type changeMarker struct {
+	Created []int64                          `json:"Created"`
+	Updated map[int64]map[string]interface{} `json:"Updated"`
+	Deleted map[int64]int64                  `json:"Deleted"`
+	Time    int64                            `json:"Time"`
+}
+
+// Writing the marker of the change to the transaction. Returns the id of the marker, zero if nothing is changed
+func (server *Server) markChange(tx *reindexer.Tx, actions map[int64]map[cache.Action]map[string]interface{}, created []int64) (int64, error) {
+	if len(actions) == 0 && len(created) == 0 {
+		return 0, nil
+	}
+	data, err := json.Marshal(newChangeMarker(actions, created))
+	if err != nil {
+		return 0, err
+	}
+	id, err := server.counters.Reserve(markerCounter, 1)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Insert(&models.Document{Id: -id, Body: string(data), ChildList: []int64{}}); err != nil {
+		return 0, err
+	}
+	return -id, nil
+}
+
+func newChangeMarker(actions map[int64]map[cache.Action]map[string]interface{}, created []int64) *changeMarker {
+	marker := &changeMarker{
+		Created: created,
+		Updated: map[int64]map[string]interface{}{},
+		Deleted: map[int64]int64{},
+		Time:    time.Now().UnixNano(),
+	}
+	for id, action := range actions {
+		if properties, deleted := action[cache.DELETE]; deleted {
+			marker.Deleted[id], _ = properties["ParentId"].(int64)
+			continue
+		}
+		if action[cache.UPDATE] != nil {
+			marker.Updated[id] = action[cache.UPDATE]
+		}
+	}
+	return marker
+}
+
+// Actions of the change in the form the saver of the transaction gives them
+func (marker *changeMarker) actions() map[int64]map[cache.Action]map[string]interface{} {
+	actions := make(map[int64]map[cache.Action]map[string]interface{}, len(marker.Updated)+len(marker.Deleted))
+	for id, fields := range marker.Updated {
+		actions[id] = map[cache.Action]map[string]interface{}{cache.UPDATE: fields}
+	}
+	for id, parentId := range marker.Deleted {
+		actions[id] = map[cache.Action]map[string]interface{}{cache.DELETE: {"ParentId": parentId}}
+	}
+	return actions
+}
+
+// Removing the marker of the published change
+func (server *Server) removeMarker(id int64) error {
+	_, err := server.db.Query(server.config.CollectionName).WhereInt64("id", reindexer.EQ, id).Delete()
+	return err
+}
+
+// Publishing the changes of the markers older than the timeout. The replicas take the marker over by
+// a conditional update of its record, so one of them publishes it
+func (server *Server) recoverChanges() {
+	now := time.Now()
+	// The markers of the later commits have lower ids
+	iterator := server.db.Query(server.config.CollectionName).
+		WhereInt64("id", reindexer.LT, 0).
+		Sort("id", true).
+		Exec()
+	records := []*models.Document{}
+	for iterator.Next() {
+		records = append(records, iterator.Object().(*models.Document))
+	}
+	err := iterator.Error()
+	iterator.Close()
+	if err != nil {
+		log.Printf("Can not read change markers of %s: %s", server.config.CollectionName, err)
+		return
+	}
+	recovered := 0
+	for _, record := range records {
+		marker := &changeMarker{}
+		if err := json.Unmarshal([]byte(record.Body), marker); err != nil {
+			log.Printf("Can not read change marker %d: %s", record.Id, err)
+			continue
+		}
+		if now.Sub(time.Unix(0, marker.Time)) < markerTimeout || server.publisher.holds(record.Id) {
+			continue
+		}
+		claimed, err := server.takeMarker(record, marker, now)
+		if err != nil {
+			log.Printf("Can not take change marker %d: %s", record.Id, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		created := make([]*models.Document, 0, len(marker.Created))
+		for _, id := range marker.Created {
+			if doc, found := server.findDoc(id); found {
+				created = append(created, doc)
+			}
+		}
+		server.publishChanges(record.Id, marker.actions(), created)
+		recovered++
+	}
+	if recovered != 0 {
+		log.Printf("%d changes of %s are published again", recovered, server.config.CollectionName)
+	}
+}
+
+// Moving the time of the marker only if the record is still the one that was read
+func (server *Server) takeMarker(record *models.Document, marker *changeMarker, now time.Time) (bool, error) {
+	marker.Time = now.UnixNano()
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return false, err
+	}
+	iterator := server.db.Query(server.config.CollectionName).
+		WhereInt64("id", reindexer.EQ, record.Id).
+		WhereString("body", reindexer.EQ, record.Body).
+		Set("body", string(data)).
+		Update()
+	defer iterator.Close()
+	if err := iterator.Error(); err != nil {
+		return false, err
+	}
+	return iterator.Count() == 1, nil
+}
+
+// Publishing the changes left by the stopped replicas until the context is done
+func (server *Server) runRecovery(ctx context.Context) {
+	ticker := time.NewTicker(markerTimeout)
+	defer ticker.Stop()
+	for {
+		server.recoverChanges()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
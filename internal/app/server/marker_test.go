@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/cache"
+)
+
+func TestChangeMarkerKeepsActions(t *testing.T) {
+	actions := map[int64]map[cache.Action]map[string]interface{}{
+		2: {cache.UPDATE: {"Body": "new", "Sort": float64(3)}},
+		3: {cache.DELETE: {"ParentId": int64(2)}},
+		4: {cache.DELETE: nil},
+	}
+	data, err := json.Marshal(newChangeMarker(actions, []int64{5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := &changeMarker{}
+	if err := json.Unmarshal(data, marker); err != nil {
+		t.Fatal(err)
+	}
+	// The deleted document without the parent gets the zero parent, the parent stays int64 for changeEvents
+	actions[4] = map[cache.Action]map[string]interface{}{cache.DELETE: {"ParentId": int64(0)}}
+	if got := marker.actions(); !reflect.DeepEqual(got, actions) {
+		t.Fatalf("got actions %v, want %v", got, actions)
+	}
+	if !reflect.DeepEqual(marker.Created, []int64{5}) || marker.Time == 0 {
+		t.Fatalf("got created %v and time %d", marker.Created, marker.Time)
+	}
+}
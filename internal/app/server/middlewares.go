@@ -11,17 +11,22 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// Attempt to write json to the structure and, if successful, run the following function
+// Attempt to write the request body to the structure and, if successful, run the following function.
+// The body is decoded according to the Content-Type header
 func (server *Server) checkJson(next gin.HandlerFunc) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var document models.Document
 		data, _ := ioutil.ReadAll(ctx.Request.Body)
-		if err := json.Unmarshal(data, &document); err != nil {
-			ctx.IndentedJSON(http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+		jsonData, err := unmarshalBodyMap(ctx.ContentType(), data)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		jsonByte, _ := json.Marshal(jsonData)
+		if err := json.Unmarshal(jsonByte, &document); err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
 			return
 		}
-		var jsonData map[string]interface{}
-		json.Unmarshal(data, &jsonData)
 		ctx.Set("data", jsonData)
 		next(ctx)
 	}
@@ -36,7 +41,7 @@ func (server *Server) checkExist(next gin.HandlerFunc) gin.HandlerFunc {
 		if id == "" {
 			jsonData = ctx.GetStringMap("data")
 			if jsonData["Id"] == nil {
-				ctx.IndentedJSON(http.StatusNotFound, gin.H{"error": NullId.Error()})
+				render(ctx, http.StatusNotFound, gin.H{"error": NullId.Error()})
 				return
 			}
 			id = fmt.Sprintf("%.f", jsonData["Id"].(float64))
@@ -45,7 +50,7 @@ func (server *Server) checkExist(next gin.HandlerFunc) gin.HandlerFunc {
 		docId := int64(buffer)
 		doc, found := server.findDoc(docId)
 		if !found {
-			ctx.IndentedJSON(http.StatusNotFound, gin.H{"error": DocumentNotExist.Error()})
+			render(ctx, http.StatusNotFound, gin.H{"error": DocumentNotExist.Error()})
 			return
 		}
 		if jsonData == nil {
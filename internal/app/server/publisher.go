@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/events"
+)
+
+// Interval of writing the changes that failed to be written
+const publishRetryInterval = time.Second
+
+// Events of the committed changes on their way to the change log, the webhook outbox and the subscribers.
+// Reindexer transactions are limited to one namespace, so the log and the outbox are written in their own
+// transactions after the commit of the documents. The changes are written in the order of the commits,
+// a change that fails stays pending together with the changes after it. The subscribers get the events
+// only after they are in the log, so they never see a number the log does not have. The change marker committed
+// with the documents is removed after its events are written, until then the change survives the exit
+type publisher struct {
+	sync.Mutex
+	pending []*pendingChange
+	log     func([]*events.Event) error
+	enqueue func([]*events.Event) error
+	publish func([]*events.Event)
+	settle  func(marker int64) error
+}
+
+type pendingChange struct {
+	list []*events.Event
+	// Id of the change marker, zero for a change without it
+	marker int64
+	// Set when the events are written to the change log
+	logged bool
+}
+
+func newPublisher(log, enqueue func([]*events.Event) error, publish func([]*events.Event), settle func(int64) error) *publisher {
+	return &publisher{
+		log:     log,
+		enqueue: enqueue,
+		publish: publish,
+		settle:  settle,
+	}
+}
+
+// Adding the events of the commit and writing all pending changes. Returns the error of the change
+// that could not be written, it is written again by the next call
+func (publisher *publisher) add(marker int64, list []*events.Event) error {
+	publisher.Lock()
+	defer publisher.Unlock()
+	publisher.pending = append(publisher.pending, &pendingChange{list: list, marker: marker})
+	return publisher.flush()
+}
+
+// Checking whether the change of the marker is waiting to be written
+func (publisher *publisher) holds(marker int64) bool {
+	publisher.Lock()
+	defer publisher.Unlock()
+	for _, change := range publisher.pending {
+		if change.marker == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of the changes that are not written yet
+func (publisher *publisher) backlog() int {
+	publisher.Lock()
+	defer publisher.Unlock()
+	return len(publisher.pending)
+}
+
+// Writing the pending changes without adding new ones
+func (publisher *publisher) retry() error {
+	publisher.Lock()
+	defer publisher.Unlock()
+	return publisher.flush()
+}
+
+// Writing the failed changes again until the context is done, so they do not wait for the next commit
+func (publisher *publisher) run(ctx context.Context) {
+	ticker := time.NewTicker(publishRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if publisher.backlog() == 0 {
+			continue
+		}
+		if err := publisher.retry(); err != nil {
+			log.Printf("%s, %d changes are waiting", err, publisher.backlog())
+		}
+	}
+}
+
+// Must be called under the lock
+func (publisher *publisher) flush() error {
+	for len(publisher.pending) != 0 {
+		change := publisher.pending[0]
+		if !change.logged {
+			if err := publisher.log(change.list); err != nil {
+				return fmt.Errorf("Can not write events to the change log: %w", err)
+			}
+			change.logged = true
+		}
+		if err := publisher.enqueue(change.list); err != nil {
+			return fmt.Errorf("Can not write events to the webhook outbox: %w", err)
+		}
+		publisher.publish(change.list)
+		if change.marker != 0 {
+			if err := publisher.settle(change.marker); err != nil {
+				log.Printf("Can not remove change marker %d, the change may be published again: %s", change.marker, err)
+			}
+		}
+		publisher.pending[0] = nil
+		publisher.pending = publisher.pending[1:]
+	}
+	return nil
+}
+
+// Last attempt to write the pending changes of the server and of its opened collections before the exit
+func (server *Server) flushChanges() {
+	if err := server.publisher.retry(); err != nil {
+		log.Printf("%s, %d changes of %s are lost", err, server.publisher.backlog(), server.config.CollectionName)
+	}
+	for _, registry := range []*serverRegistry{server.tenants, server.collections} {
+		if registry == nil {
+			continue
+		}
+		for _, opened := range registry.list() {
+			opened.flushChanges()
+		}
+	}
+}
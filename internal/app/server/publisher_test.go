@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/events"
+)
+
+// Change log and outbox failing on demand, the log numbers the events like the change log does
+type fakeSinks struct {
+	seq        uint64
+	logged     []*events.Event
+	enqueued   []*events.Event
+	published  []*events.Event
+	settled    []int64
+	logFail    bool
+	outboxFail bool
+}
+
+func (sinks *fakeSinks) publisher() *publisher {
+	return newPublisher(func(list []*events.Event) error {
+		if sinks.logFail {
+			return errors.New("log is unavailable")
+		}
+		for _, event := range list {
+			sinks.seq++
+			event.Seq = sinks.seq
+		}
+		sinks.logged = append(sinks.logged, list...)
+		return nil
+	}, func(list []*events.Event) error {
+		if sinks.outboxFail {
+			return errors.New("outbox is unavailable")
+		}
+		sinks.enqueued = append(sinks.enqueued, list...)
+		return nil
+	}, func(list []*events.Event) {
+		sinks.published = append(sinks.published, list...)
+	}, func(marker int64) error {
+		sinks.settled = append(sinks.settled, marker)
+		return nil
+	})
+}
+
+func docIds(list []*events.Event) []int64 {
+	ids := []int64{}
+	for _, event := range list {
+		ids = append(ids, event.DocId)
+	}
+	return ids
+}
+
+func equalIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPublisherKeepsFailedChanges(t *testing.T) {
+	sinks := &fakeSinks{logFail: true}
+	publisher := sinks.publisher()
+	first := []*events.Event{{Type: events.CREATE, DocId: 1}}
+	if err := publisher.add(-1, first); err == nil {
+		t.Fatal("failed write of the change log is not reported")
+	}
+	if len(sinks.published) != 0 || first[0].Seq != 0 {
+		t.Fatalf("event that is not in the change log is published with number %d", first[0].Seq)
+	}
+
+	// The change after the failed one waits for it
+	sinks.logFail = false
+	sinks.outboxFail = true
+	if err := publisher.add(-2, []*events.Event{{Type: events.UPDATE, DocId: 2}}); err == nil {
+		t.Fatal("failed write of the outbox is not reported")
+	}
+	if !equalIds(docIds(sinks.logged), []int64{1}) || len(sinks.published) != 0 || publisher.backlog() != 2 {
+		t.Fatalf("got logged %v, published %v and %d pending changes", docIds(sinks.logged), docIds(sinks.published), publisher.backlog())
+	}
+	if len(sinks.settled) != 0 || !publisher.holds(-1) || !publisher.holds(-2) {
+		t.Fatalf("markers %v are removed before their changes are written", sinks.settled)
+	}
+
+	sinks.outboxFail = false
+	if err := publisher.add(0, []*events.Event{{Type: events.DELETE, DocId: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{1, 2, 3}
+	for name, list := range map[string][]*events.Event{"logged": sinks.logged, "enqueued": sinks.enqueued, "published": sinks.published} {
+		if !equalIds(docIds(list), want) {
+			t.Errorf("%s events %v, want %v", name, docIds(list), want)
+		}
+	}
+	for i, event := range sinks.published {
+		if event.Seq != uint64(i+1) {
+			t.Errorf("event of document %d has number %d, want %d", event.DocId, event.Seq, i+1)
+		}
+	}
+	if publisher.backlog() != 0 {
+		t.Fatalf("%d changes are still pending", publisher.backlog())
+	}
+	// The change without a marker removes nothing
+	if !equalIds(sinks.settled, []int64{-1, -2}) || publisher.holds(-1) {
+		t.Fatalf("removed markers %v, want [-1 -2]", sinks.settled)
+	}
+}
+
+func TestPublisherRetriesPendingChanges(t *testing.T) {
+	sinks := &fakeSinks{outboxFail: true}
+	publisher := sinks.publisher()
+	if err := publisher.add(-1, []*events.Event{{Type: events.CREATE, DocId: 1}}); err == nil {
+		t.Fatal("failed write of the outbox is not reported")
+	}
+	if err := publisher.retry(); err == nil || publisher.backlog() != 1 {
+		t.Fatalf("got error %v and %d pending changes while the outbox fails", err, publisher.backlog())
+	}
+	sinks.outboxFail = false
+	if err := publisher.retry(); err != nil {
+		t.Fatal(err)
+	}
+	// The change is written to the log only once
+	if !equalIds(docIds(sinks.logged), []int64{1}) || !equalIds(docIds(sinks.published), []int64{1}) || publisher.backlog() != 0 {
+		t.Fatalf("got logged %v, published %v and %d pending changes", docIds(sinks.logged), docIds(sinks.published), publisher.backlog())
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/restream/reindexer/v3"
+)
+
+// Servers of the collections opened on the first request. Used for the tenants and the named collections
+type serverRegistry struct {
+	sync.Mutex
+	// Namespace of the records of the collections
+	namespace string
+	servers   map[string]*Server
+	stops     map[string]context.CancelFunc
+}
+
+func newServerRegistry(namespace string) *serverRegistry {
+	return &serverRegistry{
+		namespace: namespace,
+		servers:   map[string]*Server{},
+		stops:     map[string]context.CancelFunc{},
+	}
+}
+
+// Returning the opened server or creating it, opening its namespaces and starting its background work
+func (registry *serverRegistry) open(name string, create func() (*Server, error)) (*Server, error) {
+	registry.Lock()
+	defer registry.Unlock()
+	if server, exist := registry.servers[name]; exist {
+		return server, nil
+	}
+	server, err := create()
+	if err != nil {
+		return nil, err
+	}
+	if err := server.openCollection(); err != nil {
+		server.closeCollection()
+		return nil, err
+	}
+	runCtx, stop := context.WithCancel(context.Background())
+	server.runBackground(runCtx)
+	registry.servers[name] = server
+	registry.stops[name] = stop
+	return server, nil
+}
+
+// Stopping the server of the removed collection together with the servers of its named collections
+func (registry *serverRegistry) close(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	if stop, exist := registry.stops[name]; exist {
+		server := registry.servers[name]
+		if server.collections != nil {
+			server.collections.closeAll()
+		}
+		stop()
+		server.closeCollection()
+		delete(registry.stops, name)
+		delete(registry.servers, name)
+	}
+}
+
+func (registry *serverRegistry) list() []*Server {
+	registry.Lock()
+	defer registry.Unlock()
+	servers := make([]*Server, 0, len(registry.servers))
+	for _, server := range registry.servers {
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func (registry *serverRegistry) closeAll() {
+	registry.Lock()
+	names := make([]string, 0, len(registry.servers))
+	for name := range registry.servers {
+		names = append(names, name)
+	}
+	registry.Unlock()
+	for _, name := range names {
+		registry.close(name)
+	}
+}
+
+// Dropping the namespace of the collection and all namespaces that start with its name
+func dropCollection(db *reindexer.Reindexer, collection string) error {
+	namespaces, err := db.DescribeNamespaces()
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		if namespace.Name == collection || strings.HasPrefix(namespace.Name, collection+"_") {
+			if err := db.DropNamespace(namespace.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
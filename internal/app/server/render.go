@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	mimeYAML2 = "application/yaml"
+	mimeYAML3 = "text/yaml"
+)
+
+// Formats available for the response, the first one is used when the client accepts anything
+var offeredFormats = []string{
+	binding.MIMEJSON,
+	binding.MIMEYAML,
+	mimeYAML2,
+	mimeYAML3,
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEMSGPACK,
+	binding.MIMEMSGPACK2,
+}
+
+var msgpackHandle = func() *codec.MsgpackHandle {
+	handle := &codec.MsgpackHandle{}
+	handle.WriteExt = true
+	handle.RawToString = true
+	handle.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return handle
+}()
+
+// Writing the response in the format selected by the Accept header. JSON is compact
+// unless the "pretty" query parameter is passed
+func render(ctx *gin.Context, code int, obj interface{}) {
+	format := ctx.NegotiateFormat(offeredFormats...)
+	data, err := marshal(format, obj, isPretty(ctx))
+	if err != nil {
+		data, _ = json.Marshal(gin.H{"error": NotAcceptable.Error()})
+		ctx.Data(http.StatusNotAcceptable, binding.MIMEJSON+"; charset=utf-8", data)
+		return
+	}
+	if format != binding.MIMEMSGPACK && format != binding.MIMEMSGPACK2 {
+		format += "; charset=utf-8"
+	}
+	ctx.Data(code, format, data)
+}
+
+func isPretty(ctx *gin.Context) bool {
+	value, exist := ctx.GetQuery("pretty")
+	if !exist {
+		return false
+	}
+	if value == "" {
+		return true
+	}
+	pretty, _ := strconv.ParseBool(value)
+	return pretty
+}
+
+func marshal(format string, obj interface{}, pretty bool) ([]byte, error) {
+	switch format {
+	case binding.MIMEJSON:
+		if pretty {
+			return json.MarshalIndent(obj, "", "    ")
+		}
+		return json.Marshal(obj)
+	case binding.MIMEYAML, mimeYAML2, mimeYAML3:
+		return yaml.Marshal(obj)
+	case binding.MIMEXML, binding.MIMEXML2:
+		return marshalXML(obj, pretty)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		var data []byte
+		err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(obj)
+		return data, err
+	}
+	return nil, NotAcceptable
+}
+
+// Root element of the responses that are maps or lists
+var xmlResponse = xml.StartElement{Name: xml.Name{Local: "response"}}
+
+var xmlMapType = reflect.TypeOf(util.XMLMap(nil))
+
+// List written as the elements of its items, each named after the type of the item
+type xmlList []interface{}
+
+func (list xmlList) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, item := range list {
+		if err := encoder.Encode(item); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// encoding/xml writes neither maps nor a single root for a list, so both are put into the response element
+func marshalXML(obj interface{}, pretty bool) ([]byte, error) {
+	buffer := bytes.NewBufferString(xml.Header)
+	encoder := xml.NewEncoder(buffer)
+	if pretty {
+		encoder.Indent("", "    ")
+	}
+	value := reflect.ValueOf(obj)
+	var err error
+	switch {
+	case value.IsValid() && value.Type().ConvertibleTo(xmlMapType):
+		err = encoder.EncodeElement(value.Convert(xmlMapType).Interface(), xmlResponse)
+	case value.Kind() == reflect.Slice:
+		list := make(xmlList, value.Len())
+		for i := range list {
+			list[i] = value.Index(i).Interface()
+		}
+		err = encoder.EncodeElement(list, xmlResponse)
+	default:
+		err = encoder.Encode(obj)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decoding the request body into the structure according to the Content-Type, JSON is used by default
+func unmarshalBody(contentType string, data []byte, obj interface{}) error {
+	switch contentType {
+	case binding.MIMEYAML, mimeYAML2, mimeYAML3:
+		return yaml.Unmarshal(data, obj)
+	case binding.MIMEXML, binding.MIMEXML2:
+		return xml.Unmarshal(data, obj)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		return codec.NewDecoderBytes(data, msgpackHandle).Decode(obj)
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// Decoding the request body of the document into a map. The values have the same types
+// that encoding/json produces, since the handlers rely on them
+func unmarshalBodyMap(contentType string, data []byte) (map[string]interface{}, error) {
+	var jsonData map[string]interface{}
+	switch contentType {
+	case binding.MIMEXML, binding.MIMEXML2:
+		return xmlToMap(data)
+	case binding.MIMEYAML, mimeYAML2, mimeYAML3, binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		var buffer map[string]interface{}
+		if err := unmarshalBody(contentType, data, &buffer); err != nil {
+			return nil, err
+		}
+		jsonByte, err := json.Marshal(buffer)
+		if err != nil {
+			return nil, err
+		}
+		data = jsonByte
+	}
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+// Only the child elements of the root are read. The type of the value is taken from the field
+// of the document with the same name, repeated elements of a list field are collected into a list
+func xmlToMap(data []byte) (map[string]interface{}, error) {
+	jsonData := map[string]interface{}{}
+	types := reflect.TypeOf(models.Document{})
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	name := ""
+	text := &strings.Builder{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 {
+				name = element.Name.Local
+				text.Reset()
+			}
+		case xml.CharData:
+			if depth == 2 {
+				text.Write(element)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				if err := setXMLValue(jsonData, types, name, text.String()); err != nil {
+					return nil, err
+				}
+			}
+			depth--
+		}
+	}
+	if depth != 0 {
+		return nil, InvalidRequest
+	}
+	return jsonData, nil
+}
+
+func setXMLValue(jsonData map[string]interface{}, types reflect.Type, name, value string) error {
+	field, exist := types.FieldByName(name)
+	if !exist {
+		jsonData[name] = value
+		return nil
+	}
+	switch field.Type.Kind() {
+	case reflect.Slice:
+		list, _ := jsonData[name].([]interface{})
+		if list == nil {
+			list = []interface{}{}
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return err
+			}
+			list = append(list, number)
+		}
+		jsonData[name] = list
+	case reflect.Int, reflect.Int64:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return err
+		}
+		jsonData[name] = number
+	default:
+		jsonData[name] = value
+	}
+	return nil
+}
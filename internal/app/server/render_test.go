@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/audit"
+	"github.com/EwvwGeN/assignment/internal/backup"
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func renderXML(t *testing.T, obj interface{}) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", func(ctx *gin.Context) { render(ctx, http.StatusOK, obj) })
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Accept", "application/xml")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	// The response is a well-formed document with one root
+	decoder := xml.NewDecoder(strings.NewReader(recorder.Body.String()))
+	var root struct{ XMLName xml.Name }
+	if err := decoder.Decode(&root); err != nil {
+		t.Fatalf("response is not a document: %v", err)
+	}
+	if _, err := decoder.Token(); err == nil {
+		t.Fatalf("response has more than one root: %s", recorder.Body.String())
+	}
+	return strings.TrimPrefix(recorder.Body.String(), xml.Header)
+}
+
+func TestRenderXMLWrappers(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  interface{}
+		want string
+	}{
+		{
+			"map",
+			gin.H{"error": "Document doesnt exist", "Nested": map[string]interface{}{"Id": 1}},
+			"<response><Nested><Id>1</Id></Nested><error>Document doesnt exist</error></response>",
+		},
+		{
+			"list",
+			[]*models.Document{{Id: 1}, {Id: 2}},
+			"<response><Document><Id>1</Id>",
+		},
+		{
+			"empty list",
+			[]models.BigDocument{},
+			"<response></response>",
+		},
+		{
+			"report",
+			&backup.Report{Inserted: 1, Remapped: backup.Remapping{7: 12, 3: 11}, Written: []int64{11}},
+			`<Remapped><Id Old="3" New="11"></Id><Id Old="7" New="12"></Id></Remapped>`,
+		},
+		{
+			"event fields",
+			&events.Event{Type: events.UPDATE, DocId: 5, Fields: map[string]interface{}{"Body": "new"}},
+			"<Fields><Body>new</Body></Fields>",
+		},
+		{
+			"audit changes",
+			[]audit.Change{{DocId: 5, Before: map[string]interface{}{"Sort": 1}}},
+			"<response><Change><DocId>5</DocId><Before><Sort>1</Sort></Before><After></After></Change></response>",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := renderXML(t, test.obj); !strings.Contains(got, test.want) {
+				t.Fatalf("got %s, want it to contain %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderXMLHidesImportDetails(t *testing.T) {
+	got := renderXML(t, &backup.Report{Written: []int64{11}, Created: []*models.Document{{Id: 11}}})
+	if strings.Contains(got, "Written") || strings.Contains(got, "Created") {
+		t.Fatalf("internal fields of the report are written: %s", got)
+	}
+}
@@ -4,11 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/EwvwGeN/assignment/internal/acl"
+	"github.com/EwvwGeN/assignment/internal/apikey"
+	"github.com/EwvwGeN/assignment/internal/audit"
+	"github.com/EwvwGeN/assignment/internal/backup"
 	"github.com/EwvwGeN/assignment/internal/cache"
+	"github.com/EwvwGeN/assignment/internal/changelog"
+	"github.com/EwvwGeN/assignment/internal/counter"
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/idempotency"
+	"github.com/EwvwGeN/assignment/internal/invalidation"
+	"github.com/EwvwGeN/assignment/internal/jwt"
 	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/ratelimit"
+	"github.com/EwvwGeN/assignment/internal/webhook"
 	"github.com/gin-gonic/gin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
@@ -21,38 +39,167 @@ import (
 )
 
 var (
-	NullId             = errors.New("Missing Id")
-	DocumentSelfNested = errors.New("Document cant be self nested")
-	DocumentNotExist   = errors.New("Document doesnt exist")
-	DocumentHaveParent = errors.New("Document already have parent")
-	DeplthLevel        = errors.New("Nesting level is higher than allowed")
-	InvalidRequest     = errors.New("Invalid request")
+	NullId              = errors.New("Missing Id")
+	DocumentSelfNested  = errors.New("Document cant be self nested")
+	DocumentNotExist    = errors.New("Document doesnt exist")
+	DocumentHaveParent  = errors.New("Document already have parent")
+	DeplthLevel         = errors.New("Nesting level is higher than allowed")
+	InvalidRequest      = errors.New("Invalid request")
+	UnknownFormat       = errors.New("Unknown format")
+	NotAcceptable       = errors.New("Response can not be encoded in the accepted format")
+	Unauthorized        = errors.New("Authentication required")
+	Forbidden           = errors.New("Not enough permissions")
+	TenantNotExist      = errors.New("Tenant doesnt exist")
+	TenantExist         = errors.New("Tenant already exists")
+	InvalidTenant       = errors.New("Tenant name must consist of lowercase letters, digits and dashes")
+	CollectionNotExist  = errors.New("Collection doesnt exist")
+	CollectionExist     = errors.New("Collection already exists")
+	InvalidCollection   = errors.New("Collection name must consist of lowercase letters, digits and dashes")
+	UnknownSortOrder    = errors.New("Sort order must be asc or desc")
+	SchemaViolation     = errors.New("Document does not match the schema of the collection")
+	TooManyRequests     = errors.New("Too many requests")
+	QuotaExceeded       = errors.New("Document quota exceeded")
+	BodyTooLarge        = errors.New("Document body is larger than allowed")
+	InvalidIdempotency  = errors.New("Idempotency key must be from 1 to 255 characters")
+	DocumentNotCached   = errors.New("Document is not in the cache")
+	ChangesNotPublished = errors.New("Documents are written, but their changes are not in the change log or the webhook outbox")
 )
 
 type Server struct {
-	router *gin.Engine
-	config *Config
-	cache  *cache.Cache
-	db     *reindexer.Reindexer
+	router   *gin.Engine
+	config   *Config
+	cache    *cache.Cache
+	events   *events.Broker
+	changes  changeLog
+	audit    *audit.Log
+	apiKeys  apiKeyStore
+	acl      *acl.Store
+	webhooks *webhook.Dispatcher
+	// Counters of the collection, the ids of the new documents are reserved with them
+	counters *counter.Store
+	// Saved responses of the requests with an idempotency key
+	idempotency idempotencyStore
+	// Nil if the caches of the replicas are not invalidated together
+	bus *invalidation.Bus
+	// Nil if no keys for bearer tokens are configured
+	jwtVerifier *jwt.Verifier
+	// Servers of the tenants, nil for the server of a tenant itself
+	tenants *serverRegistry
+	// Servers of the named collections, nil for the server of a named collection itself
+	collections *serverRegistry
+	// Settings of the named collection, nil for the other servers
+	collection *models.Collection
+	// Compiled pattern of the schema of the named collection
+	bodyPattern *regexp.Regexp
+	// Nil if the requests are not limited
+	readLimiter  *ratelimit.Limiter
+	writeLimiter *ratelimit.Limiter
+	db           *reindexer.Reindexer
+	// Writes the events of the commits to the change log and the webhook outbox
+	publisher *publisher
+	// Held by the writes adding documents while the number of the documents is limited
+	quotaLock sync.Mutex
 }
 
 // Creating a connection and launching a cache
 func NewServer(config *Config) *Server {
 	DbConn := reindexer.NewReindex(
 		fmt.Sprintf("cproto://%s:%s/%s", config.DbHost, config.DbPort, config.DBname), reindexer.WithCreateDBIfMissing(), reindexer.WithOpenTelemetry())
-	return &Server{
-		router: gin.Default(),
+	jwtVerifier, err := newJwtVerifier(config)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := cache.ParsePolicy(config.CachePolicy); err != nil {
+		panic(err)
+	}
+	server := newCollectionServer(DbConn, config)
+	server.router = gin.New()
+	server.router.Use(requestLogger(), gin.Recovery())
+	server.apiKeys = apikey.NewStore(DbConn, config.CollectionName)
+	server.jwtVerifier = jwtVerifier
+	server.readLimiter = newLimiter(config.RateLimitReadRps, config.RateLimitReadBurst)
+	server.writeLimiter = newLimiter(config.RateLimitWriteRps, config.RateLimitWriteBurst)
+	server.tenants = newServerRegistry(tenantsNamespace(config))
+	server.collections = newServerRegistry(config.CollectionName + "_collections")
+	return server
+}
+
+// Creating the server of one collection of documents with its own cache, events and stores
+func newCollectionServer(db *reindexer.Reindexer, config *Config) *Server {
+	server := &Server{
 		config: config,
-		cache:  cache.NewCache(time.Duration(config.CachelifeTime)*time.Minute, time.Duration(config.CacheCleaningInterval)*time.Minute),
-		db:     DbConn,
+		cache: cache.NewCache(cache.Options{
+			LifeTime:         time.Duration(config.CachelifeTime) * time.Minute,
+			CleaningInterval: time.Duration(config.CacheCleaningInterval) * time.Minute,
+			MaxEntries:       config.CacheMaxEntries,
+			MaxBytes:         int64(config.CacheMaxBytes),
+			Policy:           cache.Policy(config.CachePolicy),
+			NegativeTtl:      time.Duration(config.CacheNegativeTtl) * time.Second,
+		}),
+		events:      events.NewBroker(config.EventHistorySize),
+		changes:     changelog.NewLog(db, config.CollectionName),
+		audit:       audit.NewLog(db, config.CollectionName),
+		acl:         acl.NewStore(db, config.CollectionName),
+		idempotency: idempotency.NewStore(db, config.CollectionName, time.Duration(config.IdempotencyTtl)*time.Hour),
+		webhooks: webhook.NewDispatcher(db, config.CollectionName, webhook.Options{
+			MaxAttempts:  config.WebhookMaxAttempts,
+			Backoff:      time.Duration(config.WebhookBackoff) * time.Second,
+			MaxBackoff:   time.Duration(config.WebhookMaxBackoff) * time.Minute,
+			Timeout:      time.Duration(config.WebhookTimeout) * time.Second,
+			PollInterval: time.Second,
+		}),
+		db: db,
 	}
+	server.publisher = newPublisher(server.changes.Append, server.webhooks.Enqueue, server.events.Publish, server.removeMarker)
+	return server
 }
 
 func (server *Server) prepareCollections() {
 	ctx, span := otel.Tracer("Test trace").Start(context.Background(), "rx open ns")
 	defer span.End()
 	server.db.WithContext(ctx)
-	server.db.OpenNamespace(server.config.CollectionName, reindexer.DefaultNamespaceOptions(), models.Document{})
+	if err := server.openCollection(); err != nil {
+		panic(err)
+	}
+	if err := server.apiKeys.Open(); err != nil {
+		panic(err)
+	}
+	if err := server.db.OpenNamespace(server.tenants.namespace, reindexer.DefaultNamespaceOptions(), models.Tenant{}); err != nil {
+		panic(err)
+	}
+}
+
+// Opening the namespace of the documents and the namespaces of the stores of the collection
+func (server *Server) openCollection() error {
+	if err := server.db.OpenNamespace(server.config.CollectionName, reindexer.DefaultNamespaceOptions(), models.Document{}); err != nil {
+		return err
+	}
+	counters, err := backup.OpenIds(server.db, server.config.CollectionName)
+	if err != nil {
+		return err
+	}
+	server.counters = counters
+	if err := server.webhooks.OpenNamespaces(); err != nil {
+		return err
+	}
+	if err := server.changes.Open(); err != nil {
+		return err
+	}
+	server.events.SetSeq(server.changes.Head())
+	if err := server.audit.Open(); err != nil {
+		return err
+	}
+	if err := server.acl.Open(); err != nil {
+		return err
+	}
+	if err := server.idempotency.Open(); err != nil {
+		return err
+	}
+	server.subscribeInvalidations()
+	if server.collections == nil {
+		return nil
+	}
+	return server.db.OpenNamespace(server.collections.namespace, reindexer.DefaultNamespaceOptions(), models.Collection{})
 }
 
 func (server *Server) Start() {
@@ -80,23 +227,118 @@ func (server *Server) Start() {
 	}()
 	otel.SetTracerProvider(tp)
 
+	bus, err := server.newInvalidationBus()
+	if err != nil {
+		panic(err)
+	}
+	server.bus = bus
 	server.prepareCollections()
+	server.warmUp()
+	server.runBackground(context.Background())
+	if server.bus != nil {
+		go func() {
+			if err := server.bus.Run(context.Background()); err != nil {
+				log.Printf("Invalidation bus stopped: %s", err)
+			}
+		}()
+	}
 	server.configureRouter()
-	server.router.Run(fmt.Sprintf("%s:%s", server.config.ApiHost, server.config.APiPort))
+	httpServer := &http.Server{
+		Addr:    fmt.Sprintf("%s:%s", server.config.ApiHost, server.config.APiPort),
+		Handler: server.router,
+	}
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.ListenAndServe()
+	}()
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	case <-stop.Done():
+		server.shutdown(httpServer)
+	}
+}
+
+// Starting the delivery of the webhooks and the writing of the failed changes
+func (server *Server) runBackground(ctx context.Context) {
+	go server.webhooks.Run(ctx)
+	go server.publisher.run(ctx)
+	go server.runRecovery(ctx)
+	if server.bus != nil {
+		go server.refreshAccessRules(ctx)
+	}
 }
 
 func (server *Server) configureRouter() {
-	simpleDocGroupe := server.router.Group("/docs")
+	server.router.Use(server.requestId(), server.auditTrail(), server.authenticate(), server.resolveTenant(), server.rateLimit())
+	read := server.requireScope(apikey.DocsRead)
+	server.docRoutes(server.router.Group("/docs"), server.router.Group("/big-docs"), server.tenant)
+	collectionGroupe := server.router.Group("/collections")
+	{
+		collectionGroupe.GET("", read, server.tenant((*Server).getCollections))
+		collectionGroupe.POST("", server.requireScope(apikey.Admin), server.tenant((*Server).createCollection))
+		collectionGroupe.DELETE("/:name", server.requireScope(apikey.Admin), server.tenant((*Server).deleteCollection))
+	}
+	server.docRoutes(collectionGroupe.Group("/:name/docs"), collectionGroupe.Group("/:name/big-docs"), server.namedCollectionHandler)
+	server.cacheRoutes(collectionGroupe.Group("/:name/cache", server.requireScope(apikey.Admin)), server.namedCollectionHandler)
+	server.changeRoutes(collectionGroupe.Group("/:name"), server.namedCollectionHandler)
+	server.webhookRoutes(collectionGroupe.Group("/:name/webhooks", server.requireScope(apikey.Admin)), server.namedCollectionHandler)
+	server.changeRoutes(server.router.Group(""), server.tenant)
+	adminGroupe := server.router.Group("/admin", server.requireScope(apikey.Admin))
 	{
-		simpleDocGroupe.GET("", server.getAllDocs())
-		simpleDocGroupe.GET("/:id", server.getDocById())
-		simpleDocGroupe.POST("", server.createDoc())
-		simpleDocGroupe.PUT("", server.updateDoc())
-		simpleDocGroupe.DELETE("/:id", server.deleteDoc())
+		adminGroupe.GET("/export", server.tenant((*Server).exportDocs))
+		adminGroupe.POST("/import", server.tenant((*Server).idempotent), server.tenant((*Server).importDocs))
 	}
-	bigDocGroupe := server.router.Group("/big-docs")
+	server.webhookRoutes(adminGroupe.Group("/webhooks"), server.tenant)
+	server.cacheRoutes(adminGroupe.Group("/cache"), server.tenant)
+	// Keys and tenants are shared by all tenants, so they are managed only outside of a tenant
+	globalGroupe := adminGroupe.Group("", server.withoutTenant())
 	{
-		bigDocGroupe.GET("", server.getAllBigDocs())
-		bigDocGroupe.GET("/:id", server.getBigDocById())
+		globalGroupe.GET("/api-keys", server.getApiKeys())
+		globalGroupe.POST("/api-keys", server.createApiKey())
+		globalGroupe.DELETE("/api-keys/:id", server.revokeApiKey())
+		globalGroupe.POST("/api-keys/:id/rotate", server.rotateApiKey())
+		globalGroupe.GET("/tenants", server.getTenants())
+		globalGroupe.POST("/tenants", server.createTenant())
+		globalGroupe.DELETE("/tenants/:name", server.deleteTenant())
 	}
 }
+
+// Routes of the events, the change log and the audit of one collection
+func (server *Server) changeRoutes(groupe *gin.RouterGroup, dispatch func(func(*Server) gin.HandlerFunc) gin.HandlerFunc) {
+	read := server.requireScope(apikey.DocsRead)
+	groupe.GET("/events", read, dispatch((*Server).streamEvents))
+	groupe.GET("/ws", read, dispatch((*Server).serveWebSocket))
+	groupe.GET("/changes", read, dispatch((*Server).getChanges))
+	groupe.GET("/audit", server.requireScope(apikey.Admin), dispatch((*Server).getAudit))
+}
+
+// Routes of the webhooks of one collection
+func (server *Server) webhookRoutes(webhookGroupe *gin.RouterGroup, dispatch func(func(*Server) gin.HandlerFunc) gin.HandlerFunc) {
+	webhookGroupe.GET("", dispatch((*Server).getWebhooks))
+	webhookGroupe.POST("", dispatch((*Server).createWebhook))
+	webhookGroupe.DELETE("/:id", dispatch((*Server).deleteWebhook))
+	webhookGroupe.GET("/dead-letters", dispatch((*Server).getDeadLetters))
+	webhookGroupe.POST("/dead-letters/:id/retry", dispatch((*Server).retryDeadLetter))
+}
+
+// Routes of the documents of one collection. The dispatch picks the server of the collection for the request
+func (server *Server) docRoutes(simpleDocGroupe, bigDocGroupe *gin.RouterGroup, dispatch func(func(*Server) gin.HandlerFunc) gin.HandlerFunc) {
+	read := server.requireScope(apikey.DocsRead)
+	write := server.requireScope(apikey.DocsWrite)
+	simpleDocGroupe.GET("", read, dispatch((*Server).getAllDocs))
+	simpleDocGroupe.GET("/:id", read, dispatch((*Server).getDocById))
+	simpleDocGroupe.POST("", write, dispatch((*Server).idempotent), dispatch((*Server).createDoc))
+	simpleDocGroupe.PUT("", write, dispatch((*Server).updateDoc))
+	simpleDocGroupe.DELETE("/:id", write, dispatch((*Server).deleteDoc))
+	simpleDocGroupe.GET("/:id/acl", read, dispatch((*Server).getAcl))
+	simpleDocGroupe.PUT("/:id/acl", write, dispatch((*Server).setAcl))
+	bigDocGroupe.GET("", read, dispatch((*Server).getAllBigDocs))
+	bigDocGroupe.GET("/:id", read, dispatch((*Server).getBigDocById))
+	bigDocGroupe.POST("", write, dispatch((*Server).idempotent), dispatch((*Server).createBigDoc))
+	bigDocGroupe.POST("/import", write, dispatch((*Server).idempotent), dispatch((*Server).importOutline))
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/apikey"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/restream/reindexer/v3"
+	"go.opentelemetry.io/otel"
+)
+
+// Key of the server of the request tenant in the gin context
+const tenantKey = "tenant"
+
+// Names of the tenants and the named collections. Dashes only, so a name never is the beginning
+// of the namespaces of another one
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Name of the collection of the tenant, the namespaces of its stores start with it
+func TenantCollection(config *Config, name string) string {
+	return config.CollectionName + "_tenant_" + name
+}
+
+// Namespace of the registered tenants
+func tenantsNamespace(config *Config) string {
+	return config.CollectionName + "_tenants"
+}
+
+// Returning the server of the tenant, opening its namespaces if it is the first request to it
+func (server *Server) tenantServer(name string) (*Server, error) {
+	return server.tenants.open(name, func() (*Server, error) {
+		item, found := server.db.Query(server.tenants.namespace).WhereString("name", reindexer.EQ, name).Get()
+		if !found {
+			return nil, TenantNotExist
+		}
+		tenant := item.(*models.Tenant)
+		config := *server.config
+		config.CollectionName = TenantCollection(server.config, tenant.Name)
+		if tenant.NestingLevel > 0 {
+			config.NestingLevel = tenant.NestingLevel
+		}
+		if tenant.MaxDocs > 0 {
+			config.QuotaMaxDocs = tenant.MaxDocs
+		}
+		if tenant.MaxBodyBytes > 0 {
+			config.QuotaMaxBodyBytes = tenant.MaxBodyBytes
+		}
+		if tenant.ReadRps > 0 {
+			config.RateLimitReadRps = tenant.ReadRps
+		}
+		if tenant.WriteRps > 0 {
+			config.RateLimitWriteRps = tenant.WriteRps
+		}
+		tenantServer := newCollectionServer(server.db, &config)
+		tenantServer.apiKeys = server.apiKeys
+		tenantServer.jwtVerifier = server.jwtVerifier
+		tenantServer.bus = server.bus
+		tenantServer.collections = newServerRegistry(config.CollectionName + "_collections")
+		tenantServer.readLimiter = newLimiter(config.RateLimitReadRps, config.RateLimitReadBurst)
+		tenantServer.writeLimiter = newLimiter(config.RateLimitWriteRps, config.RateLimitWriteBurst)
+		return tenantServer, nil
+	})
+}
+
+// Taking the tenant from the tenant of the API key, the token claim, the header or the subdomain.
+// The clients bound to a tenant by the key or the claim work only with it, the header and the subdomain
+// may only repeat it. The other clients may choose the tenant by the header or the subdomain only
+// with the admin scope or when authentication is disabled
+func (server *Server) tenantFromRequest(ctx *gin.Context) (string, error) {
+	bound := ctx.GetString(keyTenantKey)
+	if identity := requestIdentity(ctx); identity != nil && server.config.TenantClaim != "" {
+		bound = claimString(identity.Claims, server.config.TenantClaim)
+	}
+	name := bound
+	for _, value := range []string{ctx.GetHeader(server.config.TenantHeader), server.tenantFromHost(ctx.Request.Host)} {
+		if value == "" {
+			continue
+		}
+		if bound != "" && value != bound {
+			return "", Forbidden
+		}
+		if bound == "" && server.config.AuthEnabled && !apikey.HasScope(ctx.GetStringSlice(scopesKey), apikey.Admin) {
+			return "", Forbidden
+		}
+		if name != "" && value != name {
+			return "", Forbidden
+		}
+		name = value
+	}
+	return name, nil
+}
+
+func (server *Server) tenantFromHost(host string) string {
+	if server.config.TenantDomain == "" {
+		return ""
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+	if subdomain := strings.TrimSuffix(host, "."+strings.ToLower(server.config.TenantDomain)); subdomain != host && !strings.Contains(subdomain, ".") {
+		return subdomain
+	}
+	return ""
+}
+
+// Setting the server of the tenant of the request. Requests without a tenant use the collection from the configuration
+func (server *Server) resolveTenant() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !server.config.TenantsEnabled {
+			ctx.Next()
+			return
+		}
+		name, err := server.tenantFromRequest(ctx)
+		if err != nil {
+			ctx.Abort()
+			render(ctx, http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if name == "" {
+			ctx.Next()
+			return
+		}
+		tenantServer, err := server.tenantServer(name)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, TenantNotExist) {
+				status = http.StatusNotFound
+			}
+			ctx.Abort()
+			render(ctx, status, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.Set(tenantKey, tenantServer)
+		ctx.Next()
+	}
+}
+
+// Server of the collection the request works with
+func (server *Server) requestServer(ctx *gin.Context) *Server {
+	if tenantServer, ok := ctx.Value(tenantKey).(*Server); ok {
+		return tenantServer
+	}
+	return server
+}
+
+// Running the handler of the server of the request tenant
+func (server *Server) tenant(handler func(*Server) gin.HandlerFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		handler(server.requestServer(ctx))(ctx)
+	}
+}
+
+// Rejecting the requests made in a tenant
+func (server *Server) withoutTenant() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, exist := ctx.Get(tenantKey); exist {
+			ctx.Abort()
+			render(ctx, http.StatusForbidden, gin.H{"error": Forbidden.Error()})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (server *Server) getTenants() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetTenants").Start(ctx.Request.Context(), "Get tenants handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		iterator := server.db.Query(server.tenants.namespace).Sort("name", false).Exec()
+		defer iterator.Close()
+		tenants := []*models.Tenant{}
+		for iterator.Next() {
+			tenants = append(tenants, iterator.Object().(*models.Tenant))
+		}
+		if err := iterator.Error(); err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		render(ctx, http.StatusOK, tenants)
+	}
+}
+
+// Registering the tenant and opening its namespaces
+func (server *Server) createTenant() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("CreateTenant").Start(ctx.Request.Context(), "Create tenant handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		var tenant models.Tenant
+		if err := ctx.ShouldBindJSON(&tenant); err != nil || tenant.NestingLevel < 0 || tenant.MaxDocs < 0 || tenant.MaxBodyBytes < 0 || tenant.ReadRps < 0 || tenant.WriteRps < 0 {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		if !namePattern.MatchString(tenant.Name) {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidTenant.Error()})
+			return
+		}
+		tenant.CreatedAt = time.Now().UnixNano()
+		count, err := server.db.Insert(server.tenants.namespace, &tenant)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if count == 0 {
+			render(ctx, http.StatusConflict, gin.H{"error": TenantExist.Error()})
+			return
+		}
+		if _, err := server.tenantServer(tenant.Name); err != nil {
+			render(ctx, http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		render(ctx, http.StatusCreated, tenant)
+	}
+}
+
+// Removing the tenant, revoking its keys and dropping all its namespaces
+func (server *Server) deleteTenant() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("DeleteTenant").Start(ctx.Request.Context(), "Delete tenant handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		name := ctx.Param("name")
+		count, err := server.db.Query(server.tenants.namespace).WhereString("name", reindexer.EQ, name).Delete()
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if count == 0 {
+			render(ctx, http.StatusNotFound, gin.H{"error": TenantNotExist.Error()})
+			return
+		}
+		server.tenants.close(name)
+		if err := server.apiKeys.RevokeTenant(name); err != nil {
+			render(ctx, http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := dropCollection(server.db, TenantCollection(server.config, name)); err != nil {
+			render(ctx, http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		render(ctx, http.StatusOK, gin.H{"message": "ok"})
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/apikey"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestTenantFromRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := &memoryKeys{keys: map[string]*models.ApiKey{}}
+	reader, _, _ := keys.Create("reader", "", []string{apikey.DocsRead})
+	bound, _, _ := keys.Create("acme-writer", "acme", []string{apikey.DocsWrite})
+	boundAdmin, _, _ := keys.Create("acme-admin", "acme", []string{apikey.Admin})
+	admin, _, _ := keys.Create("admin", "", []string{apikey.Admin})
+	newRouter := func(authEnabled bool) *gin.Engine {
+		server := &Server{
+			config: &Config{
+				AuthEnabled:    authEnabled,
+				TenantsEnabled: true,
+				TenantHeader:   "X-Tenant-Id",
+				TenantDomain:   "docs.example.com",
+			},
+			apiKeys: keys,
+		}
+		router := gin.New()
+		router.Use(server.authenticate())
+		router.GET("/docs", func(ctx *gin.Context) {
+			name, err := server.tenantFromRequest(ctx)
+			if err != nil {
+				ctx.String(http.StatusForbidden, err.Error())
+				return
+			}
+			ctx.String(http.StatusOK, name)
+		})
+		return router
+	}
+	withAuth, withoutAuth := newRouter(true), newRouter(false)
+
+	tests := []struct {
+		name   string
+		router *gin.Engine
+		key    string
+		tenant string
+		host   string
+		status int
+		want   string
+	}{
+		{"unbound key with header", withAuth, reader, "acme", "", http.StatusForbidden, ""},
+		{"unbound key with subdomain", withAuth, reader, "", "acme.docs.example.com", http.StatusForbidden, ""},
+		{"unbound key without tenant", withAuth, reader, "", "", http.StatusOK, ""},
+		{"bound key", withAuth, bound, "", "", http.StatusOK, "acme"},
+		{"bound key with its tenant", withAuth, bound, "acme", "acme.docs.example.com", http.StatusOK, "acme"},
+		{"bound key with another header", withAuth, bound, "other", "", http.StatusForbidden, ""},
+		{"bound key with another subdomain", withAuth, bound, "", "other.docs.example.com", http.StatusForbidden, ""},
+		{"bound admin with another tenant", withAuth, boundAdmin, "other", "", http.StatusForbidden, ""},
+		{"admin with header", withAuth, admin, "other", "", http.StatusOK, "other"},
+		{"admin with different header and subdomain", withAuth, admin, "acme", "other.docs.example.com", http.StatusForbidden, ""},
+		{"authentication disabled", withoutAuth, "", "acme", "", http.StatusOK, "acme"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/docs", nil)
+			if test.key != "" {
+				request.Header.Set(apiKeyHeader, test.key)
+			}
+			if test.tenant != "" {
+				request.Header.Set("X-Tenant-Id", test.tenant)
+			}
+			if test.host != "" {
+				request.Host = test.host
+			}
+			recorder := httptest.NewRecorder()
+			test.router.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, test.status, recorder.Body.String())
+			}
+			if test.status == http.StatusOK && recorder.Body.String() != test.want {
+				t.Fatalf("got tenant %q, want %q", recorder.Body.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestCloseTenantClosesCollections(t *testing.T) {
+	config := &Config{CollectionName: "documents", EventHistorySize: 10}
+	tenant := newCollectionServer(nil, config)
+	tenant.collections = newServerRegistry("documents_tenant_acme_collections")
+	collection := newCollectionServer(nil, config)
+	tenants := newServerRegistry("documents_tenants")
+
+	contexts := map[string]context.Context{}
+	register := func(registry *serverRegistry, name string, server *Server) {
+		ctx, stop := context.WithCancel(context.Background())
+		contexts[name] = ctx
+		registry.servers[name] = server
+		registry.stops[name] = stop
+	}
+	register(tenants, "acme", tenant)
+	register(tenant.collections, "notes", collection)
+
+	tenants.close("acme")
+	if len(tenants.servers) != 0 || len(tenant.collections.servers) != 0 {
+		t.Fatal("servers are left in the registries")
+	}
+	for name, ctx := range contexts {
+		if ctx.Err() == nil {
+			t.Fatalf("webhooks of %s are not stopped", name)
+		}
+	}
+}
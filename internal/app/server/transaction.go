@@ -0,0 +1,139 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/EwvwGeN/assignment/internal/cache"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Transaction of the documents: the reindexer transaction and the cache changes staged until it is committed.
+// Rollback may be deferred right after the beginning, it does nothing after a successful commit
+type docTx struct {
+	sync.Mutex
+	server *Server
+	tx     *reindexer.Tx
+	saver  *cache.ActionSaver
+	done   bool
+	// Called on the rollback to undo the changes made outside of the transaction
+	undo []func()
+	// Changed documents in the state the transaction read them before their first change, nil for a missing one
+	originals map[int64]*models.Document
+	// Documents created outside of the transaction as part of the change
+	created []int64
+	// Id of the change marker written by the commit
+	marker int64
+}
+
+func (server *Server) beginTx() (*docTx, error) {
+	tx, err := server.db.BeginTx(server.config.CollectionName)
+	if err != nil {
+		return nil, err
+	}
+	return &docTx{
+		server:    server,
+		tx:        tx,
+		saver:     server.cache.NewActionSaver(),
+		originals: map[int64]*models.Document{},
+	}, nil
+}
+
+// Staging the change of the cache. The document is remembered before its first change
+func (tx *docTx) stage(action *cache.ActionProperties) {
+	tx.Lock()
+	if _, exist := tx.originals[action.DocId]; !exist {
+		// The cached documents are replaced on change, so the read one stays as it is
+		doc, _ := tx.server.findDoc(action.DocId)
+		tx.originals[action.DocId] = doc
+	}
+	tx.Unlock()
+	tx.saver.Save(action)
+}
+
+// Changed documents in the state before the transaction, the same state its checks were made on
+func (tx *docTx) before() map[int64]*models.Document {
+	tx.Lock()
+	defer tx.Unlock()
+	result := make(map[int64]*models.Document, len(tx.originals))
+	for id, doc := range tx.originals {
+		if doc != nil {
+			result[id] = doc
+		}
+	}
+	return result
+}
+
+// Reading the document with the changes made in the transaction
+func (tx *docTx) findDoc(id int64) (*models.Document, bool) {
+	doc, found := tx.server.findDoc(id)
+	if !found {
+		return nil, false
+	}
+	return tx.saver.Staged(doc)
+}
+
+// Staged actions grouped by document id
+func (tx *docTx) actions() map[int64]map[cache.Action]map[string]interface{} {
+	return tx.saver.Actions()
+}
+
+// Remembering the document created outside of the transaction, it is written to the marker of the change
+func (tx *docTx) create(id int64) {
+	tx.Lock()
+	defer tx.Unlock()
+	tx.created = append(tx.created, id)
+}
+
+// Registering the function that undoes a change made outside of the transaction
+func (tx *docTx) onRollback(undo func()) {
+	tx.Lock()
+	defer tx.Unlock()
+	tx.undo = append(tx.undo, undo)
+}
+
+// Committing the reindexer transaction together with the marker of the change and then applying the staged
+// changes to the cache. If the commit fails, the transaction is rolled back
+func (tx *docTx) Commit() error {
+	tx.Lock()
+	if tx.done {
+		tx.Unlock()
+		return nil
+	}
+	tx.done = true
+	tx.Unlock()
+	marker, err := tx.server.markChange(tx.tx, tx.actions(), tx.created)
+	if err != nil {
+		tx.tx.Rollback()
+		tx.saver.Rollback()
+		tx.runUndo()
+		return err
+	}
+	tx.marker = marker
+	if err := tx.tx.Commit(); err != nil {
+		tx.saver.Rollback()
+		tx.runUndo()
+		return err
+	}
+	tx.saver.Commit()
+	return nil
+}
+
+func (tx *docTx) Rollback() {
+	tx.Lock()
+	if tx.done {
+		tx.Unlock()
+		return
+	}
+	tx.done = true
+	tx.Unlock()
+	tx.tx.Rollback()
+	tx.saver.Rollback()
+	tx.runUndo()
+}
+
+func (tx *docTx) runUndo() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+}
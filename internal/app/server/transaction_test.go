@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/cache"
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+func TestTransactionKeepsDocumentsBeforeChange(t *testing.T) {
+	server := &Server{cache: cache.NewCache(cache.Options{LifeTime: time.Minute})}
+	defer server.cache.Close()
+	server.cache.AddDoc(&models.Document{Id: 1, Body: "before"})
+	tx := &docTx{
+		server:    server,
+		saver:     server.cache.NewActionSaver(),
+		originals: map[int64]*models.Document{},
+	}
+	tx.stage(&cache.ActionProperties{DocId: 1, Action: cache.UPDATE, Field: "Body", NewValue: "first"})
+	// A concurrent commit changes the document after the transaction read it
+	other := server.cache.NewActionSaver()
+	other.Save(&cache.ActionProperties{DocId: 1, Action: cache.UPDATE, Field: "Body", NewValue: "concurrent"})
+	other.Commit()
+	tx.stage(&cache.ActionProperties{DocId: 1, Action: cache.UPDATE, Field: "Body", NewValue: "second"})
+	tx.saver.Commit()
+
+	before := tx.before()
+	if doc := before[1]; doc == nil || doc.Body != "before" {
+		t.Fatalf("got document %+v before the transaction, want the body read by it", doc)
+	}
+	if doc := server.cache.GetDoc(1); doc == nil || doc.Body != "second" {
+		t.Fatalf("got cached document %+v after the commit", doc)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/backup"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Time the running requests are waited for on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// State of the cache saved on shutdown. Only the ids are saved, the documents are read
+// from the database again, so the snapshot can not bring stale data
+//
+// Trees: roots of the cached trees from the most recently used
+type cacheSnapshot struct {
+	Collection string  `json:"Collection"`
+	SavedAt    int64   `json:"SavedAt"`
+	Trees      []int64 `json:"Trees"`
+}
+
+// Preloading the root documents and the recently used trees before serving the requests. The trees are
+// taken from the snapshot, without it the trees of the newest root documents are assembled
+func (server *Server) warmUp() {
+	started := time.Now()
+	roots := []int64{}
+	if limit := server.config.CacheWarmupRoots; limit > 0 {
+		iterator := backup.Documents(server.db, server.config.CollectionName).
+			Where("ParentId", reindexer.EQ, 0).
+			Sort("id", true).
+			Limit(limit).
+			Exec()
+		for iterator.Next() {
+			doc := iterator.Object().(*models.Document)
+			server.cache.AddDoc(doc)
+			roots = append(roots, doc.Id)
+		}
+		if err := iterator.Error(); err != nil {
+			log.Printf("Cache warm-up: can not read root documents: %s", err)
+		}
+		iterator.Close()
+	}
+
+	treeRoots := roots
+	snapshot, err := server.readSnapshot()
+	switch {
+	case err == nil:
+		treeRoots = snapshot.Trees
+	case !errors.Is(err, os.ErrNotExist):
+		log.Printf("Cache warm-up: can not read snapshot: %s", err)
+	}
+	if limit := server.config.CacheWarmupTrees; len(treeRoots) > limit {
+		treeRoots = treeRoots[:limit]
+	}
+	// The least recently used trees are assembled first, so the order of the cache is kept
+	trees := 0
+	for i := len(treeRoots) - 1; i >= 0; i-- {
+		if doc, found := server.findDoc(treeRoots[i]); found && doc.ParentId == 0 {
+			server.rootTree(doc)
+			trees++
+		}
+	}
+	if len(roots) != 0 || trees != 0 {
+		log.Printf("Cache warm-up: %d root documents and %d trees in %s", len(roots), trees, time.Since(started))
+	}
+}
+
+func (server *Server) readSnapshot() (*cacheSnapshot, error) {
+	if server.config.CacheSnapshotFile == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(server.config.CacheSnapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	// The file of another collection is ignored
+	if snapshot.Collection != server.config.CollectionName {
+		return nil, os.ErrNotExist
+	}
+	return &snapshot, nil
+}
+
+// Saving the recently used trees to the snapshot file. The file is replaced atomically
+func (server *Server) saveSnapshot() error {
+	if server.config.CacheSnapshotFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(cacheSnapshot{
+		Collection: server.config.CollectionName,
+		SavedAt:    time.Now().UnixNano(),
+		Trees:      server.cache.RecentTrees(server.config.CacheWarmupTrees),
+	})
+	if err != nil {
+		return err
+	}
+	temp := server.config.CacheSnapshotFile + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, server.config.CacheSnapshotFile)
+}
+
+// Waiting for the running requests and saving the snapshot of the cache
+func (server *Server) shutdown(httpServer *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Can not stop the server gracefully: %s", err)
+	}
+	server.flushChanges()
+	if err := server.saveSnapshot(); err != nil {
+		log.Printf("Can not save the cache snapshot: %s", err)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/webhook"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+)
+
+// Registering a webhook. The secret is generated if it is not passed and is returned only in this response
+func (server *Server) createWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("CreateWebhook").Start(ctx.Request.Context(), "Create webhook handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		var hook models.Webhook
+		if err := ctx.ShouldBindJSON(&hook); err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		if hook.Root != 0 {
+			if _, found := server.findDoc(hook.Root); !found {
+				render(ctx, http.StatusNotFound, gin.H{"error": DocumentNotExist.Error()})
+				return
+			}
+		}
+		if err := server.webhooks.Register(&hook); err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		render(ctx, http.StatusCreated, hook)
+	}
+}
+
+func (server *Server) getWebhooks() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetWebhooks").Start(ctx.Request.Context(), "Get webhooks handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		hooks, err := server.webhooks.List()
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		for _, hook := range hooks {
+			hook.Secret = ""
+		}
+		render(ctx, http.StatusOK, hooks)
+	}
+}
+
+func (server *Server) deleteWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("DeleteWebhook").Start(ctx.Request.Context(), "Delete webhook handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		if err := server.webhooks.Unregister(id); err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, webhook.WebhookNotExist) {
+				code = http.StatusNotFound
+			}
+			render(ctx, code, gin.H{"error": err.Error()})
+			return
+		}
+		render(ctx, http.StatusOK, gin.H{"message": "ok"})
+	}
+}
+
+func (server *Server) getDeadLetters() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("GetDeadLetters").Start(ctx.Request.Context(), "Get dead letters handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		entries, err := server.webhooks.DeadLetters()
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		render(ctx, http.StatusOK, entries)
+	}
+}
+
+func (server *Server) retryDeadLetter() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		traceCtx, span_one := otel.Tracer("RetryDeadLetter").Start(ctx.Request.Context(), "Retry dead letter handler")
+		defer span_one.End()
+		*ctx.Request = *ctx.Request.WithContext(traceCtx)
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			render(ctx, http.StatusBadRequest, gin.H{"error": InvalidRequest.Error()})
+			return
+		}
+		if err := server.webhooks.Retry(id); err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, webhook.EntryNotExist) {
+				code = http.StatusNotFound
+			}
+			render(ctx, code, gin.H{"error": err.Error()})
+			return
+		}
+		render(ctx, http.StatusOK, gin.H{"message": "ok"})
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/acl"
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// Actions of the client
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+)
+
+// Types of the messages of the server
+const (
+	wsEvent        = "event"
+	wsSubscribed   = "subscribed"
+	wsUnsubscribed = "unsubscribed"
+	wsReset        = "reset"
+	wsPing         = "ping"
+	wsError        = "error"
+)
+
+// Maximum number of the missed events read from the change log on resume, the client that missed more is reset
+const wsReplayLimit = 10 * maxChangesLimit
+
+// Message of the client
+//
+// Id: document to follow, zero means all documents
+//
+// Subtree: follow the whole subtree of the document, not only the document itself
+//
+// Since: number of the last received event, the missed events are sent before the new ones
+type wsRequest struct {
+	Action  string `json:"Action"`
+	Id      int64  `json:"Id"`
+	Subtree bool   `json:"Subtree"`
+	Since   uint64 `json:"Since"`
+}
+
+// Message of the server
+//
+// Seq: for "subscribed" the number of the last published event at the moment of subscription
+type wsResponse struct {
+	Type  string        `json:"Type"`
+	Id    int64         `json:"Id,omitempty"`
+	Seq   uint64        `json:"Seq,omitempty"`
+	Event *events.Event `json:"Event,omitempty"`
+	Error string        `json:"Error,omitempty"`
+}
+
+// Subscriptions of one connection, document id to the subtree flag
+type wsSubscriptions struct {
+	sync.Mutex
+	ids map[int64]bool
+}
+
+func (subs *wsSubscriptions) match(event *events.Event) bool {
+	subs.Lock()
+	defer subs.Unlock()
+	for id, subtree := range subs.ids {
+		if matchSubscription(id, subtree, event) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSubscription(id int64, subtree bool, event *events.Event) bool {
+	if subtree {
+		return event.InSubtree(id)
+	}
+	return id == 0 || event.DocId == id
+}
+
+// Live updates of the documents over websocket. The client sends subscribe and unsubscribe messages,
+// the server sends committed events of the followed documents in the order of their numbers
+func (server *Server) serveWebSocket() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal := server.principal(ctx)
+		wsServer := websocket.Server{Handler: func(conn *websocket.Conn) {
+			server.wsSession(conn, principal)
+		}}
+		wsServer.ServeHTTP(ctx.Writer, ctx.Request)
+	}
+}
+
+// Events of the documents the principal can not read are not sent
+func (server *Server) wsSession(conn *websocket.Conn, principal *acl.Principal) {
+	defer conn.Close()
+	subs := &wsSubscriptions{ids: map[int64]bool{}}
+	sub := server.events.Subscribe(subs.match, subscriptionBuffer)
+	defer sub.Close()
+
+	requests := make(chan *wsRequest)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(requests)
+		for {
+			request := &wsRequest{}
+			if err := websocket.JSON.Receive(conn, request); err != nil {
+				return
+			}
+			select {
+			case requests <- request:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Numbers of the events already sent by replay that may still come from the live subscription
+	replayed := map[uint64]bool{}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var response []*wsResponse
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				return
+			}
+			response = server.wsHandleRequest(principal, subs, request, replayed)
+		case event, ok := <-sub.Events:
+			if !ok {
+				// The subscriber was too slow and was dropped, the client has to resume
+				websocket.JSON.Send(conn, &wsResponse{Type: wsReset})
+				return
+			}
+			message := server.wsLiveEvent(principal, event, replayed)
+			if message == nil {
+				continue
+			}
+			response = []*wsResponse{message}
+		case <-heartbeat.C:
+			response = []*wsResponse{{Type: wsPing}}
+		}
+		for _, message := range response {
+			if err := websocket.JSON.Send(conn, message); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (server *Server) wsHandleRequest(principal *acl.Principal, subs *wsSubscriptions, request *wsRequest, replayed map[uint64]bool) []*wsResponse {
+	switch request.Action {
+	case wsSubscribe:
+		if request.Id != 0 {
+			if _, found := server.findDoc(request.Id); !found && request.Since == 0 {
+				return []*wsResponse{{Type: wsError, Id: request.Id, Error: DocumentNotExist.Error()}}
+			}
+		}
+		filter := func(event *events.Event) bool {
+			return matchSubscription(request.Id, request.Subtree, event)
+		}
+		since := request.Since
+		history, head, complete := server.events.Replay(since, filter, func() {
+			subs.Lock()
+			subs.ids[request.Id] = subs.ids[request.Id] || request.Subtree
+			subs.Unlock()
+		})
+		response := []*wsResponse{{Type: wsSubscribed, Id: request.Id, Seq: head}}
+		if since == 0 {
+			return response
+		}
+		if !complete {
+			// The history of the broker is too short, the missed events are read from the change log
+			logged, found := server.loggedEvents(since, head, filter)
+			if !found {
+				response = append(response, &wsResponse{Type: wsReset, Id: request.Id})
+			} else {
+				history = logged
+			}
+		}
+		for _, event := range history {
+			replayed[event.Seq] = true
+			if server.canReadEvent(principal, event) {
+				response = append(response, &wsResponse{Type: wsEvent, Event: event})
+			}
+		}
+		return response
+	case wsUnsubscribe:
+		subs.Lock()
+		delete(subs.ids, request.Id)
+		subs.Unlock()
+		return []*wsResponse{{Type: wsUnsubscribed, Id: request.Id}}
+	}
+	return []*wsResponse{{Type: wsError, Error: InvalidRequest.Error()}}
+}
+
+// Message with the live event, nil if the event is already sent by replay or the principal can not read it
+func (server *Server) wsLiveEvent(principal *acl.Principal, event *events.Event, replayed map[uint64]bool) *wsResponse {
+	if replayed[event.Seq] {
+		delete(replayed, event.Seq)
+		return nil
+	}
+	// The live events come in the order of their numbers, the earlier replayed ones will not come
+	for seq := range replayed {
+		if seq < event.Seq {
+			delete(replayed, seq)
+		}
+	}
+	if !server.canReadEvent(principal, event) {
+		return nil
+	}
+	return &wsResponse{Type: wsEvent, Event: event}
+}
+
+// Events with the numbers after since up to head passing the filter, read from the change log.
+// False if the log can not be read or does not have all of them
+func (server *Server) loggedEvents(since, head uint64, filter events.Filter) ([]*events.Event, bool) {
+	if head-since > wsReplayLimit {
+		return nil, false
+	}
+	result := []*events.Event{}
+	for since < head {
+		list, err := server.changes.Since(since, maxChangesLimit)
+		if err != nil {
+			log.Printf("Can not read the change log: %s", err)
+			return nil, false
+		}
+		if len(list) == 0 {
+			return nil, false
+		}
+		for _, event := range list {
+			if event.Seq > head {
+				break
+			}
+			if filter(event) {
+				result = append(result, event)
+			}
+		}
+		since = list[len(list)-1].Seq
+	}
+	return result, true
+}
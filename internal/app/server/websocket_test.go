@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// Change log kept in memory, the events are written with their numbers
+type memoryLog struct {
+	list []*events.Event
+	fail bool
+}
+
+func (log *memoryLog) Open() error { return nil }
+
+func (log *memoryLog) Head() uint64 {
+	if len(log.list) == 0 {
+		return 0
+	}
+	return log.list[len(log.list)-1].Seq
+}
+
+func (log *memoryLog) Append(list []*events.Event) error {
+	log.list = append(log.list, list...)
+	return nil
+}
+
+func (log *memoryLog) Since(since uint64, limit int) ([]*events.Event, error) {
+	if log.fail {
+		return nil, errors.New("log is unavailable")
+	}
+	result := []*events.Event{}
+	for _, event := range log.list {
+		if event.Seq > since && len(result) < limit {
+			result = append(result, event)
+		}
+	}
+	return result, nil
+}
+
+// Server with the broker keeping the history of the size and the events from 1 to count
+// published and written to the change log
+func newEventServer(historySize int, count int) (*Server, *memoryLog) {
+	changes := &memoryLog{}
+	server := &Server{
+		config:  &Config{},
+		events:  events.NewBroker(historySize),
+		changes: changes,
+	}
+	for seq := 1; seq <= count; seq++ {
+		publishEvent(server, uint64(seq))
+	}
+	return server, changes
+}
+
+func publishEvent(server *Server, seq uint64) {
+	event := &events.Event{Seq: seq, Type: events.UPDATE, DocId: int64(seq)}
+	server.changes.Append([]*events.Event{event})
+	server.events.Publish([]*events.Event{event})
+}
+
+func dialWebSocket(t *testing.T, server *Server) *websocket.Conn {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", server.serveWebSocket())
+	httpServer := httptest.NewServer(router)
+	t.Cleanup(httpServer.Close)
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", "", httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// Receiving the messages until the one with the event of the number
+func receiveUntil(t *testing.T, conn *websocket.Conn, seq uint64) []*wsResponse {
+	t.Helper()
+	result := []*wsResponse{}
+	for {
+		message := &wsResponse{}
+		if err := websocket.JSON.Receive(conn, message); err != nil {
+			t.Fatalf("got %d messages before the error: %v", len(result), err)
+		}
+		if message.Type == wsPing {
+			continue
+		}
+		result = append(result, message)
+		if message.Type == wsEvent && message.Event.Seq == seq {
+			return result
+		}
+	}
+}
+
+func messageTypes(list []*wsResponse) string {
+	types := []string{}
+	for _, message := range list {
+		if message.Type == wsEvent {
+			types = append(types, message.Type+":"+strconv.FormatUint(message.Event.Seq, 10))
+			continue
+		}
+		types = append(types, message.Type)
+	}
+	return strings.Join(types, " ")
+}
+
+func TestWebSocketResume(t *testing.T) {
+	tests := []struct {
+		name        string
+		historySize int
+		want        string
+	}{
+		{"from the history", 10, "subscribed event:3 event:4 event:5"},
+		// The events 3 and 4 are gone from the history and are read from the change log
+		{"from the change log", 1, "subscribed event:3 event:4 event:5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newEventServer(test.historySize, 5)
+			conn := dialWebSocket(t, server)
+			if err := websocket.JSON.Send(conn, &wsRequest{Action: wsSubscribe, Since: 2}); err != nil {
+				t.Fatal(err)
+			}
+			replay := receiveUntil(t, conn, 5)
+			if got := messageTypes(replay); got != test.want {
+				t.Fatalf("got messages %q, want %q", got, test.want)
+			}
+			if replay[0].Seq != 5 {
+				t.Fatalf("subscribed at number %d, want 5", replay[0].Seq)
+			}
+			// The live events follow the replayed ones
+			publishEvent(server, 6)
+			if got := messageTypes(receiveUntil(t, conn, 6)); got != "event:6" {
+				t.Fatalf("got live messages %q", got)
+			}
+		})
+	}
+}
+
+func TestWebSocketResetWithoutChangeLog(t *testing.T) {
+	server, changes := newEventServer(1, 5)
+	changes.fail = true
+	subs := &wsSubscriptions{ids: map[int64]bool{}}
+	response := server.wsHandleRequest(nil, subs, &wsRequest{Action: wsSubscribe, Since: 2}, map[uint64]bool{})
+	if got := messageTypes(response); got != "subscribed reset event:5" {
+		t.Fatalf("got messages %q when the change log is unavailable", got)
+	}
+}
+
+func TestWebSocketDoesNotRepeatReplayedEvents(t *testing.T) {
+	server, _ := newEventServer(10, 3)
+	subs := &wsSubscriptions{ids: map[int64]bool{}}
+	replayed := map[uint64]bool{}
+	// The events 2 and 3 are replayed while they still wait in the channel of the live subscription
+	response := server.wsHandleRequest(nil, subs, &wsRequest{Action: wsSubscribe, Since: 1}, replayed)
+	if got := messageTypes(response); got != "subscribed event:2 event:3" {
+		t.Fatalf("got replay %q", got)
+	}
+	for _, seq := range []uint64{2, 3} {
+		if message := server.wsLiveEvent(nil, &events.Event{Seq: seq}, replayed); message != nil {
+			t.Fatalf("replayed event %d is sent again", seq)
+		}
+	}
+	if message := server.wsLiveEvent(nil, &events.Event{Seq: 4}, replayed); message == nil || message.Event.Seq != 4 {
+		t.Fatal("live event after the replay is not sent")
+	}
+	if len(replayed) != 0 {
+		t.Fatalf("numbers %v of the replayed events are kept after the live events passed them", replayed)
+	}
+
+	// The replayed event skipped by the live subscription is forgotten when a later event comes
+	server.wsHandleRequest(nil, subs, &wsRequest{Action: wsSubscribe, Since: 2}, replayed)
+	if message := server.wsLiveEvent(nil, &events.Event{Seq: 5}, replayed); message == nil || len(replayed) != 0 {
+		t.Fatalf("got message %v and replayed numbers %v after the later live event", message, replayed)
+	}
+}
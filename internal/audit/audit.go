@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/util"
+	"github.com/restream/reindexer/v3"
+)
+
+// Change of one document. For an update only the changed fields are kept,
+// for a creation Before is empty and for a deletion After is empty
+type Change struct {
+	DocId  int64       `json:"DocId"`
+	Before util.XMLMap `json:"Before"`
+	After  util.XMLMap `json:"After"`
+}
+
+// Audit record as it is returned by the API
+type Entry struct {
+	Id        int64     `json:"Id"`
+	Actor     string    `json:"Actor"`
+	ClientIp  string    `json:"ClientIp"`
+	RequestId string    `json:"RequestId"`
+	Method    string    `json:"Method"`
+	Endpoint  string    `json:"Endpoint"`
+	Path      string    `json:"Path"`
+	Status    int       `json:"Status"`
+	DocIds    []int64   `json:"DocIds"`
+	Changes   []Change  `json:"Changes"`
+	Time      time.Time `json:"Time"`
+}
+
+// Conditions of the search, empty values are not checked
+type Filter struct {
+	Actor string
+	DocId int64
+	From  time.Time
+	To    time.Time
+	Page  int
+	Limit int
+}
+
+type Log struct {
+	db        *reindexer.Reindexer
+	namespace string
+}
+
+func NewLog(db *reindexer.Reindexer, prefix string) *Log {
+	return &Log{
+		db:        db,
+		namespace: prefix + "_audit",
+	}
+}
+
+func (log *Log) Open() error {
+	return log.db.OpenNamespace(log.namespace, reindexer.DefaultNamespaceOptions(), models.AuditEntry{})
+}
+
+func (log *Log) Record(entry *Entry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	docIds := entry.DocIds
+	if docIds == nil {
+		docIds = []int64{}
+	}
+	_, err = log.db.Insert(log.namespace, &models.AuditEntry{
+		Actor:     entry.Actor,
+		ClientIp:  entry.ClientIp,
+		RequestId: entry.RequestId,
+		Method:    entry.Method,
+		Endpoint:  entry.Endpoint,
+		Path:      entry.Path,
+		Status:    entry.Status,
+		DocIds:    docIds,
+		Changes:   string(changes),
+		Time:      entry.Time.UnixNano(),
+	}, "id=serial()")
+	return err
+}
+
+// Searching the records from the newest one. Pages start from one, zero page means no pagination
+func (log *Log) Find(filter Filter) ([]*Entry, error) {
+	query := log.db.Query(log.namespace)
+	if filter.Actor != "" {
+		query = query.Where("actor", reindexer.EQ, filter.Actor)
+	}
+	if filter.DocId != 0 {
+		query = query.WhereInt64("doc_ids", reindexer.EQ, filter.DocId)
+	}
+	if !filter.From.IsZero() {
+		query = query.WhereInt64("time", reindexer.GE, filter.From.UnixNano())
+	}
+	if !filter.To.IsZero() {
+		query = query.WhereInt64("time", reindexer.LE, filter.To.UnixNano())
+	}
+	query = query.Sort("id", true)
+	if filter.Page != 0 {
+		query = query.Limit(filter.Limit).Offset((filter.Page - 1) * filter.Limit)
+	}
+	iterator := query.Exec()
+	defer iterator.Close()
+	result := []*Entry{}
+	for iterator.Next() {
+		item := iterator.Object().(*models.AuditEntry)
+		entry := &Entry{
+			Id:        item.Id,
+			Actor:     item.Actor,
+			ClientIp:  item.ClientIp,
+			RequestId: item.RequestId,
+			Method:    item.Method,
+			Endpoint:  item.Endpoint,
+			Path:      item.Path,
+			Status:    item.Status,
+			DocIds:    item.DocIds,
+			Time:      time.Unix(0, item.Time).UTC(),
+		}
+		json.Unmarshal([]byte(item.Changes), &entry.Changes)
+		result = append(result, entry)
+	}
+	return result, iterator.Error()
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"errors"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/counter"
+	"github.com/restream/reindexer/v3"
+)
+
+// Name of the format written to the header record
+const Format = "assignment-ndjson"
+
+const Version = 1
+
+// Name of the counter of the document ids
+const IdCounter = "id"
+
+var (
+	MissingHeader      = errors.New("Missing header record")
+	UnsupportedFormat  = errors.New("Unsupported backup format")
+	UnsupportedVersion = errors.New("Unsupported backup version")
+	UnknownPolicy      = errors.New("Unknown conflict policy")
+	CountMismatch      = errors.New("Number of documents does not match the header")
+	InconsistentDump   = errors.New("Links between the documents of the dump are inconsistent")
+	ConflictingTree    = errors.New("Overwritten document has links to documents outside of its tree")
+	InvalidId          = errors.New("Document id must be positive")
+)
+
+// The first record of the dump
+//
+// Serial: value of the id serial counter at the moment of the export
+//
+// Count: number of document records following the header
+type Header struct {
+	Format     string    `json:"Format"`
+	Version    int       `json:"Version"`
+	Collection string    `json:"Collection"`
+	Serial     int64     `json:"Serial"`
+	Count      int       `json:"Count"`
+	CreatedAt  time.Time `json:"CreatedAt"`
+}
+
+func (header *Header) check() error {
+	if header.Format != Format {
+		return UnsupportedFormat
+	}
+	if header.Version != Version {
+		return UnsupportedVersion
+	}
+	return nil
+}
+
+// Opening the counters of the collection with the id counter moved past the ids of the documents.
+// The ids of new documents of the namespace must be reserved with the id counter
+func OpenIds(db *reindexer.Reindexer, namespace string) (*counter.Store, error) {
+	counters := counter.NewStore(db, namespace)
+	if err := counters.Open(); err != nil {
+		return nil, err
+	}
+	return counters, counters.Follow(IdCounter, namespace, "id")
+}
+
+// Query of the documents of the namespace. The records with negative ids kept in the namespace by the server
+// are not documents and are skipped
+func Documents(db *reindexer.Reindexer, namespace string) *reindexer.Query {
+	return db.Query(namespace).WhereInt64("id", reindexer.GT, 0)
+}
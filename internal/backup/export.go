@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Writes the header and then every document of the namespace ordered by id, one json object per line
+func Export(db *reindexer.Reindexer, namespace string, w io.Writer) (*Header, error) {
+	counters, err := OpenIds(db, namespace)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := counters.Value(IdCounter)
+	if err != nil {
+		return nil, err
+	}
+	iterator := Documents(db, namespace).Sort("id", false).ReqTotal().Exec()
+	defer iterator.Close()
+	if err := iterator.Error(); err != nil {
+		return nil, err
+	}
+	header := &Header{
+		Format:     Format,
+		Version:    Version,
+		Collection: namespace,
+		Serial:     serial,
+		Count:      iterator.TotalCount(),
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	buffer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffer)
+	if err := encoder.Encode(header); err != nil {
+		return nil, err
+	}
+	for iterator.Next() {
+		if err := encoder.Encode(iterator.Object().(*models.Document)); err != nil {
+			return nil, err
+		}
+	}
+	if err := iterator.Error(); err != nil {
+		return nil, err
+	}
+	return header, buffer.Flush()
+}
@@ -0,0 +1,392 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// What to do with a document whose id already exists in the namespace
+type Policy string
+
+// The policy is applied to the whole tree of the dump containing the conflicting document, so the links
+// of the written documents always agree with each other
+const (
+	// The existing documents are kept, the tree is not imported
+	Skip Policy = "skip"
+	// The existing documents are replaced by the tree. The import is rejected if a replaced document
+	// has another parent or children outside of the tree
+	Overwrite Policy = "overwrite"
+	// All documents of the tree get new ids, the links between them are rewritten
+	Remap Policy = "remap"
+)
+
+func ParsePolicy(value string) (Policy, error) {
+	switch policy := Policy(value); policy {
+	case Skip, Overwrite, Remap:
+		return policy, nil
+	case "":
+		return Skip, nil
+	}
+	return "", fmt.Errorf("%w: %s", UnknownPolicy, value)
+}
+
+type Options struct {
+	Policy Policy
+	// Only the report is built, nothing is written
+	DryRun bool
+	// Called with the report and the documents to write before they are written, also on a dry run.
+	// An error cancels the import
+	Check func(report *Report, docs []*models.Document) error
+	// Called with the transaction after the documents are written to it, the written records are committed
+	// together with the documents. An error cancels the import
+	Mark func(tx *reindexer.Tx, report *Report) error
+}
+
+// Result of the import
+//
+// Remapped: old id to new id for the documents moved by the remap policy
+//
+// Written: ids of the documents that were written to the namespace
+//
+// Created, Replaced: written documents that are new and that replaced the existing ones
+type Report struct {
+	Header      *Header            `json:"Header"`
+	DryRun      bool               `json:"DryRun"`
+	Inserted    int                `json:"Inserted"`
+	Overwritten int                `json:"Overwritten"`
+	Skipped     int                `json:"Skipped"`
+	Remapped    Remapping          `json:"Remapped"`
+	Serial      int64              `json:"Serial"`
+	Written     []int64            `json:"-" yaml:"-" xml:"-"`
+	Created     []*models.Document `json:"-" yaml:"-" xml:"-"`
+	Replaced    []*models.Document `json:"-" yaml:"-" xml:"-"`
+}
+
+// Old id to new id. In XML every pair is an Id element with the Old and New attributes,
+// since encoding/xml can not write maps
+type Remapping map[int64]int64
+
+func (remapping Remapping) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	ids := make([]int64, 0, len(remapping))
+	for id := range remapping {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		pair := xml.StartElement{
+			Name: xml.Name{Local: "Id"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "Old"}, Value: strconv.FormatInt(id, 10)},
+				{Name: xml.Name{Local: "New"}, Value: strconv.FormatInt(remapping[id], 10)},
+			},
+		}
+		if err := encoder.EncodeElement(struct{}{}, pair); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// Reads the dump written by Export and loads it into the namespace in one transaction.
+// The id counter is moved forward so that it is never lower than the one saved in the header
+// and the ids of the dump
+func Import(db *reindexer.Reindexer, namespace string, r io.Reader, options Options) (*Report, error) {
+	header, docs, err := read(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{
+		Header:   header,
+		DryRun:   options.DryRun,
+		Remapped: Remapping{},
+	}
+
+	trees, err := splitTrees(docs)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(docs))
+	maxId := header.Serial
+	for _, doc := range docs {
+		if doc.Id <= 0 {
+			return nil, fmt.Errorf("%w: %d", InvalidId, doc.Id)
+		}
+		ids = append(ids, doc.Id)
+		if doc.Id > maxId {
+			maxId = doc.Id
+		}
+	}
+	existing, err := existingDocs(db, namespace, ids)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := OpenIds(db, namespace)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := counters.Value(IdCounter)
+	if err != nil {
+		return nil, err
+	}
+	if serial > maxId {
+		maxId = serial
+	}
+
+	write := make([]*models.Document, 0, len(docs))
+	replaced := map[*models.Document]bool{}
+	remapped := []int64{}
+	for _, tree := range trees {
+		conflicts := 0
+		for _, doc := range tree {
+			if existing[doc.Id] != nil {
+				conflicts++
+			}
+		}
+		if conflicts == 0 {
+			report.Inserted += len(tree)
+			write = append(write, tree...)
+			continue
+		}
+		switch options.Policy {
+		case Skip:
+			report.Skipped += len(tree)
+			continue
+		case Overwrite:
+			if err := checkOverwrite(tree, existing); err != nil {
+				return nil, err
+			}
+			for _, doc := range tree {
+				replaced[doc] = existing[doc.Id] != nil
+			}
+			report.Overwritten += conflicts
+			report.Inserted += len(tree) - conflicts
+		case Remap:
+			for _, doc := range tree {
+				remapped = append(remapped, doc.Id)
+			}
+			report.Inserted += len(tree)
+		default:
+			return nil, fmt.Errorf("%w: %s", UnknownPolicy, options.Policy)
+		}
+		write = append(write, tree...)
+	}
+	// The new ids follow the ids of the dump, they are reserved only when the dump is written
+	next := maxId + 1
+	if !options.DryRun {
+		if err := counters.Raise(IdCounter, maxId); err != nil {
+			return nil, err
+		}
+		if len(remapped) != 0 {
+			if next, err = counters.Reserve(IdCounter, len(remapped)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, id := range remapped {
+		report.Remapped[id] = next + int64(i)
+	}
+	remap(write, report.Remapped)
+	report.Serial = maxId
+	if len(remapped) != 0 {
+		report.Serial = next + int64(len(remapped)) - 1
+	}
+	if options.Check != nil {
+		if err := options.Check(report, write); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.DryRun {
+		return report, nil
+	}
+	tx, err := db.BeginTx(namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range write {
+		if err := tx.Upsert(doc); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		report.Written = append(report.Written, doc.Id)
+		if replaced[doc] {
+			report.Replaced = append(report.Replaced, doc)
+		} else {
+			report.Created = append(report.Created, doc)
+		}
+	}
+	if options.Mark != nil {
+		if err := options.Mark(tx, report); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func read(r io.Reader) (*Header, []*models.Document, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, MissingHeader
+	}
+	header := &Header{}
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", MissingHeader, err)
+	}
+	if err := header.check(); err != nil {
+		return nil, nil, err
+	}
+
+	docs := make([]*models.Document, 0, header.Count)
+	line := 1
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		doc := &models.Document{}
+		if err := json.Unmarshal(scanner.Bytes(), doc); err != nil {
+			return nil, nil, fmt.Errorf("Line %d: %w", line, err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(docs) != header.Count {
+		return nil, nil, fmt.Errorf("%w: expected %d, got %d", CountMismatch, header.Count, len(docs))
+	}
+	return header, docs, nil
+}
+
+func existingDocs(db *reindexer.Reindexer, namespace string, ids []int64) (map[int64]*models.Document, error) {
+	existing := map[int64]*models.Document{}
+	if len(ids) == 0 {
+		return existing, nil
+	}
+	iterator := db.Query(namespace).WhereInt64("id", reindexer.SET, ids...).Exec()
+	defer iterator.Close()
+	for iterator.Next() {
+		doc := iterator.Object().(*models.Document)
+		existing[doc.Id] = doc
+	}
+	return existing, iterator.Error()
+}
+
+// Grouping the documents of the dump by the trees of the documents without a parent, every tree starts
+// with its root. The parent and the children of every document must be in the dump and link back to it
+func splitTrees(docs []*models.Document) ([][]*models.Document, error) {
+	byId := make(map[int64]*models.Document, len(docs))
+	for _, doc := range docs {
+		if _, repeated := byId[doc.Id]; repeated {
+			return nil, fmt.Errorf("%w: document %d is repeated", InconsistentDump, doc.Id)
+		}
+		byId[doc.Id] = doc
+	}
+	for _, doc := range docs {
+		if doc.ParentId != 0 {
+			parent, exist := byId[doc.ParentId]
+			if !exist || !hasChild(parent, doc.Id) {
+				return nil, fmt.Errorf("%w: document %d is not a child of its parent %d", InconsistentDump, doc.Id, doc.ParentId)
+			}
+		}
+		for _, childId := range doc.ChildList {
+			if child, exist := byId[childId]; !exist || child.ParentId != doc.Id {
+				return nil, fmt.Errorf("%w: child %d of document %d has another parent", InconsistentDump, childId, doc.Id)
+			}
+		}
+	}
+	trees := [][]*models.Document{}
+	count := 0
+	var collect func(doc *models.Document, tree []*models.Document) []*models.Document
+	collect = func(doc *models.Document, tree []*models.Document) []*models.Document {
+		tree = append(tree, doc)
+		for _, childId := range doc.ChildList {
+			tree = collect(byId[childId], tree)
+		}
+		return tree
+	}
+	for _, doc := range docs {
+		if doc.ParentId == 0 {
+			tree := collect(doc, nil)
+			count += len(tree)
+			trees = append(trees, tree)
+		}
+	}
+	// The documents linked in a cycle are not reachable from any root
+	if count != len(docs) {
+		return nil, fmt.Errorf("%w: documents are linked in a cycle", InconsistentDump)
+	}
+	return trees, nil
+}
+
+func hasChild(doc *models.Document, id int64) bool {
+	for _, childId := range doc.ChildList {
+		if childId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Checking that the existing documents replaced by the tree are linked only with the documents of the tree:
+// they have the same parent as in the dump and all their children are in the tree
+func checkOverwrite(tree []*models.Document, existing map[int64]*models.Document) error {
+	inTree := make(map[int64]bool, len(tree))
+	for _, doc := range tree {
+		inTree[doc.Id] = true
+	}
+	for _, doc := range tree {
+		old := existing[doc.Id]
+		if old == nil {
+			continue
+		}
+		if old.ParentId != doc.ParentId {
+			return fmt.Errorf("%w: document %d has the parent %d", ConflictingTree, doc.Id, old.ParentId)
+		}
+		for _, childId := range old.ChildList {
+			if !inTree[childId] {
+				return fmt.Errorf("%w: document %d has the child %d", ConflictingTree, doc.Id, childId)
+			}
+		}
+	}
+	return nil
+}
+
+// Rewriting the ids and all links to them
+func remap(docs []*models.Document, ids map[int64]int64) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, doc := range docs {
+		if newId, ok := ids[doc.Id]; ok {
+			doc.Id = newId
+		}
+		if newId, ok := ids[doc.ParentId]; ok {
+			doc.ParentId = newId
+		}
+		for i, childId := range doc.ChildList {
+			if newId, ok := ids[childId]; ok {
+				doc.ChildList[i] = newId
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+func doc(id, parentId int64, childs ...int64) *models.Document {
+	return &models.Document{Id: id, ParentId: parentId, ChildList: childs}
+}
+
+func TestSplitTrees(t *testing.T) {
+	trees, err := splitTrees([]*models.Document{
+		doc(3, 1), doc(1, 0, 2, 3), doc(4, 0), doc(2, 1, 5), doc(5, 2),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]int64{{1, 2, 5, 3}, {4}}
+	if len(trees) != len(want) {
+		t.Fatalf("got %d trees, want %d", len(trees), len(want))
+	}
+	for i, tree := range trees {
+		if len(tree) != len(want[i]) {
+			t.Fatalf("tree %d has %d documents, want %d", i, len(tree), len(want[i]))
+		}
+		for j, doc := range tree {
+			if doc.Id != want[i][j] {
+				t.Fatalf("tree %d has document %d at %d, want %d", i, doc.Id, j, want[i][j])
+			}
+		}
+	}
+}
+
+func TestSplitTreesRejectsInconsistentDump(t *testing.T) {
+	tests := map[string][]*models.Document{
+		"repeated id":          {doc(1, 0), doc(1, 0)},
+		"missing parent":       {doc(2, 1)},
+		"parent without child": {doc(1, 0), doc(2, 1)},
+		"missing child":        {doc(1, 0, 2)},
+		"child of another":     {doc(1, 0, 3), doc(2, 0, 3), doc(3, 2)},
+		"cycle":                {doc(1, 2, 2), doc(2, 1, 1)},
+	}
+	for name, docs := range tests {
+		if _, err := splitTrees(docs); !errors.Is(err, InconsistentDump) {
+			t.Errorf("%s: got error %v, want %v", name, err, InconsistentDump)
+		}
+	}
+}
+
+func TestCheckOverwrite(t *testing.T) {
+	tree := []*models.Document{doc(1, 0, 2), doc(2, 1)}
+	tests := []struct {
+		name     string
+		existing map[int64]*models.Document
+		err      error
+	}{
+		{"same links", map[int64]*models.Document{1: doc(1, 0, 2), 2: doc(2, 1)}, nil},
+		{"fewer children", map[int64]*models.Document{1: doc(1, 0)}, nil},
+		{"only a leaf", map[int64]*models.Document{2: doc(2, 1)}, nil},
+		{"child outside of the tree", map[int64]*models.Document{1: doc(1, 0, 2, 7)}, ConflictingTree},
+		{"another parent", map[int64]*models.Document{1: doc(1, 9, 2)}, ConflictingTree},
+		{"leaf moved", map[int64]*models.Document{2: doc(2, 8)}, ConflictingTree},
+	}
+	for _, test := range tests {
+		if err := checkOverwrite(tree, test.existing); !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+	}
+}
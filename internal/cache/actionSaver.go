@@ -1,6 +1,10 @@
 package cache
 
-import "sync"
+import (
+	"sync"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
 
 type Action string
 
@@ -9,7 +13,7 @@ const (
 	UPDATE Action = "update"
 )
 
-//i think this way to transfer action is better then map[id]map[action]map[field]newValue
+// i think this way to transfer action is better then map[id]map[action]map[field]newValue
 // or map[id]map[action][]properties{field, newValue}
 //
 // DocId: identifier of the document
@@ -19,6 +23,8 @@ const (
 // Field: changeable field
 //
 // NewValue: new value for field
+//
+// For the delete action Field and NewValue may keep the ParentId the document had before deletion
 type ActionProperties struct {
 	DocId    int64
 	Action   Action
@@ -26,61 +32,108 @@ type ActionProperties struct {
 	NewValue interface{}
 }
 
-type docActionSaver struct {
-	Channel       chan *ActionProperties
+// Changes of the cache staged until the transaction is committed. The actions may be saved
+// from several goroutines. After Commit or Rollback the saver accepts no more actions
+type ActionSaver struct {
+	sync.Mutex
 	actionStorage map[int64]map[Action]map[string]interface{}
 	workingСache  *Cache
+	done          bool
 }
 
-func (cache *Cache) NewActionSaver() *docActionSaver {
-	newSaver := &docActionSaver{
-		Channel:       make(chan *ActionProperties),
+func (cache *Cache) NewActionSaver() *ActionSaver {
+	return &ActionSaver{
 		actionStorage: make(map[int64]map[Action]map[string]interface{}),
 		workingСache:  cache,
 	}
-	newSaver.controller()
-	return newSaver
 }
 
-func (das *docActionSaver) controller() {
-	go func() {
-		for {
-			input := <-das.Channel
-			if input == nil {
-				continue
-			}
-			if input.Action == "" {
-				continue
-			}
-			if das.actionStorage[input.DocId] == nil {
-				das.actionStorage[input.DocId] = map[Action]map[string]interface{}{
-					input.Action: {
-						input.Field: input.NewValue,
-					},
-				}
-				continue
-			}
-			switch input.Action {
-			case DELETE:
-				das.actionStorage[input.DocId] = map[Action]map[string]interface{}{
-					DELETE: nil,
-				}
-			case UPDATE:
-				das.actionStorage[input.DocId][input.Action][input.Field] = input.NewValue
+// Staging the action. An update of a deleted document is ignored, a deletion replaces the updates
+func (das *ActionSaver) Save(input *ActionProperties) {
+	if input == nil || input.Action == "" {
+		return
+	}
+	das.Lock()
+	defer das.Unlock()
+	if das.done {
+		return
+	}
+	switch input.Action {
+	case DELETE:
+		var properties map[string]interface{}
+		if input.Field != "" {
+			properties = map[string]interface{}{
+				input.Field: input.NewValue,
 			}
 		}
-	}()
+		das.actionStorage[input.DocId] = map[Action]map[string]interface{}{
+			DELETE: properties,
+		}
+	case UPDATE:
+		actions := das.actionStorage[input.DocId]
+		if actions == nil {
+			actions = map[Action]map[string]interface{}{}
+			das.actionStorage[input.DocId] = actions
+		}
+		if _, deleted := actions[DELETE]; deleted {
+			return
+		}
+		if actions[UPDATE] == nil {
+			actions[UPDATE] = map[string]interface{}{}
+		}
+		actions[UPDATE][input.Field] = input.NewValue
+	}
 }
 
-func (das *docActionSaver) Rollback() {
-	das = nil
+// Applying the staged actions to a copy of the document, so the code of the transaction reads its own writes.
+// Returns false if the document is deleted in the transaction or does not exist
+func (das *ActionSaver) Staged(doc *models.Document) (*models.Document, bool) {
+	if doc == nil {
+		return nil, false
+	}
+	staged := copyDoc(doc)
+	das.Lock()
+	defer das.Unlock()
+	actions := das.actionStorage[doc.Id]
+	if _, deleted := actions[DELETE]; deleted {
+		return nil, false
+	}
+	applyFields(staged, actions[UPDATE])
+	return staged, true
 }
 
-func (das *docActionSaver) Commit() {
+// Dropping the staged actions, the cache is not changed. Does nothing after Commit
+func (das *ActionSaver) Rollback() {
+	das.Lock()
+	defer das.Unlock()
+	if das.done {
+		return
+	}
+	das.done = true
+	das.actionStorage = make(map[int64]map[Action]map[string]interface{})
+}
+
+// Applying the staged actions to the cache. Must be called after the commit of the database transaction
+func (das *ActionSaver) Commit() {
+	das.Lock()
+	das.done = true
+	das.Unlock()
 	das.innerCommit()
+	ids := make([]int64, 0, len(das.actionStorage))
+	for id := range das.actionStorage {
+		ids = append(ids, id)
+	}
+	das.workingСache.notifyCommit(ids)
+}
+
+// Saved actions grouped by document id. Must not be called while actions are still being saved
+func (das *ActionSaver) Actions() map[int64]map[Action]map[string]interface{} {
+	das.Lock()
+	defer das.Unlock()
+	return das.actionStorage
 }
 
-func (das *docActionSaver) innerCommit() {
+func (das *ActionSaver) innerCommit() {
 	wg := new(sync.WaitGroup)
 	wg.Add(len(das.actionStorage))
 	for id, v := range das.actionStorage {
@@ -88,9 +141,9 @@ func (das *docActionSaver) innerCommit() {
 			for action, properties := range inputMap {
 				switch action {
 				case DELETE:
-					das.workingСache.innerDelDoc(id)
+					das.workingСache.DelDoc(id)
 				case UPDATE:
-					das.workingСache.innerUpdateDoc(id, properties)
+					das.workingСache.UpdateDoc(id, properties)
 				}
 			}
 			wg.Done()
@@ -2,225 +2,140 @@ package cache
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/EwvwGeN/assignment/internal/models"
-	"github.com/EwvwGeN/assignment/internal/util"
-)
-
-type status int
-
-// Cache Statuses
-const (
-	unoccupied status = 0
-	working    status = 1
-	awaitLock  status = 2
-)
-
-// Values for checking cache usage
-const (
-	startWork  = 1
-	cancelWork = -1
-	update     = 0
+	"github.com/EwvwGeN/assignment/internal/typedcache"
 )
 
+// LifeTime: time the document is kept after the last access, for the TTL policy after the last write
+//
+// CleaningInterval: interval of removing the expired documents, they are not removed if it is zero
+//
+// MaxEntries, MaxBytes: limits of the number and the approximate size of all documents, zero means no limit.
+// A document larger than MaxBytes is not cached
+//
+// Policy: rule of choosing the document to evict when a limit is reached
+//
+// NegativeTtl: time the ids of the documents that were not found are remembered, zero disables it
+type Options struct {
+	LifeTime         time.Duration
+	CleaningInterval time.Duration
+	MaxEntries       int
+	MaxBytes         int64
+	Policy           Policy
+	NegativeTtl      time.Duration
+}
+
+// Documents by id and the big documents assembled from them. A change of a document
+// drops it together with the tree containing it
 type Cache struct {
-	sync.RWMutex
-	state            status
-	lifeTime         time.Duration
+	docs             *typedcache.Cache[int64, *models.Document]
 	cleaningInterval time.Duration
-	innerAction      chan int
-	lockChan         chan chan bool
-	docsConroller    map[int64]*extDoc
+	maxEntries       int
+	maxBytes         int64
+	trees            *treeCache
+	done             chan struct{}
+	closeOnce        sync.Once
+	// Called with the ids of the documents changed by every commit
+	notifier atomic.Value
 }
 
-type extDoc struct {
-	sync.RWMutex
-	expiration int64
-	doc        *models.Document
-}
-
-func NewCache(lifeTime, cleaningInterval time.Duration) *Cache {
+// An unknown policy is replaced with LRU
+func NewCache(options Options) *Cache {
 	cache := &Cache{
-		state:            unoccupied,
-		lifeTime:         lifeTime,
-		cleaningInterval: cleaningInterval,
-		innerAction:      make(chan int),
-		lockChan:         make(chan chan bool),
-		docsConroller:    make(map[int64]*extDoc),
+		docs: typedcache.New(typedcache.Options[int64, *models.Document]{
+			LifeTime:         options.LifeTime,
+			CleaningInterval: options.CleaningInterval,
+			MaxEntries:       options.MaxEntries,
+			MaxBytes:         options.MaxBytes,
+			Policy:           options.Policy,
+			NegativeTtl:      options.NegativeTtl,
+			Size: func(id int64, doc *models.Document) int64 {
+				return docSize(doc)
+			},
+		}),
+		cleaningInterval: options.CleaningInterval,
+		maxEntries:       options.MaxEntries,
+		maxBytes:         options.MaxBytes,
+		trees:            newTreeCache(options),
+		done:             make(chan struct{}),
 	}
 
-	// Starting the garbage collector at a non-zero cleaning time
-	if cleaningInterval > 0 {
+	// The documents and the trees are cleaned by their own caches, here only the index of the tree nodes
+	if options.CleaningInterval > 0 {
 		go cache.garbageCollector()
 	}
 
-	go cache.controller()
-
 	return cache
 }
 
-func (cache *Cache) controller() {
-	// List of channels waiting to be accessed to deleting
-	var chans []chan bool
-	// Channel for communication of the goroutine controller
-	cChan := make(chan bool)
-	// The goroutine is monitors the use of the cache and indicates its status
-	go func() {
-		usageCount := 0
-		for {
-			usageCount += <-cache.innerAction
-			if usageCount != 0 && cache.state == awaitLock {
-				continue
-			}
-			if usageCount == 0 && cache.state != awaitLock {
-				cache.state = unoccupied
-				continue
-			}
-			if usageCount == 0 && cache.state == awaitLock {
-				cChan <- true
-				<-cChan
-				if len(chans) == 0 {
-					cache.state = unoccupied
-				}
-				continue
-			}
-			cache.state = working
-		}
-	}()
-	// The goroutine is reads channels that are waiting for access to delete
-	go func() {
-		for {
-			chans = append(chans, <-cache.lockChan)
-			cache.state = awaitLock
-			cache.innerAction <- update
-		}
-	}()
-	// The goroutine is transfers access to a channel waiting for deletion access
-	go func() {
-		for {
-			<-cChan
-			for len(chans) != 0 {
-				chans[0] <- true
-				<-chans[0]
-				chans = chans[1:]
-			}
-			cChan <- true
-		}
-	}()
+// Setting the function called with the ids of the documents changed by every committed transaction
+func (cache *Cache) OnCommit(notify func(ids []int64)) {
+	cache.notifier.Store(notify)
 }
 
-// When the cleaning time comes, it checks for the expiration date of the cache and passes the received keys for deletion
-func (cache *Cache) garbageCollector() {
-	for {
-		<-time.After(cache.cleaningInterval)
-		if cache.docsConroller == nil {
-			return
-		}
-		if keys := cache.checkExpired(); len(keys) != 0 {
-			cache.clearExpired(keys)
-		}
+func (cache *Cache) notifyCommit(ids []int64) {
+	if notify, exist := cache.notifier.Load().(func(ids []int64)); exist && notify != nil {
+		notify(ids)
 	}
 }
 
-func (cache *Cache) checkExpired() (keys []int64) {
-	keys = []int64{}
-	waiter := make(chan bool)
-	cache.lockChan <- waiter
-	<-waiter
-	cache.RLock()
-	for i, dc := range cache.docsConroller {
-		if time.Now().UnixNano() > dc.expiration && dc.expiration > 0 {
-			keys = append(keys, i)
+// When the cleaning time comes, it removes the nodes of the expired trees from the index
+func (cache *Cache) garbageCollector() {
+	ticker := time.NewTicker(cache.cleaningInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-cache.done:
+			return
 		}
+		cache.trees.clearRoots()
 	}
-	cache.RUnlock()
-	waiter <- true
-	return keys
 }
 
-func (cache *Cache) clearExpired(keys []int64) {
-	waiter := make(chan bool)
-	cache.lockChan <- waiter
-	<-waiter
-	cache.RLock()
-	for _, i := range keys {
-		delete(cache.docsConroller, i)
-	}
-	cache.RUnlock()
-	waiter <- true
-}
-
-func (cache *Cache) checkExist(id int64) bool {
-	if cache.docsConroller[id] == nil {
-		return false
-	}
-	return true
+// Stopping the garbage collectors of the cache that is no longer used
+func (cache *Cache) Close() {
+	cache.closeOnce.Do(func() {
+		close(cache.done)
+	})
+	cache.docs.Close()
+	cache.trees.cached.Close()
 }
 
 func (cache *Cache) AddDoc(doc *models.Document) {
-	cache.innerAddDoc(doc)
-}
-
-func (cache *Cache) innerAddDoc(doc *models.Document) {
-	id := doc.Id
-	cache.docsConroller[id] = &extDoc{
-		expiration: time.Now().Add(cache.lifeTime).UnixNano(),
-		doc:        doc,
-	}
+	cache.docs.Set(doc.Id, doc)
 }
 
 func (cache *Cache) DelDoc(id int64) {
-	cache.innerDelDoc(id)
-}
-
-func (cache *Cache) innerDelDoc(id int64) {
-	if !cache.checkExist(id) {
-		return
-	}
-	cache.docsConroller[id].Lock()
-	cache.docsConroller[id].Unlock()
-	delete(cache.docsConroller, id)
+	cache.invalidateTree(id)
+	cache.docs.Delete(id)
 }
 
 func (cache *Cache) GetDoc(id int64) *models.Document {
-	return cache.innerGetDoc(id)
+	doc, _ := cache.docs.Get(id)
+	return doc
 }
 
-func (cache *Cache) innerGetDoc(id int64) *models.Document {
-	if !cache.checkExist(id) {
-		return nil
-	}
-	for cache.state == awaitLock {
-	}
-	cache.innerAction <- startWork
-	cache.docsConroller[id].Lock()
-	if !cache.checkExist(id) {
-		return nil
-	}
-	buffer := cache.docsConroller[id]
-	cache.docsConroller[id].expiration = time.Now().Add(cache.lifeTime).UnixNano()
-	cache.docsConroller[id].Unlock()
-	cache.innerAction <- cancelWork
-	return buffer.doc
+// Returning the document from the cache or loading it, see typedcache.Cache.Load
+func (cache *Cache) Load(id int64, load func() (*models.Document, bool)) (*models.Document, bool) {
+	return cache.docs.Load(id, load)
 }
 
+// Replacing the document with a copy that has the new values of the fields. The cached tree
+// of the document is dropped even if the document itself is not cached
 func (cache *Cache) UpdateDoc(id int64, updFields map[string]interface{}) {
-	cache.innerUpdateDoc(id, updFields)
-}
-
-func (cache *Cache) innerUpdateDoc(id int64, updFields map[string]interface{}) {
-	if !cache.checkExist(id) {
-		return
-	}
-	for cache.state == awaitLock {
-	}
-	cache.innerAction <- startWork
-	cache.docsConroller[id].RLock()
-	for field, value := range updFields {
-		util.SetValueByName(cache.docsConroller[id].doc, field, value)
+	cache.invalidateTree(id)
+	consistent := true
+	cache.docs.Update(id, func(doc *models.Document) *models.Document {
+		updated := copyDoc(doc)
+		consistent = applyFields(updated, updFields)
+		return updated
+	})
+	// The document is read again instead of keeping the value the database does not have
+	if !consistent {
+		cache.docs.Delete(id)
 	}
-	cache.docsConroller[id].expiration = time.Now().Add(cache.lifeTime).UnixNano()
-	cache.docsConroller[id].RUnlock()
-	cache.innerAction <- cancelWork
 }
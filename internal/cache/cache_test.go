@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+// Run with -race, the readers check that a returned document is never changed by the writers
+func TestCacheConcurrentAccess(t *testing.T) {
+	cache := NewCache(Options{
+		LifeTime:         time.Millisecond,
+		CleaningInterval: time.Millisecond,
+		MaxEntries:       64,
+		Policy:           LRU,
+	})
+	defer cache.Close()
+
+	const (
+		workers    = 8
+		iterations = 2000
+		ids        = 128
+	)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(seed))
+			for i := 0; i < iterations; i++ {
+				id := random.Int63n(ids) + 1
+				switch random.Intn(6) {
+				case 0:
+					cache.AddDoc(&models.Document{Id: id, Body: "body", ChildList: []int64{id + 1, id + 2}})
+				case 1:
+					cache.UpdateDoc(id, map[string]interface{}{
+						"Body":      "updated",
+						"Depth":     float64(random.Intn(4)),
+						"ChildList": []interface{}{float64(id + 3)},
+					})
+				case 2:
+					cache.DelDoc(id)
+				case 3:
+					cache.AddTree(&models.BigDocument{Id: id, ChildList: []models.BigDocument{{Id: id + 1}}}, cache.TreeGeneration(id))
+					cache.GetTree(id)
+				case 4:
+					// The documents and the trees are cleared by the collectors of their caches every millisecond
+					cache.trees.clearRoots()
+				default:
+					if doc := cache.GetDoc(id); doc != nil {
+						if doc.Id != id {
+							t.Errorf("got document %d by id %d", doc.Id, id)
+						}
+						for _, child := range doc.ChildList {
+							if child <= id {
+								t.Errorf("document %d has unexpected child %d", id, child)
+							}
+						}
+					}
+				}
+			}
+		}(int64(worker))
+	}
+	wg.Wait()
+}
+
+func TestUpdateDocKeepsReturnedCopy(t *testing.T) {
+	cache := NewCache(Options{LifeTime: time.Minute})
+	defer cache.Close()
+
+	cache.AddDoc(&models.Document{Id: 1, Body: "before", ChildList: []int64{2, 3}})
+	doc := cache.GetDoc(1)
+	cache.UpdateDoc(1, map[string]interface{}{"Body": "after", "ChildList": []int64{4}})
+	if doc.Body != "before" || len(doc.ChildList) != 2 {
+		t.Fatalf("returned document was changed by the update: %+v", doc)
+	}
+	if updated := cache.GetDoc(1); updated.Body != "after" || len(updated.ChildList) != 1 || updated.ChildList[0] != 4 {
+		t.Fatalf("unexpected updated document: %+v", updated)
+	}
+	cache.DelDoc(1)
+	if cache.GetDoc(1) != nil {
+		t.Fatal("deleted document is still cached")
+	}
+}
+
+func TestTreeAssembledDuringChange(t *testing.T) {
+	cache := NewCache(Options{LifeTime: time.Minute})
+	defer cache.Close()
+	tree := &models.BigDocument{Id: 1, ChildList: []models.BigDocument{{Id: 2}}}
+
+	// A change of a document of another tree does not prevent saving the tree
+	generation := cache.TreeGeneration(1)
+	cache.DelDoc(10)
+	cache.AddTree(tree, generation)
+	if cache.GetTree(1) == nil {
+		t.Fatal("tree was not saved after a change of another tree")
+	}
+
+	cache.DelDoc(2)
+	if cache.GetTree(1) != nil {
+		t.Fatal("tree was not dropped by a change of its node")
+	}
+	generation = cache.TreeGeneration(1)
+	cache.UpdateDoc(2, map[string]interface{}{"Body": "changed"})
+	cache.AddTree(tree, generation)
+	if cache.GetTree(1) != nil {
+		t.Fatal("tree was saved after a change of its node during the assembly")
+	}
+}
+
+func TestUpdateDocWithUnexpectedType(t *testing.T) {
+	cache := NewCache(Options{LifeTime: time.Minute})
+	defer cache.Close()
+
+	cache.AddDoc(&models.Document{Id: 1, Body: "before"})
+	cache.UpdateDoc(1, map[string]interface{}{"Body": 42})
+	if doc := cache.GetDoc(1); doc != nil {
+		t.Fatalf("document with a value that was not applied is still cached: %+v", doc)
+	}
+}
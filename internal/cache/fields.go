@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"log"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/util"
+)
+
+// Copy of the document that can be changed without changing the cached one
+func copyDoc(doc *models.Document) *models.Document {
+	copied := *doc
+	if doc.ChildList != nil {
+		copied.ChildList = append(make([]int64, 0, len(doc.ChildList)), doc.ChildList...)
+	}
+	return &copied
+}
+
+// Setting the fields of the document by their names. The values may be decoded from JSON,
+// so the numbers are converted. Unknown fields and values of other types are logged and ignored,
+// then false is returned and the document must not be cached
+func applyFields(doc *models.Document, fields map[string]interface{}) bool {
+	consistent := true
+	for field, value := range fields {
+		applied := true
+		switch field {
+		case "Id":
+			var number int64
+			if number, applied = toInt64(value); applied {
+				doc.Id = number
+			}
+		case "ParentId":
+			var number int64
+			if number, applied = toInt64(value); applied {
+				doc.ParentId = number
+			}
+		case "Depth":
+			var number int64
+			if number, applied = toInt64(value); applied {
+				doc.Depth = int(number)
+			}
+		case "Sort":
+			var number int64
+			if number, applied = toInt64(value); applied {
+				doc.Sort = int(number)
+			}
+		case "Body":
+			var body string
+			if body, applied = value.(string); applied {
+				doc.Body = body
+			}
+		case "ChildList":
+			switch list := value.(type) {
+			case []int64:
+				doc.ChildList = append([]int64{}, list...)
+			case []interface{}:
+				doc.ChildList = util.ArrToInt64(list)
+			case nil:
+				doc.ChildList = nil
+			default:
+				applied = false
+			}
+		default:
+			applied = false
+		}
+		if !applied {
+			log.Printf("Can not update field %s of cached document %d with value of type %T", field, doc.Id, value)
+			consistent = false
+		}
+	}
+	return consistent
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch number := value.(type) {
+	case int:
+		return int64(number), true
+	case int64:
+		return number, true
+	case int32:
+		return int64(number), true
+	case float64:
+		return int64(number), true
+	}
+	return 0, false
+}
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/typedcache"
+)
+
+// Rule of choosing the document to evict when the cache is full
+type Policy = typedcache.Policy
+
+const (
+	LRU = typedcache.LRU
+	LFU = typedcache.LFU
+	TTL = typedcache.TTL
+)
+
+// Checking the name of the policy, the empty name means LRU
+func ParsePolicy(name string) (Policy, error) {
+	return typedcache.ParsePolicy(name)
+}
+
+// Approximate memory used by the document in the cache
+func docSize(doc *models.Document) int64 {
+	// The document, the entry and the record of the map
+	const overhead = 160
+	return overhead + int64(len(doc.Body)) + 8*int64(len(doc.ChildList))
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+// Hits, Misses: reads of the documents found and not found in the cache
+//
+// Evictions, Expirations: documents removed because of the limits and because of the lifetime
+//
+// AverageAge: average time in seconds since the documents were put to the cache
+//
+// NegativeHits, NegativeEntries: reads answered by the remembered missing ids and the number of such ids
+//
+// Coalesced: reads that waited for the load of the same document by another read
+//
+// Trees, TreeNodes, TreeHits, TreeMisses: cached big documents, their nodes and reads
+type Stats struct {
+	Hits            uint64  `json:"Hits" yaml:"Hits"`
+	Misses          uint64  `json:"Misses" yaml:"Misses"`
+	HitRatio        float64 `json:"HitRatio" yaml:"HitRatio"`
+	Evictions       uint64  `json:"Evictions" yaml:"Evictions"`
+	Expirations     uint64  `json:"Expirations" yaml:"Expirations"`
+	Entries         int     `json:"Entries" yaml:"Entries"`
+	Bytes           int64   `json:"Bytes" yaml:"Bytes"`
+	AverageAge      float64 `json:"AverageAge" yaml:"AverageAge"`
+	MaxEntries      int     `json:"MaxEntries" yaml:"MaxEntries"`
+	MaxBytes        int64   `json:"MaxBytes" yaml:"MaxBytes"`
+	Policy          Policy  `json:"Policy" yaml:"Policy"`
+	NegativeHits    uint64  `json:"NegativeHits" yaml:"NegativeHits"`
+	NegativeEntries int     `json:"NegativeEntries" yaml:"NegativeEntries"`
+	Coalesced       uint64  `json:"Coalesced" yaml:"Coalesced"`
+	Trees           int     `json:"Trees" yaml:"Trees"`
+	TreeNodes       int     `json:"TreeNodes" yaml:"TreeNodes"`
+	TreeHits        uint64  `json:"TreeHits" yaml:"TreeHits"`
+	TreeMisses      uint64  `json:"TreeMisses" yaml:"TreeMisses"`
+}
+
+// State of the cached document, the times are in unix nanoseconds
+type Entry struct {
+	Document   *models.Document `json:"Document" yaml:"Document"`
+	Size       int64            `json:"Size" yaml:"Size"`
+	Hits       int64            `json:"Hits" yaml:"Hits"`
+	CreatedAt  int64            `json:"CreatedAt" yaml:"CreatedAt"`
+	LastAccess int64            `json:"LastAccess" yaml:"LastAccess"`
+	ExpiresAt  int64            `json:"ExpiresAt" yaml:"ExpiresAt"`
+}
+
+func (cache *Cache) Stats() Stats {
+	docs := cache.docs.Stats()
+	trees := cache.trees.cached.Stats()
+	stats := Stats{
+		Hits:            docs.Hits,
+		Misses:          docs.Misses,
+		HitRatio:        docs.HitRatio,
+		Evictions:       docs.Evictions + trees.Evictions,
+		Expirations:     docs.Expirations + trees.Expirations,
+		Entries:         docs.Entries,
+		Bytes:           docs.Bytes,
+		AverageAge:      docs.AverageAge,
+		MaxEntries:      docs.MaxEntries,
+		MaxBytes:        docs.MaxBytes,
+		Policy:          docs.Policy,
+		NegativeHits:    docs.NegativeHits,
+		NegativeEntries: docs.NegativeEntries,
+		Coalesced:       docs.Coalesced,
+		Trees:           trees.Entries,
+		TreeHits:        trees.Hits,
+		TreeMisses:      trees.Misses,
+	}
+	cache.trees.Lock()
+	stats.TreeNodes = len(cache.trees.roots)
+	cache.trees.Unlock()
+	return stats
+}
+
+// Inspecting the cached document, the read is not counted and does not prolong its lifetime
+func (cache *Cache) Entry(id int64) (*Entry, bool) {
+	entry, exist := cache.docs.Entry(id)
+	if !exist {
+		return nil, false
+	}
+	return &Entry{
+		Document:   entry.Value,
+		Size:       entry.Size,
+		Hits:       entry.Hits,
+		CreatedAt:  entry.CreatedAt,
+		LastAccess: entry.LastAccess,
+		ExpiresAt:  entry.ExpiresAt,
+	}, true
+}
+
+// Removing all documents and trees, the counters are kept
+func (cache *Cache) Flush() {
+	cache.trees.flush()
+	cache.docs.Flush()
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/EwvwGeN/assignment/internal/typedcache"
+)
+
+// Assembled big documents by the id of the root. Every node is indexed with its root, so a change of any
+// node drops the whole tree. The trees are limited by their number and size with the limits
+// of the documents, the least recently used tree is evicted first
+type treeCache struct {
+	sync.Mutex
+	cached *typedcache.Cache[int64, *models.BigDocument]
+	// Roots of the nodes of the cached trees, the nodes of the evicted trees are removed by the cleaning
+	roots map[int64]int64
+	// Trees being assembled by their generations, a tree is not saved if any of its nodes changed meanwhile
+	builds     map[uint64]*treeBuild
+	generation uint64
+}
+
+type treeBuild struct {
+	root    int64
+	changed map[int64]struct{}
+	// Set by the flush, the tree is not saved whatever nodes it has
+	stale bool
+}
+
+func newTreeCache(options Options) *treeCache {
+	return &treeCache{
+		cached: typedcache.New(typedcache.Options[int64, *models.BigDocument]{
+			LifeTime:         options.LifeTime,
+			CleaningInterval: options.CleaningInterval,
+			MaxEntries:       options.MaxEntries,
+			MaxBytes:         options.MaxBytes,
+			Policy:           LRU,
+			Size: func(root int64, tree *models.BigDocument) int64 {
+				return treeSize(tree)
+			},
+		}),
+		roots:  make(map[int64]int64),
+		builds: make(map[uint64]*treeBuild),
+	}
+}
+
+// Starting the assembly of the tree of the root. Must be called before the documents of the tree are read,
+// the generation must be passed to AddTree
+func (cache *Cache) TreeGeneration(root int64) uint64 {
+	trees := cache.trees
+	trees.Lock()
+	defer trees.Unlock()
+	trees.generation++
+	trees.builds[trees.generation] = &treeBuild{
+		root:    root,
+		changed: make(map[int64]struct{}),
+	}
+	return trees.generation
+}
+
+// Returns the cached tree of the root. The tree is shared and must not be changed
+func (cache *Cache) GetTree(root int64) *models.BigDocument {
+	tree, _ := cache.trees.cached.Get(root)
+	return tree
+}
+
+// Saving the tree if none of its documents was changed since the generation was taken
+func (cache *Cache) AddTree(tree *models.BigDocument, generation uint64) {
+	trees := cache.trees
+	trees.Lock()
+	defer trees.Unlock()
+	build, exist := trees.builds[generation]
+	delete(trees.builds, generation)
+	if !exist || build.stale || build.root != tree.Id {
+		return
+	}
+	ids := treeIds(tree, nil)
+	if (cache.maxEntries > 0 && len(ids) > cache.maxEntries) || (cache.maxBytes > 0 && treeSize(tree) > cache.maxBytes) {
+		return
+	}
+	for _, id := range ids {
+		if _, changed := build.changed[id]; changed {
+			return
+		}
+	}
+	for _, id := range ids {
+		trees.roots[id] = tree.Id
+	}
+	trees.cached.Set(tree.Id, tree)
+}
+
+// Dropping the tree containing the document. Must be called on every change of the document
+func (cache *Cache) invalidateTree(id int64) {
+	trees := cache.trees
+	trees.Lock()
+	defer trees.Unlock()
+	for _, build := range trees.builds {
+		build.changed[id] = struct{}{}
+	}
+	if root, exist := trees.roots[id]; exist {
+		if entry, cached := trees.cached.Entry(root); cached {
+			for _, node := range treeIds(entry.Value, nil) {
+				if trees.roots[node] == root {
+					delete(trees.roots, node)
+				}
+			}
+		}
+		delete(trees.roots, id)
+		trees.cached.Delete(root)
+	}
+}
+
+// Removing the nodes of the trees that were evicted or expired
+func (trees *treeCache) clearRoots() {
+	trees.Lock()
+	defer trees.Unlock()
+	for id, root := range trees.roots {
+		if _, cached := trees.cached.Entry(root); !cached {
+			delete(trees.roots, id)
+		}
+	}
+}
+
+func (trees *treeCache) flush() {
+	trees.Lock()
+	defer trees.Unlock()
+	for _, build := range trees.builds {
+		build.stale = true
+	}
+	trees.roots = make(map[int64]int64)
+	trees.cached.Flush()
+}
+
+func treeIds(node *models.BigDocument, ids []int64) []int64 {
+	ids = append(ids, node.Id)
+	for i := range node.ChildList {
+		ids = treeIds(&node.ChildList[i], ids)
+	}
+	return ids
+}
+
+// Approximate memory used by the tree
+func treeSize(node *models.BigDocument) int64 {
+	const overhead = 96
+	size := overhead + int64(len(node.Body))
+	for i := range node.ChildList {
+		size += treeSize(&node.ChildList[i])
+	}
+	return size
+}
+
+// Roots of the cached trees from the most recently used, not more than the limit
+func (cache *Cache) RecentTrees(limit int) []int64 {
+	return cache.trees.cached.Recent(limit)
+}
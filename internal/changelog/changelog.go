@@ -0,0 +1,125 @@
+package changelog
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Log keeps every committed change in its own namespace under a monotonically increasing number
+type Log struct {
+	sync.Mutex
+	db        *reindexer.Reindexer
+	namespace string
+	seq       uint64
+}
+
+func NewLog(db *reindexer.Reindexer, prefix string) *Log {
+	return &Log{
+		db:        db,
+		namespace: prefix + "_changes",
+	}
+}
+
+// Opening the namespace and restoring the last number
+func (log *Log) Open() error {
+	if err := log.db.OpenNamespace(log.namespace, reindexer.DefaultNamespaceOptions(), models.Change{}); err != nil {
+		return err
+	}
+	item, found := log.db.Query(log.namespace).Sort("seq", true).Limit(1).Get()
+	log.Lock()
+	defer log.Unlock()
+	if found {
+		log.seq = uint64(item.(*models.Change).Seq)
+	}
+	return nil
+}
+
+// Number of the last change
+func (log *Log) Head() uint64 {
+	log.Lock()
+	defer log.Unlock()
+	return log.seq
+}
+
+// Assigning the next numbers to the events and writing them in one transaction. If the writing fails,
+// the numbers are taken back, so the numbering has no gaps. The records are written by their numbers,
+// so writing the events again after a failed commit does not repeat them
+func (log *Log) Append(list []*events.Event) error {
+	if len(list) == 0 {
+		return nil
+	}
+	log.Lock()
+	defer log.Unlock()
+	last := log.seq
+	now := time.Now().UTC()
+	for _, event := range list {
+		log.seq++
+		event.Seq = log.seq
+		if event.Time.IsZero() {
+			event.Time = now
+		}
+	}
+	if err := log.write(list); err != nil {
+		log.seq = last
+		for _, event := range list {
+			event.Seq = 0
+		}
+		return err
+	}
+	return nil
+}
+
+func (log *Log) write(list []*events.Event) error {
+	tx, err := log.db.BeginTx(log.namespace)
+	if err != nil {
+		return err
+	}
+	for _, event := range list {
+		fields, _ := json.Marshal(event.Fields)
+		err := tx.Upsert(&models.Change{
+			Seq:       int64(event.Seq),
+			Type:      string(event.Type),
+			DocId:     event.DocId,
+			Ancestors: event.Ancestors,
+			Fields:    string(fields),
+			Time:      event.Time.UnixNano(),
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Returning up to limit changes with a number greater than since in the order of their numbers
+func (log *Log) Since(since uint64, limit int) ([]*events.Event, error) {
+	iterator := log.db.Query(log.namespace).
+		WhereInt64("seq", reindexer.GT, int64(since)).
+		Sort("seq", false).
+		Limit(limit).
+		Exec()
+	defer iterator.Close()
+	result := []*events.Event{}
+	for iterator.Next() {
+		change := iterator.Object().(*models.Change)
+		event := &events.Event{
+			Seq:       uint64(change.Seq),
+			Type:      events.Type(change.Type),
+			DocId:     change.DocId,
+			Ancestors: change.Ancestors,
+			Time:      time.Unix(0, change.Time).UTC(),
+		}
+		if event.Ancestors == nil {
+			event.Ancestors = []int64{}
+		}
+		json.Unmarshal([]byte(change.Fields), &event.Fields)
+		result = append(result, event)
+	}
+	return result, iterator.Error()
+}
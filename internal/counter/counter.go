@@ -0,0 +1,110 @@
+package counter
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Counters of a collection. A counter is moved by an atomic update of its record, so the values are
+// reserved before the items are written and may be used inside a transaction. The replicas of the server
+// share the counters and never get the same values
+type Store struct {
+	db        *reindexer.Reindexer
+	namespace string
+}
+
+func NewStore(db *reindexer.Reindexer, prefix string) *Store {
+	return &Store{
+		db:        db,
+		namespace: prefix + "_counters",
+	}
+}
+
+func (store *Store) Open() error {
+	return store.db.OpenNamespace(store.namespace, reindexer.DefaultNamespaceOptions(), models.Counter{})
+}
+
+// The last value given by the counter, zero for a new counter
+func (store *Store) Value(name string) (int64, error) {
+	item, found := store.db.Query(store.namespace).WhereString("name", reindexer.EQ, name).Get()
+	if !found {
+		return 0, nil
+	}
+	return item.(*models.Counter).Value, nil
+}
+
+// Reserving the next count values of the counter. Returns the first of them, the others follow it
+func (store *Store) Reserve(name string, count int) (int64, error) {
+	if count < 1 {
+		return 0, fmt.Errorf("Can not reserve %d values of the counter %s", count, name)
+	}
+	if err := store.create(name); err != nil {
+		return 0, err
+	}
+	iterator := store.db.Query(store.namespace).
+		WhereString("name", reindexer.EQ, name).
+		SetExpression("value", "value + "+strconv.Itoa(count)).
+		Update()
+	defer iterator.Close()
+	if !iterator.Next() {
+		if err := iterator.Error(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("Counter %s is not updated", name)
+	}
+	return iterator.Object().(*models.Counter).Value - int64(count) + 1, iterator.Error()
+}
+
+// Moving the counter forward to the value if the counter is lower
+func (store *Store) Raise(name string, value int64) error {
+	if err := store.create(name); err != nil {
+		return err
+	}
+	iterator := store.db.Query(store.namespace).
+		WhereString("name", reindexer.EQ, name).
+		WhereInt64("value", reindexer.LT, value).
+		Set("value", value).
+		Update()
+	defer iterator.Close()
+	return iterator.Error()
+}
+
+// Moving the counter past the values of the field of the namespace and past the serial of reindexer
+// for the field, so the counter continues the values given by "field=serial()" before it was used
+func (store *Store) Follow(name, namespace, field string) error {
+	query := store.db.Query(namespace).Limit(0)
+	query.AggregateMax(field)
+	iterator := query.Exec()
+	var last int64
+	if results := iterator.AggResults(); len(results) != 0 && results[0].Value != nil {
+		last = int64(*results[0].Value)
+	}
+	err := iterator.Error()
+	iterator.Close()
+	if err != nil {
+		return err
+	}
+	data, err := store.db.GetMeta(namespace, "_SERIAL_"+field)
+	if err != nil {
+		return err
+	}
+	if len(data) != 0 {
+		serial, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return err
+		}
+		if serial > last {
+			last = serial
+		}
+	}
+	return store.Raise(name, last)
+}
+
+// Inserting the counter with zero value if it does not exist
+func (store *Store) create(name string) error {
+	_, err := store.db.Insert(store.namespace, &models.Counter{Name: name})
+	return err
+}
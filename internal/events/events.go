@@ -0,0 +1,192 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/util"
+)
+
+type Type string
+
+const (
+	CREATE Type = "create"
+	UPDATE Type = "update"
+	DELETE Type = "delete"
+)
+
+// Committed change of a document
+//
+// Seq: number of the event, assigned by the broker in the order of publishing
+//
+// Ancestors: ids of the parents of the document from the nearest one up to the root,
+// for a deleted document these are the parents it had at the moment of deletion
+//
+// Fields: changed fields for an update, all fields for a creation
+type Event struct {
+	Seq       uint64      `json:"Seq"`
+	Type      Type        `json:"Type"`
+	DocId     int64       `json:"DocId"`
+	Ancestors []int64     `json:"Ancestors"`
+	Fields    util.XMLMap `json:"Fields,omitempty"`
+	Time      time.Time   `json:"Time"`
+}
+
+// Checks whether the event belongs to the subtree of the document
+func (event *Event) InSubtree(root int64) bool {
+	if root == 0 || event.DocId == root {
+		return true
+	}
+	for _, id := range event.Ancestors {
+		if id == root {
+			return true
+		}
+	}
+	return false
+}
+
+type Filter func(event *Event) bool
+
+func SubtreeFilter(root int64) Filter {
+	return func(event *Event) bool {
+		return event.InSubtree(root)
+	}
+}
+
+type Subscription struct {
+	Events chan *Event
+	filter Filter
+	broker *Broker
+	once   sync.Once
+}
+
+// Stops the delivery, the channel of events is closed
+func (sub *Subscription) Close() {
+	sub.broker.unsubscribe(sub)
+}
+
+// Broker delivers the published events to all subscribers. A subscriber that does not keep up
+// with the events is dropped and its channel is closed, so that publishing never blocks.
+// The last events are kept in the history to let the subscribers resume after reconnect
+type Broker struct {
+	sync.RWMutex
+	seq         uint64
+	subscribers map[*Subscription]struct{}
+	history     []*Event
+	historySize int
+}
+
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		subscribers: make(map[*Subscription]struct{}),
+		history:     make([]*Event, 0, historySize),
+		historySize: historySize,
+	}
+}
+
+// Subscribing to the events passing the filter, a nil filter accepts every event
+func (broker *Broker) Subscribe(filter Filter, size int) *Subscription {
+	sub := &Subscription{
+		Events: make(chan *Event, size),
+		filter: filter,
+		broker: broker,
+	}
+	broker.Lock()
+	broker.subscribers[sub] = struct{}{}
+	broker.Unlock()
+	return sub
+}
+
+func (broker *Broker) unsubscribe(sub *Subscription) {
+	broker.Lock()
+	defer broker.Unlock()
+	broker.drop(sub)
+}
+
+// Must be called under the lock
+func (broker *Broker) drop(sub *Subscription) {
+	delete(broker.subscribers, sub)
+	sub.once.Do(func() {
+		close(sub.Events)
+	})
+}
+
+// Setting the number of the last published event, for example restored from the change log
+func (broker *Broker) SetSeq(seq uint64) {
+	broker.Lock()
+	defer broker.Unlock()
+	broker.seq = seq
+}
+
+// Sending the events to the subscribers. Events without a number get the next one,
+// events that already have a number must be published in the order of their numbers
+func (broker *Broker) Publish(events []*Event) {
+	if len(events) == 0 {
+		return
+	}
+	broker.Lock()
+	defer broker.Unlock()
+	now := time.Now().UTC()
+	for _, event := range events {
+		if event.Seq == 0 {
+			broker.seq++
+			event.Seq = broker.seq
+		} else if event.Seq > broker.seq {
+			broker.seq = event.Seq
+		}
+		if event.Time.IsZero() {
+			event.Time = now
+		}
+		broker.remember(event)
+		for sub := range broker.subscribers {
+			if sub.filter != nil && !sub.filter(event) {
+				continue
+			}
+			select {
+			case sub.Events <- event:
+			default:
+				broker.drop(sub)
+			}
+		}
+	}
+}
+
+// Must be called under the lock
+func (broker *Broker) remember(event *Event) {
+	if broker.historySize <= 0 {
+		return
+	}
+	if len(broker.history) == broker.historySize {
+		copy(broker.history, broker.history[1:])
+		broker.history = broker.history[:len(broker.history)-1]
+	}
+	broker.history = append(broker.history, event)
+}
+
+// Returning the events from the history with a number greater than since and passing the filter.
+// The apply function is called under the lock, nothing is published between it and taking the events,
+// so it can be used to change the filters of the subscribers.
+//
+// head: number of the last published event
+//
+// complete: false if some events after since are already gone from the history
+func (broker *Broker) Replay(since uint64, filter Filter, apply func()) (result []*Event, head uint64, complete bool) {
+	broker.RLock()
+	defer broker.RUnlock()
+	if apply != nil {
+		apply()
+	}
+	complete = since >= broker.seq
+	for _, event := range broker.history {
+		if event.Seq <= since {
+			continue
+		}
+		if event.Seq == since+1 {
+			complete = true
+		}
+		if filter == nil || filter(event) {
+			result = append(result, event)
+		}
+	}
+	return result, broker.seq, complete
+}
@@ -0,0 +1,169 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Time the key stays reserved by a request that did not complete, after it the key may be used again.
+// The reservation is extended while the request is processed, so only a stopped server lets it expire
+const lockTimeout = time.Minute
+
+// Interval of extending the reservation of the processed request
+const holdInterval = lockTimeout / 3
+
+// Interval of removing the expired records
+const sweepInterval = time.Minute
+
+var (
+	KeyReused  = errors.New("Idempotency key was used with another request")
+	InProgress = errors.New("Request with this idempotency key is in progress")
+)
+
+// Hash of the parts joined with a zero byte
+func Hash(parts ...[]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type Store struct {
+	sync.Mutex
+	db        *reindexer.Reindexer
+	namespace string
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+func NewStore(db *reindexer.Reindexer, prefix string, ttl time.Duration) *Store {
+	return &Store{
+		db:        db,
+		namespace: prefix + "_idempotency",
+		ttl:       ttl,
+	}
+}
+
+func (store *Store) Open() error {
+	return store.db.OpenNamespace(store.namespace, reindexer.DefaultNamespaceOptions(), models.IdempotencyRecord{})
+}
+
+// Reserving the key for the request. Returns nil if the key is reserved and the request has to be processed,
+// or the saved response of the first request with the same fingerprint
+func (store *Store) Reserve(key, fingerprint string) (*models.IdempotencyRecord, error) {
+	now := time.Now()
+	store.sweep(now)
+	// The second attempt is made after removing an expired record
+	for attempt := 0; attempt < 2; attempt++ {
+		count, err := store.db.Insert(store.namespace, &models.IdempotencyRecord{
+			Key:         key,
+			Fingerprint: fingerprint,
+			ExpiresAt:   now.Add(lockTimeout).UnixNano(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if count != 0 {
+			return nil, nil
+		}
+		item, found := store.db.Query(store.namespace).WhereString("key", reindexer.EQ, key).Get()
+		if !found {
+			continue
+		}
+		record := item.(*models.IdempotencyRecord)
+		if record.ExpiresAt < now.UnixNano() {
+			store.db.Query(store.namespace).
+				WhereString("key", reindexer.EQ, key).
+				WhereInt64("expires_at", reindexer.EQ, record.ExpiresAt).
+				Delete()
+			continue
+		}
+		if record.Fingerprint != fingerprint {
+			return nil, KeyReused
+		}
+		if !record.Completed {
+			return nil, InProgress
+		}
+		return record, nil
+	}
+	return nil, InProgress
+}
+
+// Saving the response of the request that reserved the key
+func (store *Store) Complete(key, fingerprint string, status int, contentType string, body []byte) error {
+	return store.db.Upsert(store.namespace, &models.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		Completed:   true,
+		Status:      status,
+		ContentType: contentType,
+		Body:        string(body),
+		ExpiresAt:   time.Now().Add(store.ttl).UnixNano(),
+	})
+}
+
+// Extending the reservation of the key every holdInterval until the returned function is called
+func (store *Store) Hold(key string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(holdInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := store.extend(key); err != nil {
+					log.Printf("Can not extend the reservation of the idempotency key: %s", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// The reservation is changed only if it is still the one that was read, so the completed record
+// is never turned back into a reservation
+func (store *Store) extend(key string) error {
+	item, found := store.db.Query(store.namespace).WhereString("key", reindexer.EQ, key).Get()
+	if !found {
+		return nil
+	}
+	record := item.(*models.IdempotencyRecord)
+	if record.Completed {
+		return nil
+	}
+	iterator := store.db.Query(store.namespace).
+		WhereString("key", reindexer.EQ, key).
+		WhereInt64("expires_at", reindexer.EQ, record.ExpiresAt).
+		Set("expires_at", time.Now().Add(lockTimeout).UnixNano()).
+		Update()
+	defer iterator.Close()
+	return iterator.Error()
+}
+
+// Releasing the key, so the request can be repeated
+func (store *Store) Release(key string) error {
+	_, err := store.db.Query(store.namespace).WhereString("key", reindexer.EQ, key).Delete()
+	return err
+}
+
+func (store *Store) sweep(now time.Time) {
+	store.Lock()
+	if now.Sub(store.lastSweep) < sweepInterval {
+		store.Unlock()
+		return
+	}
+	store.lastSweep = now
+	store.Unlock()
+	store.db.Query(store.namespace).WhereInt64("expires_at", reindexer.LT, now.UnixNano()).Delete()
+}
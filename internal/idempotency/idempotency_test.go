@@ -0,0 +1,16 @@
+package idempotency
+
+import "testing"
+
+func TestHashSeparatesParts(t *testing.T) {
+	if Hash([]byte("POST"), []byte("/docs")) != Hash([]byte("POST"), []byte("/docs")) {
+		t.Fatal("hash of the same parts differs")
+	}
+	// Moving bytes between the parts changes the hash, so the query can not be passed off as the path
+	if Hash([]byte("/docs"), []byte("pretty")) == Hash([]byte("/docspretty"), nil) {
+		t.Fatal("parts are joined without a separator")
+	}
+	if Hash([]byte("/docs"), nil) == Hash([]byte("/docs")) {
+		t.Fatal("empty part is not counted")
+	}
+}
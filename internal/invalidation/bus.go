@@ -0,0 +1,105 @@
+package invalidation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+)
+
+// Ids of the documents changed by a replica in a collection. Flush asks to drop the whole cache of the collection
+//
+// Origin: id of the bus of the replica, a replica ignores its own messages
+//
+// Rules: the access rules of the documents are changed, not the documents themselves
+type Message struct {
+	Origin     string  `json:"Origin"`
+	Collection string  `json:"Collection"`
+	Ids        []int64 `json:"Ids,omitempty"`
+	Flush      bool    `json:"Flush,omitempty"`
+	Rules      bool    `json:"Rules,omitempty"`
+}
+
+// Delivery of the messages between the replicas. Run delivers the messages of all replicas
+// until the context is done
+type Transport interface {
+	Publish(message Message) error
+	Run(ctx context.Context, deliver func(Message)) error
+}
+
+// Broadcasting the invalidations of the caches of the collections to the other replicas
+type Bus struct {
+	sync.RWMutex
+	origin    string
+	transport Transport
+	handlers  map[string]func(Message)
+}
+
+func NewBus(transport Transport) *Bus {
+	origin := make([]byte, 8)
+	rand.Read(origin)
+	return &Bus{
+		origin:    hex.EncodeToString(origin),
+		transport: transport,
+		handlers:  map[string]func(Message){},
+	}
+}
+
+// Sending the ids changed in the collection to the other replicas
+func (bus *Bus) Publish(collection string, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+	bus.publish(Message{Collection: collection, Ids: ids})
+}
+
+// Asking the other replicas to read the access rules of the documents again
+func (bus *Bus) PublishRules(collection string, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+	bus.publish(Message{Collection: collection, Ids: ids, Rules: true})
+}
+
+// Asking the other replicas to drop the cache of the collection
+func (bus *Bus) PublishFlush(collection string) {
+	bus.publish(Message{Collection: collection, Flush: true})
+}
+
+func (bus *Bus) publish(message Message) {
+	message.Origin = bus.origin
+	if err := bus.transport.Publish(message); err != nil {
+		log.Printf("Can not publish invalidation message: %s", err)
+	}
+}
+
+// Handling the messages of the collection from the other replicas, replaces the previous handler
+func (bus *Bus) Subscribe(collection string, handler func(Message)) {
+	bus.Lock()
+	defer bus.Unlock()
+	bus.handlers[collection] = handler
+}
+
+func (bus *Bus) Unsubscribe(collection string) {
+	bus.Lock()
+	defer bus.Unlock()
+	delete(bus.handlers, collection)
+}
+
+// Receiving the messages until the context is done
+func (bus *Bus) Run(ctx context.Context) error {
+	return bus.transport.Run(ctx, bus.deliver)
+}
+
+func (bus *Bus) deliver(message Message) {
+	if message.Origin == bus.origin {
+		return
+	}
+	bus.RLock()
+	handler := bus.handlers[message.Collection]
+	bus.RUnlock()
+	if handler != nil {
+		handler(message)
+	}
+}
@@ -0,0 +1,135 @@
+package invalidation
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/cache"
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+// Replica connected to the bus the same way the server connects the cache of a collection
+func newReplica(ctx context.Context, transport Transport) *cache.Cache {
+	bus := NewBus(transport)
+	replica := cache.NewCache(cache.Options{LifeTime: time.Minute})
+	bus.Subscribe("documents", func(message Message) {
+		if message.Flush {
+			replica.Flush()
+			return
+		}
+		for _, id := range message.Ids {
+			replica.DelDoc(id)
+		}
+	})
+	replica.OnCommit(func(ids []int64) {
+		bus.Publish("documents", ids)
+	})
+	go bus.Run(ctx)
+	return replica
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUpdateEvictsDocumentOnOtherReplica(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := NewHub()
+	first := newReplica(ctx, hub.Transport())
+	second := newReplica(ctx, hub.Transport())
+	defer first.Close()
+	defer second.Close()
+	for _, replica := range []*cache.Cache{first, second} {
+		replica.AddDoc(&models.Document{Id: 1, Body: "before"})
+		replica.AddDoc(&models.Document{Id: 2, Body: "other"})
+	}
+
+	saver := first.NewActionSaver()
+	saver.Save(&cache.ActionProperties{DocId: 1, Action: cache.UPDATE, Field: "Body", NewValue: "after"})
+	saver.Commit()
+
+	if doc := first.GetDoc(1); doc == nil || doc.Body != "after" {
+		t.Fatalf("document is not updated on the replica that changed it: %+v", doc)
+	}
+	waitFor(t, func() bool { return second.GetDoc(1) == nil })
+	if second.GetDoc(2) == nil {
+		t.Fatal("document that was not changed is evicted")
+	}
+}
+
+func newTestUDP(t *testing.T, secret string) *UDP {
+	t.Helper()
+	transport, err := NewUDP("127.0.0.1:0", nil, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport
+}
+
+func TestUDPDropsForeignDatagrams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sender := newTestUDP(t, "secret")
+	forger := newTestUDP(t, "another secret")
+	receiver := newTestUDP(t, "secret")
+	stranger := newTestUDP(t, "secret")
+	for _, transport := range []*UDP{sender, forger} {
+		transport.peers = []*net.UDPAddr{receiver.conn.LocalAddr().(*net.UDPAddr)}
+	}
+	receiver.peers = []*net.UDPAddr{sender.conn.LocalAddr().(*net.UDPAddr)}
+	stranger.peers = []*net.UDPAddr{{IP: net.ParseIP("192.0.2.1"), Port: 7946}}
+
+	received := make(chan Message, 10)
+	go receiver.Run(ctx, func(message Message) { received <- message })
+	go stranger.Run(ctx, func(message Message) { received <- message })
+
+	// The forger is on the same host as the peer, only the signature tells them apart
+	forger.Publish(Message{Collection: "documents", Ids: []int64{1}})
+	// The stranger does not list the host of the sender as a peer
+	sender.peers = append(sender.peers, stranger.conn.LocalAddr().(*net.UDPAddr))
+	sender.Publish(Message{Collection: "documents", Ids: []int64{2}})
+
+	select {
+	case message := <-received:
+		if len(message.Ids) != 1 || message.Ids[0] != 2 {
+			t.Fatalf("unexpected message delivered: %+v", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("signed message from the peer is not delivered")
+	}
+	select {
+	case message := <-received:
+		t.Fatalf("unexpected message delivered: %+v", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRulesMessageIsNotInvalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := NewHub()
+	sender := NewBus(hub.Transport())
+	receiver := NewBus(hub.Transport())
+	received := make(chan Message, 1)
+	receiver.Subscribe("documents", func(message Message) { received <- message })
+	go receiver.Run(ctx)
+	sender.PublishRules("documents", []int64{36})
+	select {
+	case message := <-received:
+		if !message.Rules || message.Flush || len(message.Ids) != 1 || message.Ids[0] != 36 {
+			t.Fatalf("got message %+v", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("rules message is not delivered")
+	}
+}
@@ -0,0 +1,61 @@
+package invalidation
+
+import (
+	"context"
+	"sync"
+)
+
+// Connecting the buses of one process, for the tests and for several servers in one process
+type Hub struct {
+	sync.Mutex
+	members []*localTransport
+}
+
+type localTransport struct {
+	hub      *Hub
+	messages chan Message
+}
+
+func NewHub() *Hub {
+	return &Hub{}
+}
+
+// Transport receiving the messages of all transports of the hub
+func (hub *Hub) Transport() Transport {
+	hub.Lock()
+	defer hub.Unlock()
+	transport := &localTransport{
+		hub:      hub,
+		messages: make(chan Message, 1024),
+	}
+	hub.members = append(hub.members, transport)
+	return transport
+}
+
+func (transport *localTransport) Publish(message Message) error {
+	transport.hub.Lock()
+	members := append([]*localTransport(nil), transport.hub.members...)
+	transport.hub.Unlock()
+	for _, member := range members {
+		if member == transport {
+			continue
+		}
+		// A member that does not keep up loses the message like with the other transports
+		select {
+		case member.messages <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+func (transport *localTransport) Run(ctx context.Context, deliver func(Message)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case message := <-transport.messages:
+			deliver(message)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package invalidation
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Time the messages are kept in the namespace, a replica stopped for longer misses them
+const retention = 10 * time.Minute
+
+// Number of the messages read by one poll
+const pollLimit = 1000
+
+// Transport writing the messages to a reindexer namespace shared by the replicas, every replica
+// polls it for the messages written after its start
+type Reindexer struct {
+	db           *reindexer.Reindexer
+	namespace    string
+	pollInterval time.Duration
+}
+
+func NewReindexer(db *reindexer.Reindexer, prefix string, pollInterval time.Duration) (*Reindexer, error) {
+	transport := &Reindexer{
+		db:           db,
+		namespace:    prefix + "_invalidations",
+		pollInterval: pollInterval,
+	}
+	if err := db.OpenNamespace(transport.namespace, reindexer.DefaultNamespaceOptions(), models.InvalidationRecord{}); err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+func (transport *Reindexer) Publish(message Message) error {
+	_, err := transport.db.Insert(transport.namespace, &models.InvalidationRecord{
+		Origin:     message.Origin,
+		Collection: message.Collection,
+		Ids:        message.Ids,
+		Flush:      message.Flush,
+		Rules:      message.Rules,
+		CreatedAt:  time.Now().UnixNano(),
+	}, "id=serial()")
+	return err
+}
+
+func (transport *Reindexer) Run(ctx context.Context, deliver func(Message)) error {
+	last := transport.lastId()
+	ticker := time.NewTicker(transport.pollInterval)
+	defer ticker.Stop()
+	lastCleaning := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		last = transport.poll(last, deliver)
+		if time.Since(lastCleaning) > retention/10 {
+			lastCleaning = time.Now()
+			transport.db.Query(transport.namespace).
+				WhereInt64("created_at", reindexer.LT, time.Now().Add(-retention).UnixNano()).
+				Delete()
+		}
+	}
+}
+
+// Delivering the messages written after the last one, returns the id of the last delivered message
+func (transport *Reindexer) poll(last int64, deliver func(Message)) int64 {
+	iterator := transport.db.Query(transport.namespace).
+		WhereInt64("id", reindexer.GT, last).
+		Sort("id", false).
+		Limit(pollLimit).
+		Exec()
+	defer iterator.Close()
+	for iterator.Next() {
+		record := iterator.Object().(*models.InvalidationRecord)
+		deliver(Message{
+			Origin:     record.Origin,
+			Collection: record.Collection,
+			Ids:        record.Ids,
+			Flush:      record.Flush,
+			Rules:      record.Rules,
+		})
+		last = record.Id
+	}
+	if err := iterator.Error(); err != nil {
+		log.Printf("Can not read invalidation messages: %s", err)
+	}
+	return last
+}
+
+func (transport *Reindexer) lastId() int64 {
+	item, found := transport.db.Query(transport.namespace).Sort("id", true).Limit(1).Get()
+	if !found {
+		return 0
+	}
+	return item.(*models.InvalidationRecord).Id
+}
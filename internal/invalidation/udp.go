@@ -0,0 +1,136 @@
+package invalidation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"net"
+)
+
+// Number of the ids sent in one datagram, keeps the datagrams far below the size limit
+const idsPerDatagram = 512
+
+// Buffer of the received datagram
+const datagramSize = 64 * 1024
+
+// Transport sending every message as a JSON datagram to each peer. The delivery is not guaranteed,
+// a lost message leaves the stale documents until their lifetime expires. The datagrams from the hosts
+// that are not peers are dropped. With the secret every datagram starts with the HMAC-SHA256 of the message,
+// the datagrams with a wrong signature are dropped
+type UDP struct {
+	conn   *net.UDPConn
+	peers  []*net.UDPAddr
+	secret []byte
+}
+
+// Listening on the address and sending to the peers, the peers are "host:port". An empty secret
+// disables the signatures
+func NewUDP(listen string, peers []string, secret string) (*UDP, error) {
+	address, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	transport := &UDP{}
+	if secret != "" {
+		transport.secret = []byte(secret)
+	}
+	for _, peer := range peers {
+		peerAddress, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return nil, err
+		}
+		transport.peers = append(transport.peers, peerAddress)
+	}
+	transport.conn, err = net.ListenUDP("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+func (transport *UDP) Publish(message Message) error {
+	for {
+		part := message
+		if len(part.Ids) > idsPerDatagram {
+			part.Ids = part.Ids[:idsPerDatagram]
+		}
+		data, err := json.Marshal(part)
+		if err != nil {
+			return err
+		}
+		data = append(transport.sign(data), data...)
+		for _, peer := range transport.peers {
+			if _, err := transport.conn.WriteToUDP(data, peer); err != nil {
+				log.Printf("Can not send invalidation message to %s: %s", peer, err)
+			}
+		}
+		if len(message.Ids) <= idsPerDatagram {
+			return nil
+		}
+		message.Ids = message.Ids[idsPerDatagram:]
+	}
+}
+
+func (transport *UDP) Run(ctx context.Context, deliver func(Message)) error {
+	go func() {
+		<-ctx.Done()
+		transport.conn.Close()
+	}()
+	buffer := make([]byte, datagramSize)
+	for {
+		size, source, err := transport.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if !transport.isPeer(source) {
+			continue
+		}
+		data, valid := transport.verify(buffer[:size])
+		if !valid {
+			log.Printf("Invalidation message from %s has a wrong signature", source)
+			continue
+		}
+		var message Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+		deliver(message)
+	}
+}
+
+// The peers send from the address they listen on, but the port may be changed on the way,
+// so only the host is compared
+func (transport *UDP) isPeer(source *net.UDPAddr) bool {
+	for _, peer := range transport.peers {
+		if peer.IP.Equal(source.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (transport *UDP) sign(data []byte) []byte {
+	if transport.secret == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, transport.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Returns the message without the signature
+func (transport *UDP) verify(datagram []byte) ([]byte, bool) {
+	if transport.secret == nil {
+		return datagram, true
+	}
+	if len(datagram) < sha256.Size {
+		return nil, false
+	}
+	signature, data := datagram[:sha256.Size], datagram[sha256.Size:]
+	return data, hmac.Equal(signature, transport.sign(data))
+}
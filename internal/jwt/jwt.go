@@ -0,0 +1,253 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Supported signature algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	MalformedToken       = errors.New("Malformed token")
+	UnsupportedAlgorithm = errors.New("Unsupported signing algorithm")
+	UnknownKey           = errors.New("No key to verify the token")
+	InvalidSignature     = errors.New("Invalid token signature")
+	TokenExpired         = errors.New("Token is expired")
+	TokenNotYetValid     = errors.New("Token is not valid yet")
+	InvalidIssuer        = errors.New("Invalid token issuer")
+	InvalidAudience      = errors.New("Invalid token audience")
+	MissingSubject       = errors.New("Token has no subject")
+	MissingExpiration    = errors.New("Token has no expiration time")
+)
+
+type Claims map[string]interface{}
+
+// Identity of the client taken from the claims
+type Identity struct {
+	Subject string
+	Roles   []string
+	Claims  Claims
+}
+
+type Options struct {
+	// Secret for HS256
+	HmacSecret string
+	// Public keys for RS256 and ES256, tokens with a "kid" header are checked only with the key of this id
+	Keys map[string]crypto.PublicKey
+	// Public keys without ids, such as the keys of a PEM file. Tokens with any "kid" header are checked
+	// with them when there is no key of this id
+	UnnamedKeys []crypto.PublicKey
+	// Expected "iss" claim, not checked if empty
+	Issuer string
+	// Expected value in the "aud" claim, not checked if empty
+	Audience string
+	// Claim with the list of roles, a space separated string is also accepted
+	RolesClaim string
+	// Allowed clock difference for "exp" and "nbf"
+	Leeway time.Duration
+	// Rejecting the tokens without the "exp" claim
+	RequireExpiration bool
+}
+
+type Verifier struct {
+	options Options
+	now     func() time.Time
+}
+
+func NewVerifier(options Options) *Verifier {
+	if options.RolesClaim == "" {
+		options.RolesClaim = "roles"
+	}
+	return &Verifier{
+		options: options,
+		now:     time.Now,
+	}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Checking the signature and the registered claims of the compact token
+func (verifier *Verifier) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, MalformedToken
+	}
+	var head header
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, MalformedToken
+	}
+	if err := verifier.verifySignature(head, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := verifier.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, MissingSubject
+	}
+	return &Identity{
+		Subject: subject,
+		Roles:   stringList(claims[verifier.options.RolesClaim]),
+		Claims:  claims,
+	}, nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return MalformedToken
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(out); err != nil {
+		return MalformedToken
+	}
+	return nil
+}
+
+// The algorithm is checked against the type of the key, so a public key is never used as an HMAC secret
+func (verifier *Verifier) verifySignature(head header, signed, signature []byte) error {
+	switch head.Alg {
+	case HS256:
+		if verifier.options.HmacSecret == "" {
+			return UnknownKey
+		}
+		mac := hmac.New(sha256.New, []byte(verifier.options.HmacSecret))
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return InvalidSignature
+		}
+		return nil
+	case RS256, ES256:
+		keys := verifier.keys(head.Kid)
+		if len(keys) == 0 {
+			return UnknownKey
+		}
+		digest := sha256.Sum256(signed)
+		for _, key := range keys {
+			if verifyWithKey(head.Alg, key, digest[:], signature) {
+				return nil
+			}
+		}
+		return InvalidSignature
+	}
+	return fmt.Errorf("%w: %s", UnsupportedAlgorithm, head.Alg)
+}
+
+func (verifier *Verifier) keys(kid string) []crypto.PublicKey {
+	if kid != "" {
+		if key, exist := verifier.options.Keys[kid]; exist {
+			return []crypto.PublicKey{key}
+		}
+		return verifier.options.UnnamedKeys
+	}
+	result := make([]crypto.PublicKey, 0, len(verifier.options.Keys)+len(verifier.options.UnnamedKeys))
+	for _, key := range verifier.options.Keys {
+		result = append(result, key)
+	}
+	return append(result, verifier.options.UnnamedKeys...)
+}
+
+func verifyWithKey(alg string, key crypto.PublicKey, digest, signature []byte) bool {
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		return alg == RS256 && rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		if alg != ES256 || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(publicKey, digest, r, s)
+	}
+	return false
+}
+
+func (verifier *Verifier) checkClaims(claims Claims) error {
+	now := verifier.now()
+	leeway := verifier.options.Leeway
+	exp, exist := numericDate(claims["exp"])
+	if !exist && verifier.options.RequireExpiration {
+		return MissingExpiration
+	}
+	if exist && now.After(exp.Add(leeway)) {
+		return TokenExpired
+	}
+	if nbf, exist := numericDate(claims["nbf"]); exist && now.Add(leeway).Before(nbf) {
+		return TokenNotYetValid
+	}
+	if verifier.options.Issuer != "" {
+		if issuer, _ := claims["iss"].(string); issuer != verifier.options.Issuer {
+			return InvalidIssuer
+		}
+	}
+	if verifier.options.Audience != "" {
+		found := false
+		for _, audience := range stringList(claims["aud"]) {
+			if audience == verifier.options.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return InvalidAudience
+		}
+	}
+	return nil
+}
+
+func numericDate(value interface{}) (time.Time, bool) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// A claim may be a list of strings or one string with values separated by spaces
+func stringList(value interface{}) []string {
+	switch list := value.(type) {
+	case string:
+		return strings.Fields(list)
+	case []interface{}:
+		result := make([]string, 0, len(list))
+		for _, item := range list {
+			if text, ok := item.(string); ok {
+				result = append(result, text)
+			}
+		}
+		return result
+	}
+	return nil
+}
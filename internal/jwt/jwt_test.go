@@ -0,0 +1,192 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const secret = "hmac secret"
+
+func segment(t *testing.T, value interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Compact token signed with the key: a string is an HMAC secret, otherwise a private key
+func token(t *testing.T, head map[string]string, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	signed := segment(t, head) + "." + segment(t, claims)
+	var signature []byte
+	switch signingKey := key.(type) {
+	case string:
+		mac := hmac.New(sha256.New, []byte(signingKey))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, signingKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, signingKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func claims(extra map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range extra {
+		if value == nil {
+			delete(result, name)
+			continue
+		}
+		result[name] = value
+	}
+	return result
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})
+
+	withHmac := NewVerifier(Options{HmacSecret: secret, RequireExpiration: true})
+	withJwks := NewVerifier(Options{
+		Keys: map[string]crypto.PublicKey{
+			"rsa": &rsaKey.PublicKey,
+			"ec":  &ecKey.PublicKey,
+		},
+		RequireExpiration: true,
+	})
+	withPem := NewVerifier(Options{UnnamedKeys: []crypto.PublicKey{&rsaKey.PublicKey}, RequireExpiration: true})
+	optionalExp := NewVerifier(Options{HmacSecret: secret})
+
+	tests := []struct {
+		name     string
+		verifier *Verifier
+		token    string
+		err      error
+	}{
+		{"hs256", withHmac, token(t, map[string]string{"alg": HS256}, claims(nil), secret), nil},
+		{"hs256 wrong secret", withHmac, token(t, map[string]string{"alg": HS256}, claims(nil), "other secret"), InvalidSignature},
+		{"rs256 by kid", withJwks, token(t, map[string]string{"alg": RS256, "kid": "rsa"}, claims(nil), rsaKey), nil},
+		{"rs256 without kid", withJwks, token(t, map[string]string{"alg": RS256}, claims(nil), rsaKey), nil},
+		{"rs256 other key", withJwks, token(t, map[string]string{"alg": RS256}, claims(nil), otherRsaKey), InvalidSignature},
+		{"es256 by kid", withJwks, token(t, map[string]string{"alg": ES256, "kid": "ec"}, claims(nil), ecKey), nil},
+		{"kid of another key", withJwks, token(t, map[string]string{"alg": RS256, "kid": "ec"}, claims(nil), rsaKey), InvalidSignature},
+		{"unknown kid", withJwks, token(t, map[string]string{"alg": RS256, "kid": "missing"}, claims(nil), rsaKey), UnknownKey},
+		{"unknown kid with pem keys", withPem, token(t, map[string]string{"alg": RS256, "kid": "issuer-key-1"}, claims(nil), rsaKey), nil},
+		{"pem key without kid", withPem, token(t, map[string]string{"alg": RS256}, claims(nil), rsaKey), nil},
+		{"hs256 with public key as secret", withJwks, token(t, map[string]string{"alg": HS256}, claims(nil), string(publicPem)), UnknownKey},
+		{"hs256 with public key as secret and hmac", NewVerifier(Options{
+			HmacSecret: secret,
+			Keys:       map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey},
+		}), token(t, map[string]string{"alg": HS256, "kid": "rsa"}, claims(nil), string(publicPem)), InvalidSignature},
+		{"es256 header with rsa signature", withJwks, token(t, map[string]string{"alg": ES256, "kid": "rsa"}, claims(nil), rsaKey), InvalidSignature},
+		{"alg none", withHmac, segment(t, map[string]string{"alg": "none"}) + "." + segment(t, claims(nil)) + ".", UnsupportedAlgorithm},
+		{"expired", withHmac, token(t, map[string]string{"alg": HS256}, claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), secret), TokenExpired},
+		{"expired within leeway", NewVerifier(Options{HmacSecret: secret, Leeway: time.Minute}), token(t, map[string]string{"alg": HS256}, claims(map[string]interface{}{"exp": time.Now().Add(-time.Second).Unix()}), secret), nil},
+		{"not yet valid", withHmac, token(t, map[string]string{"alg": HS256}, claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()}), secret), TokenNotYetValid},
+		{"without exp", withHmac, token(t, map[string]string{"alg": HS256}, claims(map[string]interface{}{"exp": nil}), secret), MissingExpiration},
+		{"without exp not required", optionalExp, token(t, map[string]string{"alg": HS256}, claims(map[string]interface{}{"exp": nil}), secret), nil},
+		{"without sub", withHmac, token(t, map[string]string{"alg": HS256}, claims(map[string]interface{}{"sub": nil}), secret), MissingSubject},
+		{"malformed", withHmac, "not a token", MalformedToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := test.verifier.Verify(test.token)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && identity.Subject != "alice" {
+				t.Fatalf("got subject %q", identity.Subject)
+			}
+		})
+	}
+}
+
+func TestClaimsChecks(t *testing.T) {
+	verifier := NewVerifier(Options{HmacSecret: secret, Issuer: "issuer", Audience: "docs", RolesClaim: "groups"})
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{"valid", claims(map[string]interface{}{"iss": "issuer", "aud": []string{"other", "docs"}, "groups": "editors admin"}), nil},
+		{"wrong issuer", claims(map[string]interface{}{"iss": "other", "aud": "docs"}), InvalidIssuer},
+		{"wrong audience", claims(map[string]interface{}{"iss": "issuer", "aud": "other"}), InvalidAudience},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := verifier.Verify(token(t, map[string]string{"alg": HS256}, test.claims, secret))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && (len(identity.Roles) != 2 || identity.Roles[1] != "admin") {
+				t.Fatalf("got roles %v", identity.Roles)
+			}
+		})
+	}
+}
+
+func TestLoadPEM(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadPEM(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewVerifier(Options{UnnamedKeys: keys})
+	if _, err := verifier.Verify(token(t, map[string]string{"alg": ES256, "kid": "any"}, claims(nil), key)); err != nil {
+		t.Fatalf("token signed with the key from the file is rejected: %v", err)
+	}
+}
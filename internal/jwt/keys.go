@@ -0,0 +1,162 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+var (
+	NoKeysFound     = errors.New("No public keys found")
+	UnsupportedKey  = errors.New("Unsupported key")
+	InvalidJwksFile = errors.New("Invalid JWKS file")
+)
+
+// Reading the public keys from a PEM file. The keys have no ids, so they are passed as Options.UnnamedKeys
+func LoadPEM(path string) ([]crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := []crypto.PublicKey{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var certificate *x509.Certificate
+			certificate, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = certificate.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKey(key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, NoKeysFound
+	}
+	return keys, nil
+}
+
+func checkKey(key crypto.PublicKey) error {
+	switch publicKey := key.(type) {
+	case *rsa.PublicKey:
+		return nil
+	case *ecdsa.PublicKey:
+		if publicKey.Curve == elliptic.P256() {
+			return nil
+		}
+	}
+	return UnsupportedKey
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Reading the RSA and P-256 keys from a JWKS file, keys for encryption are skipped
+func LoadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %s", InvalidJwksFile, err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for i, item := range set.Keys {
+		if item.Use != "" && item.Use != "sig" {
+			continue
+		}
+		key, err := item.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %d: %s", InvalidJwksFile, i, err)
+		}
+		kid := item.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("jwks-%d", i)
+		}
+		keys[kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, NoKeysFound
+	}
+	return keys, nil
+}
+
+func (item *jwk) publicKey() (crypto.PublicKey, error) {
+	switch item.Kty {
+	case "RSA":
+		n, err := decodeBigInt(item.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(item.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if item.Crv != "P-256" {
+			return nil, UnsupportedKey
+		}
+		x, err := decodeBigInt(item.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(item.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, UnsupportedKey
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, UnsupportedKey
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
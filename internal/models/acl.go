@@ -0,0 +1,11 @@
+package models
+
+// Access rule of the document, inherited by all its descendants
+//
+// Principal: actor of the request such as "jwt:alice" or "apikey:team-a", or "group:<role>" for a role of the token
+type AccessRule struct {
+	Id          int64    `reindex:"id,,pk" json:"Id"`
+	DocId       int64    `reindex:"doc_id" json:"DocId"`
+	Principal   string   `reindex:"principal" json:"Principal"`
+	Permissions []string `reindex:"permissions" json:"Permissions"`
+}
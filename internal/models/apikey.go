@@ -0,0 +1,20 @@
+package models
+
+// Key for the API. Only the hash of the key is stored, the key itself is shown once on creation
+//
+// Prefix: beginning of the key to recognize it in the list
+//
+// Tenant: the only tenant the key works with, empty for the keys of the collection from the configuration
+//
+// CreatedAt, RotatedAt: unix time in nanoseconds
+type ApiKey struct {
+	Id        int64    `reindex:"id,,pk" json:"Id"`
+	Name      string   `reindex:"name" json:"Name"`
+	Hash      string   `reindex:"hash" json:"Hash,omitempty"`
+	Prefix    string   `reindex:"prefix,-" json:"Prefix"`
+	Scopes    []string `reindex:"scopes" json:"Scopes"`
+	Tenant    string   `reindex:"tenant" json:"Tenant,omitempty"`
+	Revoked   bool     `reindex:"revoked" json:"Revoked"`
+	CreatedAt int64    `reindex:"created_at" json:"CreatedAt"`
+	RotatedAt int64    `reindex:"rotated_at" json:"RotatedAt"`
+}
@@ -0,0 +1,24 @@
+package models
+
+// Record of the audit log
+//
+// Endpoint: route of the request, Path: actual path of the request
+//
+// DocIds: ids of all affected documents including the cascade of deletion
+//
+// Changes: json list of the changes of the documents with the values before and after
+//
+// Time: unix time in nanoseconds
+type AuditEntry struct {
+	Id        int64   `reindex:"id,,pk" json:"Id"`
+	Actor     string  `reindex:"actor" json:"Actor"`
+	ClientIp  string  `reindex:"client_ip" json:"ClientIp"`
+	RequestId string  `reindex:"request_id" json:"RequestId"`
+	Method    string  `reindex:"method" json:"Method"`
+	Endpoint  string  `reindex:"endpoint" json:"Endpoint"`
+	Path      string  `reindex:"path,-" json:"Path"`
+	Status    int     `reindex:"status" json:"Status"`
+	DocIds    []int64 `reindex:"doc_ids" json:"DocIds"`
+	Changes   string  `reindex:"changes,-" json:"Changes"`
+	Time      int64   `reindex:"time" json:"Time"`
+}
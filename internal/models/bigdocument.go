@@ -1,8 +1,8 @@
 package models
 
 type BigDocument struct {
-	Id        int64         `json:"Id"`
-	Sort      int           `json:"Sort"`
-	Body      string        `json:"Body"`
-	ChildList []BigDocument `json:"ChildList"`
+	Id        int64         `json:"Id" yaml:"Id"`
+	Sort      int           `json:"Sort" yaml:"Sort"`
+	Body      string        `json:"Body" yaml:"Body"`
+	ChildList []BigDocument `json:"ChildList" yaml:"ChildList"`
 }
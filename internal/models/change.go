@@ -0,0 +1,15 @@
+package models
+
+// Record of the change log
+//
+// Fields: changed fields encoded in json
+//
+// Time: unix time in nanoseconds of the commit
+type Change struct {
+	Seq       int64   `reindex:"seq,,pk" json:"Seq"`
+	Type      string  `reindex:"type" json:"Type"`
+	DocId     int64   `reindex:"doc_id" json:"DocId"`
+	Ancestors []int64 `reindex:"ancestors" json:"Ancestors"`
+	Fields    string  `reindex:"fields,-" json:"Fields"`
+	Time      int64   `reindex:"time" json:"Time"`
+}
@@ -0,0 +1,29 @@
+package models
+
+// Named collection of documents with its own settings
+//
+// NestingLevel: maximum nesting level of the documents, zero means the level of the server
+//
+// SortOrder: order of the child documents in the big documents by the Sort field, "desc" or "asc"
+//
+// CreatedAt: unix time in nanoseconds
+type Collection struct {
+	Name         string           `reindex:"name,hash,pk" json:"Name"`
+	NestingLevel int              `reindex:"nesting_level" json:"NestingLevel"`
+	SortOrder    string           `reindex:"sort_order" json:"SortOrder"`
+	Schema       CollectionSchema `json:"Schema"`
+	CreatedAt    int64            `reindex:"created_at" json:"CreatedAt"`
+}
+
+// Restrictions on the body of the documents of the collection
+//
+// MaxBodyLength: maximum length of the body in bytes, zero means no limit
+//
+// BodyPattern: regular expression the body must match, empty means any body
+//
+// JsonBody: the body must be a valid JSON value
+type CollectionSchema struct {
+	MaxBodyLength int    `json:"MaxBodyLength"`
+	BodyPattern   string `json:"BodyPattern"`
+	JsonBody      bool   `json:"JsonBody"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// Counter of the counter store
+//
+// Value: the last value given by the counter
+type Counter struct {
+	Name  string `reindex:"name,hash,pk" json:"Name"`
+	Value int64  `reindex:"value" json:"Value"`
+}
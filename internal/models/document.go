@@ -1,12 +1,12 @@
 package models
 
 type Document struct {
-	Id        int64   `reindex:"id,,pk" json:"Id"`
-	ParentId  int64   `reindex:"parent_id,,sparse" json:"ParentId"`
-	Depth     int     `reindex:"depth" json:"Depth"`
-	Sort      int     `reindexer:"sort" json:"Sort"`
-	Body      string  `reindex:"body" json:"Body"`
-	ChildList []int64 `reindex:"child_list,,sparse" json:"ChildList"`
+	Id        int64   `reindex:"id,,pk" json:"Id" yaml:"Id"`
+	ParentId  int64   `reindex:"parent_id,,sparse" json:"ParentId" yaml:"ParentId"`
+	Depth     int     `reindex:"depth" json:"Depth" yaml:"Depth"`
+	Sort      int     `reindexer:"sort" json:"Sort" yaml:"Sort"`
+	Body      string  `reindex:"body" json:"Body" yaml:"Body"`
+	ChildList []int64 `reindex:"child_list,,sparse" json:"ChildList" yaml:"ChildList"`
 }
 
 func (doc *Document) DeepCopy() interface{} {
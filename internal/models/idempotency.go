@@ -0,0 +1,20 @@
+package models
+
+// Saved response of a request with an idempotency key
+//
+// Key: hash of the client, the endpoint and the key from the header
+//
+// Fingerprint: hash of the request, a repeat with another request is rejected
+//
+// Completed: false while the first request is processed
+//
+// ExpiresAt: unix time in nanoseconds
+type IdempotencyRecord struct {
+	Key         string `reindex:"key,hash,pk" json:"Key"`
+	Fingerprint string `json:"Fingerprint"`
+	Completed   bool   `json:"Completed"`
+	Status      int    `json:"Status"`
+	ContentType string `json:"ContentType"`
+	Body        string `json:"Body"`
+	ExpiresAt   int64  `reindex:"expires_at" json:"ExpiresAt"`
+}
@@ -0,0 +1,14 @@
+package models
+
+// Message of the cache invalidation bus kept for the polling replicas
+//
+// CreatedAt: unix time in nanoseconds, the old records are removed
+type InvalidationRecord struct {
+	Id         int64   `reindex:"id,,pk" json:"Id"`
+	Origin     string  `json:"Origin"`
+	Collection string  `json:"Collection"`
+	Ids        []int64 `json:"Ids"`
+	Flush      bool    `json:"Flush"`
+	Rules      bool    `json:"Rules"`
+	CreatedAt  int64   `reindex:"created_at" json:"CreatedAt"`
+}
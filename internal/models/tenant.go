@@ -0,0 +1,20 @@
+package models
+
+// Tenant with its own collection of documents
+//
+// NestingLevel: maximum nesting level of the documents of the tenant, zero means the level from the configuration
+//
+// MaxDocs, MaxBodyBytes: storage quotas of the tenant, zero means the quotas from the configuration
+//
+// ReadRps, WriteRps: requests per second of every client of the tenant, zero means the rates from the configuration
+//
+// CreatedAt: unix time in nanoseconds
+type Tenant struct {
+	Name         string `reindex:"name,hash,pk" json:"Name"`
+	NestingLevel int    `reindex:"nesting_level" json:"NestingLevel"`
+	MaxDocs      int    `reindex:"max_docs" json:"MaxDocs"`
+	MaxBodyBytes int    `reindex:"max_body_bytes" json:"MaxBodyBytes"`
+	ReadRps      int    `reindex:"read_rps" json:"ReadRps"`
+	WriteRps     int    `reindex:"write_rps" json:"WriteRps"`
+	CreatedAt    int64  `reindex:"created_at" json:"CreatedAt"`
+}
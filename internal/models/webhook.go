@@ -0,0 +1,31 @@
+package models
+
+// Registered receiver of the events
+//
+// Types: types of the events to deliver, all types if empty
+//
+// Root: only the events of the subtree of this document are delivered, all events if zero
+type Webhook struct {
+	Id     int64    `reindex:"id,,pk" json:"Id"`
+	Url    string   `reindex:"url" json:"Url"`
+	Secret string   `reindex:"secret" json:"Secret,omitempty"`
+	Types  []string `reindex:"types" json:"Types"`
+	Root   int64    `reindex:"root" json:"Root"`
+}
+
+// Event waiting for delivery to the webhook. The same structure is kept in the dead-letter list
+// after the last failed attempt
+//
+// NextAttempt: unix time in nanoseconds of the next delivery attempt
+//
+// Owner: dispatcher that claimed the entry last, the claim lasts until the next attempt
+type OutboxEntry struct {
+	Id          int64  `reindex:"id,,pk" json:"Id"`
+	WebhookId   int64  `reindex:"webhook_id" json:"WebhookId"`
+	EventType   string `reindex:"event_type" json:"EventType"`
+	Payload     string `reindex:"payload,-" json:"Payload"`
+	Attempts    int    `reindex:"attempts" json:"Attempts"`
+	NextAttempt int64  `reindex:"next_attempt" json:"NextAttempt"`
+	LastError   string `reindex:"last_error,-" json:"LastError"`
+	Owner       string `reindex:"owner,-" json:"Owner"`
+}
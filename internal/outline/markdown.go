@@ -0,0 +1,128 @@
+package outline
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+const indent = "  "
+
+// Converting the tree to a nested markdown list. Lines of a multiline body are written as continuation
+// lines with the indentation of the item text, empty lines of the body stay empty
+func ToMarkdown(bigDoc *models.BigDocument) []byte {
+	buffer := &bytes.Buffer{}
+	writeMarkdownItem(buffer, bigDoc, 0)
+	return buffer.Bytes()
+}
+
+func writeMarkdownItem(buffer *bytes.Buffer, bigDoc *models.BigDocument, level int) {
+	prefix := strings.Repeat(indent, level)
+	lines := strings.Split(bigDoc.Body, "\n")
+	fmt.Fprintf(buffer, "%s- %s\n", prefix, lines[0])
+	for _, line := range lines[1:] {
+		if line == "" {
+			buffer.WriteString("\n")
+			continue
+		}
+		fmt.Fprintf(buffer, "%s  %s\n", prefix, line)
+	}
+	for i := range bigDoc.ChildList {
+		writeMarkdownItem(buffer, &bigDoc.ChildList[i], level+1)
+	}
+}
+
+type markdownItem struct {
+	indent int
+	node   *models.BigDocument
+	childs []*markdownItem
+}
+
+// Building the tree from a nested markdown list. Items may start with "-", "*", "+" or a number with a dot,
+// nesting is defined by the indentation. A heading before the list becomes the body of the root
+// if the list has several top items. Blank lines followed by a continuation line stay in the body
+func FromMarkdown(data []byte) (*models.BigDocument, error) {
+	title := ""
+	root := &markdownItem{indent: -1}
+	stack := []*markdownItem{root}
+	var last *markdownItem
+	// Blank lines after the last item, they belong to its body only if a continuation line follows
+	blank := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.TrimSpace(line) == "" {
+			blank++
+			continue
+		}
+		blankLines := blank
+		blank = 0
+		expanded := strings.ReplaceAll(line, "\t", "    ")
+		content := strings.TrimLeft(expanded, " ")
+		lineIndent := len(expanded) - len(content)
+		if text, ok := listItemText(content); ok {
+			for len(stack) > 1 && stack[len(stack)-1].indent >= lineIndent {
+				stack = stack[:len(stack)-1]
+			}
+			parent := stack[len(stack)-1]
+			last = &markdownItem{indent: lineIndent, node: &models.BigDocument{Body: text}}
+			parent.childs = append(parent.childs, last)
+			stack = append(stack, last)
+			continue
+		}
+		if strings.HasPrefix(content, "#") && last == nil {
+			title = strings.TrimSpace(strings.TrimLeft(content, "#"))
+			continue
+		}
+		// Continuation of the body of the previous item
+		if last != nil && lineIndent > last.indent {
+			last.node.Body += strings.Repeat("\n", blankLines+1) + content
+			continue
+		}
+		return nil, fmt.Errorf("Line is not a list item: %q", line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	items := buildMarkdownNodes(root.childs)
+	if len(items) == 1 {
+		title = ""
+	}
+	return makeRoot(title, items)
+}
+
+func listItemText(content string) (string, bool) {
+	for _, marker := range []string{"- ", "* ", "+ "} {
+		if strings.HasPrefix(content, marker) {
+			return strings.TrimSpace(content[len(marker):]), true
+		}
+	}
+	if content == "-" || content == "*" || content == "+" {
+		return "", true
+	}
+	digits := 0
+	for digits < len(content) && content[digits] >= '0' && content[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 && strings.HasPrefix(content[digits:], ". ") {
+		return strings.TrimSpace(content[digits+2:]), true
+	}
+	return "", false
+}
+
+func buildMarkdownNodes(items []*markdownItem) []models.BigDocument {
+	if len(items) == 0 {
+		return nil
+	}
+	nodes := make([]models.BigDocument, 0, len(items))
+	for _, item := range items {
+		node := *item.node
+		node.ChildList = buildMarkdownNodes(item.childs)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
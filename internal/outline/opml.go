@@ -0,0 +1,69 @@
+package outline
+
+import (
+	"encoding/xml"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+type opml struct {
+	XMLName xml.Name    `xml:"opml"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"head>title"`
+	Body    []opmlEntry `xml:"body>outline"`
+}
+
+type opmlEntry struct {
+	Text     string      `xml:"text,attr"`
+	Outlines []opmlEntry `xml:"outline"`
+}
+
+// Converting the tree to OPML 2.0, the root document becomes the only top outline
+func ToOPML(bigDoc *models.BigDocument) ([]byte, error) {
+	document := opml{
+		Version: "2.0",
+		Title:   bigDoc.Body,
+		Body:    []opmlEntry{toOPMLEntry(bigDoc)},
+	}
+	data, err := xml.MarshalIndent(document, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+func toOPMLEntry(bigDoc *models.BigDocument) opmlEntry {
+	entry := opmlEntry{Text: bigDoc.Body}
+	for i := range bigDoc.ChildList {
+		entry.Outlines = append(entry.Outlines, toOPMLEntry(&bigDoc.ChildList[i]))
+	}
+	return entry
+}
+
+// Building the tree from OPML. The text attribute becomes the body, the sort is taken from the order of outlines
+func FromOPML(data []byte) (*models.BigDocument, error) {
+	var document opml
+	if err := xml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	items := fromOPMLEntries(document.Body)
+	title := ""
+	if len(items) > 1 {
+		title = document.Title
+	}
+	return makeRoot(title, items)
+}
+
+func fromOPMLEntries(entries []opmlEntry) []models.BigDocument {
+	if len(entries) == 0 {
+		return nil
+	}
+	nodes := make([]models.BigDocument, 0, len(entries))
+	for _, entry := range entries {
+		nodes = append(nodes, models.BigDocument{
+			Body:      entry.Text,
+			ChildList: fromOPMLEntries(entry.Outlines),
+		})
+	}
+	return nodes
+}
@@ -0,0 +1,33 @@
+package outline
+
+import (
+	"errors"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+var EmptyOutline = errors.New("Outline has no items")
+
+// Sort values are assigned from the order of the siblings, increasing from 1 for the first one
+func setSort(nodes []models.BigDocument) {
+	for i := range nodes {
+		nodes[i].Sort = i + 1
+		setSort(nodes[i].ChildList)
+	}
+}
+
+// A single top item becomes the root, several items are placed under a new root with the title as a body
+func makeRoot(title string, items []models.BigDocument) (*models.BigDocument, error) {
+	if len(items) == 0 {
+		return nil, EmptyOutline
+	}
+	setSort(items)
+	if len(items) == 1 && title == "" {
+		items[0].Sort = 0
+		return &items[0], nil
+	}
+	return &models.BigDocument{
+		Body:      title,
+		ChildList: items,
+	}, nil
+}
@@ -0,0 +1,112 @@
+package outline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+// Tree written as body:sort with the children in brackets in the order of the list
+func treeString(node *models.BigDocument) string {
+	result := fmt.Sprintf("%q:%d", node.Body, node.Sort)
+	if len(node.ChildList) == 0 {
+		return result
+	}
+	childs := []string{}
+	for i := range node.ChildList {
+		childs = append(childs, treeString(&node.ChildList[i]))
+	}
+	return result + "[" + strings.Join(childs, " ") + "]"
+}
+
+func sampleTree() *models.BigDocument {
+	return &models.BigDocument{
+		Body: "Plan",
+		ChildList: []models.BigDocument{
+			{Body: "First\n\nsecond paragraph", ChildList: []models.BigDocument{{Body: "Nested"}}},
+			{Body: "Second\nline"},
+		},
+	}
+}
+
+const sampleWant = `"Plan":0["First\n\nsecond paragraph":1["Nested":1] "Second\nline":2]`
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	data := ToMarkdown(sampleTree())
+	want := "- Plan\n  - First\n\n    second paragraph\n    - Nested\n  - Second\n    line\n"
+	if string(data) != want {
+		t.Fatalf("got markdown %q, want %q", data, want)
+	}
+	bigDoc, err := FromMarkdown(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := treeString(bigDoc); got != sampleWant {
+		t.Fatalf("got tree %s, want %s", got, sampleWant)
+	}
+}
+
+func TestOPMLRoundTrip(t *testing.T) {
+	data, err := ToOPML(sampleTree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigDoc, err := FromOPML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := treeString(bigDoc); got != sampleWant {
+		t.Fatalf("got tree %s, want %s", got, sampleWant)
+	}
+}
+
+func TestFromMarkdown(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			"several top items under the heading",
+			"# Title\n\n* one\n\t+ child\n2. two\n",
+			`"Title":0["one":1["child":1] "two":2]`,
+		},
+		{
+			"single top item without the heading",
+			"# Title\n- one\n  - child\n",
+			`"one":0["child":1]`,
+		},
+		{
+			"blank lines between the items",
+			"- root\n\n  - one\n\n\n  - two\n",
+			`"root":0["one":1 "two":2]`,
+		},
+		{
+			"paragraphs of the body",
+			"- root\n  first\n\n\n  second\n",
+			`"root\nfirst\n\n\nsecond":0`,
+		},
+	}
+	for _, test := range tests {
+		bigDoc, err := FromMarkdown([]byte(test.input))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := treeString(bigDoc); got != test.want {
+			t.Errorf("%s: got tree %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFromMarkdownErrors(t *testing.T) {
+	if _, err := FromMarkdown([]byte("# Title only\n")); !errors.Is(err, EmptyOutline) {
+		t.Errorf("got error %v for the outline without items", err)
+	}
+	if _, err := FromMarkdown([]byte("plain text\n- item\n")); err == nil {
+		t.Error("line before the list is accepted")
+	}
+}
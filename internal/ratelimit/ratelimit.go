@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Interval of removing the buckets that are full again
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Token bucket for every key. The bucket holds up to burst tokens and gets rate tokens per second
+type Limiter struct {
+	sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Taking a token from the bucket of the key. If the bucket is empty, returns the time until the next token
+func (limiter *Limiter) Allow(key string) (bool, time.Duration) {
+	limiter.Lock()
+	defer limiter.Unlock()
+	now := limiter.now()
+	limiter.sweep(now)
+	item, exist := limiter.buckets[key]
+	if !exist {
+		item = &bucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = item
+	}
+	item.tokens = math.Min(limiter.burst, item.tokens+now.Sub(item.last).Seconds()*limiter.rate)
+	item.last = now
+	if item.tokens >= 1 {
+		item.tokens--
+		return true, 0
+	}
+	wait := (1 - item.tokens) / limiter.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// Removing the buckets that would be full by now, they are the same as new ones
+func (limiter *Limiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < sweepInterval {
+		return
+	}
+	limiter.lastSweep = now
+	for key, item := range limiter.buckets {
+		if item.tokens+now.Sub(item.last).Seconds()*limiter.rate >= limiter.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// Limiter with the clock moved by the test
+func newTestLimiter(rate float64, burst int) (*Limiter, *time.Time) {
+	limiter := NewLimiter(rate, burst)
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+	limiter.lastSweep = now
+	return limiter, &now
+}
+
+func TestBurstAndRefill(t *testing.T) {
+	limiter, now := newTestLimiter(2, 3)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("client"); !allowed {
+			t.Fatalf("request %d of the burst is rejected", i+1)
+		}
+	}
+	allowed, wait := limiter.Allow("client")
+	if allowed {
+		t.Fatal("request over the burst is allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("got wait %v, want 500ms", wait)
+	}
+
+	*now = now.Add(250 * time.Millisecond)
+	allowed, wait = limiter.Allow("client")
+	if allowed || wait != 250*time.Millisecond {
+		t.Fatalf("got allowed %v and wait %v before the token is refilled", allowed, wait)
+	}
+	*now = now.Add(250 * time.Millisecond)
+	if allowed, _ := limiter.Allow("client"); !allowed {
+		t.Fatal("request is rejected after the token is refilled")
+	}
+
+	// The bucket is never filled over the burst
+	*now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("client"); !allowed {
+			t.Fatalf("request %d of the burst is rejected after the pause", i+1)
+		}
+	}
+	if allowed, _ := limiter.Allow("client"); allowed {
+		t.Fatal("bucket holds more tokens than the burst")
+	}
+}
+
+func TestKeysHaveSeparateBuckets(t *testing.T) {
+	limiter, _ := newTestLimiter(1, 1)
+	if allowed, _ := limiter.Allow("first"); !allowed {
+		t.Fatal("first request of the key is rejected")
+	}
+	if allowed, _ := limiter.Allow("first"); allowed {
+		t.Fatal("request over the burst is allowed")
+	}
+	if allowed, _ := limiter.Allow("second"); !allowed {
+		t.Fatal("another key uses the bucket of the first one")
+	}
+}
+
+func TestSweepRemovesFullBuckets(t *testing.T) {
+	limiter, now := newTestLimiter(0.1, 1)
+	limiter.Allow("idle")
+	*now = now.Add(sweepInterval - time.Second)
+	limiter.Allow("busy")
+	*now = now.Add(time.Second)
+	limiter.Allow("other")
+	if _, exist := limiter.buckets["idle"]; exist {
+		t.Fatal("full bucket is not removed")
+	}
+	if _, exist := limiter.buckets["busy"]; !exist {
+		t.Fatal("bucket that is not full is removed")
+	}
+}
@@ -0,0 +1,334 @@
+package typedcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of the parts of the cache with their own locks, the entries are spread by the hash of the key
+const shardCount = 32
+
+// LifeTime: time the entry is kept after the last access, for the TTL policy after the last write
+//
+// CleaningInterval: interval of removing the expired entries, they are not removed if it is zero
+//
+// MaxEntries, MaxBytes: limits of the number and the approximate size of all entries, zero means no limit.
+// An entry larger than MaxBytes is not cached
+//
+// Policy: rule of choosing the entry to evict when a limit is reached
+//
+// NegativeTtl: time the keys that were not found by the loader are remembered, zero disables it
+//
+// Size: approximate memory used by the entry, the entries are not counted in MaxBytes without it
+//
+// Hash: spreading the keys over the shards, the integer and string keys are hashed by default
+type Options[K comparable, V any] struct {
+	LifeTime         time.Duration
+	CleaningInterval time.Duration
+	MaxEntries       int
+	MaxBytes         int64
+	Policy           Policy
+	NegativeTtl      time.Duration
+	Size             func(key K, value V) int64
+	Hash             func(key K) uint64
+}
+
+// Cache of the values of any type with the lifetime and the limits. The cached value is never changed
+// by the cache, an update replaces it, so the returned values can be read without locks as long as
+// the callers do not change them either
+type Cache[K comparable, V any] struct {
+	lifeTime         time.Duration
+	cleaningInterval time.Duration
+	policy           Policy
+	evictBefore      evictionOrder
+	shards           [shardCount]*shard[K, V]
+	done             chan struct{}
+	closeOnce        sync.Once
+	maxEntries       int
+	maxBytes         int64
+	negativeTtl      time.Duration
+	size             func(key K, value V) int64
+	hash             func(key K) uint64
+	// Number and size of the entries of all shards, accessed atomically
+	entries int64
+	bytes   int64
+	// Shard the next eviction starts from, accessed atomically
+	nextVictim uint32
+	// Loads of the values missing in the cache
+	flights flightGroup[K, V]
+	// Counters of the statistics, accessed atomically
+	hits         uint64
+	misses       uint64
+	evictions    uint64
+	expirations  uint64
+	negativeHits uint64
+	coalesced    uint64
+}
+
+type shard[K comparable, V any] struct {
+	sync.RWMutex
+	items map[K]*item[K, V]
+	// Expiration times of the keys that were not found by the loader
+	missing map[K]int64
+	// Changed by every update and deletion, a value loaded during a change is not cached
+	generation uint64
+	// Sum of the sizes of the entries
+	bytes        int64
+	missingLimit int
+}
+
+type item[K comparable, V any] struct {
+	meta
+	size int64
+	// Time the entry was put to the cache
+	created int64
+	key     K
+	value   V
+}
+
+// Times and counters of the entry compared by the eviction policies
+type meta struct {
+	// Accessed atomically, they are changed under the read lock of the shard
+	expiration int64
+	lastAccess int64
+	hits       int64
+}
+
+// An unknown policy is replaced with LRU
+func New[K comparable, V any](options Options[K, V]) *Cache[K, V] {
+	policy, err := ParsePolicy(string(options.Policy))
+	if err != nil {
+		policy = LRU
+	}
+	cache := &Cache[K, V]{
+		lifeTime:         options.LifeTime,
+		cleaningInterval: options.CleaningInterval,
+		policy:           policy,
+		evictBefore:      policies[policy],
+		done:             make(chan struct{}),
+		maxEntries:       options.MaxEntries,
+		maxBytes:         options.MaxBytes,
+		negativeTtl:      options.NegativeTtl,
+		size:             options.Size,
+		hash:             options.Hash,
+		flights:          flightGroup[K, V]{calls: make(map[K]*flight[V])},
+	}
+	if cache.hash == nil {
+		cache.hash = defaultHash[K]
+	}
+	for i := range cache.shards {
+		cache.shards[i] = &shard[K, V]{
+			items:        make(map[K]*item[K, V]),
+			missing:      make(map[K]int64),
+			missingLimit: missingLimit(options.MaxEntries),
+		}
+	}
+
+	// Starting the garbage collector at a non-zero cleaning time
+	if options.CleaningInterval > 0 {
+		go cache.garbageCollector()
+	}
+
+	return cache
+}
+
+// Part of the limit of the entries for the missing keys of one shard, not less than one
+func missingLimit(maxEntries int) int {
+	if maxEntries <= 0 {
+		return defaultMissingLimit
+	}
+	return (maxEntries + shardCount - 1) / shardCount
+}
+
+func (cache *Cache[K, V]) shard(key K) *shard[K, V] {
+	return cache.shards[cache.hash(key)%shardCount]
+}
+
+func (cache *Cache[K, V]) newItem(key K, value V) *item[K, V] {
+	now := time.Now()
+	entry := &item[K, V]{
+		meta: meta{
+			expiration: now.Add(cache.lifeTime).UnixNano(),
+			lastAccess: now.UnixNano(),
+		},
+		created: now.UnixNano(),
+		key:     key,
+		value:   value,
+	}
+	if cache.size != nil {
+		entry.size = cache.size(key, value)
+	}
+	return entry
+}
+
+// When the cleaning time comes, it removes the entries with the expired lifetime
+func (cache *Cache[K, V]) garbageCollector() {
+	ticker := time.NewTicker(cache.cleaningInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-cache.done:
+			return
+		}
+		cache.clearExpired(time.Now().UnixNano())
+	}
+}
+
+// Stopping the garbage collector of the cache that is no longer used
+func (cache *Cache[K, V]) Close() {
+	cache.closeOnce.Do(func() {
+		close(cache.done)
+	})
+}
+
+// Every shard is locked separately, so the requests to the other shards are not stopped
+func (cache *Cache[K, V]) clearExpired(now int64) {
+	for _, part := range cache.shards {
+		part.Lock()
+		for key, entry := range part.items {
+			if expiration := atomic.LoadInt64(&entry.expiration); now > expiration && expiration > 0 {
+				cache.remove(part, key)
+				atomic.AddUint64(&cache.expirations, 1)
+			}
+		}
+		for key, expiration := range part.missing {
+			if now > expiration {
+				delete(part.missing, key)
+			}
+		}
+		part.Unlock()
+	}
+}
+
+// Returns false if the entry does not fit the limits alone and was not put.
+// Must be called under the write lock of the shard
+func (cache *Cache[K, V]) put(part *shard[K, V], entry *item[K, V]) bool {
+	cache.remove(part, entry.key)
+	if cache.maxBytes > 0 && entry.size > cache.maxBytes {
+		atomic.AddUint64(&cache.evictions, 1)
+		return false
+	}
+	part.items[entry.key] = entry
+	part.bytes += entry.size
+	atomic.AddInt64(&cache.entries, 1)
+	atomic.AddInt64(&cache.bytes, entry.size)
+	return true
+}
+
+// Must be called under the write lock of the shard
+func (cache *Cache[K, V]) remove(part *shard[K, V], key K) {
+	if entry, exist := part.items[key]; exist {
+		part.bytes -= entry.size
+		delete(part.items, key)
+		atomic.AddInt64(&cache.entries, -1)
+		atomic.AddInt64(&cache.bytes, -entry.size)
+	}
+}
+
+func (cache *Cache[K, V]) full() bool {
+	return (cache.maxEntries > 0 && atomic.LoadInt64(&cache.entries) > int64(cache.maxEntries)) ||
+		(cache.maxBytes > 0 && atomic.LoadInt64(&cache.bytes) > cache.maxBytes)
+}
+
+// Evicting the entries until the cache fits its limits. The shards are visited in turn and locked
+// one at a time, the victim is chosen by the policy among the samples of one shard. The kept entry
+// is evicted only if no other entry is left. Must be called without the locks of the shards
+func (cache *Cache[K, V]) evict(keep K) {
+	for empty := 0; cache.full() && empty < shardCount; {
+		part := cache.shards[atomic.AddUint32(&cache.nextVictim, 1)%shardCount]
+		part.Lock()
+		var victim *item[K, V]
+		sampled := 0
+		for key, entry := range part.items {
+			if key == keep {
+				continue
+			}
+			if victim == nil || cache.evictBefore(&entry.meta, &victim.meta) {
+				victim = entry
+			}
+			if sampled++; sampled == evictionSamples {
+				break
+			}
+		}
+		if victim != nil && cache.full() {
+			cache.remove(part, victim.key)
+			atomic.AddUint64(&cache.evictions, 1)
+			empty = 0
+		} else {
+			empty++
+		}
+		part.Unlock()
+	}
+	if cache.full() {
+		part := cache.shard(keep)
+		part.Lock()
+		if _, exist := part.items[keep]; exist && cache.full() {
+			cache.remove(part, keep)
+			atomic.AddUint64(&cache.evictions, 1)
+		}
+		part.Unlock()
+	}
+}
+
+func (cache *Cache[K, V]) Set(key K, value V) {
+	part := cache.shard(key)
+	part.Lock()
+	delete(part.missing, key)
+	cache.put(part, cache.newItem(key, value))
+	part.Unlock()
+	cache.evict(key)
+}
+
+func (cache *Cache[K, V]) Delete(key K) {
+	part := cache.shard(key)
+	part.Lock()
+	part.generation++
+	delete(part.missing, key)
+	cache.remove(part, key)
+	part.Unlock()
+}
+
+// Returning the value and prolonging its lifetime, except for the TTL policy
+func (cache *Cache[K, V]) Get(key K) (V, bool) {
+	part := cache.shard(key)
+	part.RLock()
+	defer part.RUnlock()
+	entry, exist := part.items[key]
+	if !exist {
+		atomic.AddUint64(&cache.misses, 1)
+		var empty V
+		return empty, false
+	}
+	atomic.AddUint64(&cache.hits, 1)
+	now := time.Now()
+	atomic.StoreInt64(&entry.lastAccess, now.UnixNano())
+	atomic.AddInt64(&entry.hits, 1)
+	if cache.policy != TTL {
+		atomic.StoreInt64(&entry.expiration, now.Add(cache.lifeTime).UnixNano())
+	}
+	return entry.value, true
+}
+
+// Replacing the cached value with the one returned by the update. The update gets the cached value
+// and must return a changed copy instead of changing it. Returns false if the key is not cached,
+// a load of the key that is in progress is not cached in any case
+func (cache *Cache[K, V]) Update(key K, update func(value V) V) bool {
+	part := cache.shard(key)
+	part.Lock()
+	part.generation++
+	delete(part.missing, key)
+	entry, exist := part.items[key]
+	if !exist {
+		part.Unlock()
+		return false
+	}
+	updated := cache.newItem(key, update(entry.value))
+	updated.hits = atomic.LoadInt64(&entry.hits)
+	updated.created = entry.created
+	cache.put(part, updated)
+	part.Unlock()
+	cache.evict(key)
+	return true
+}
@@ -0,0 +1,75 @@
+package typedcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxEntriesIsGlobal(t *testing.T) {
+	cache := New(Options[int, string]{LifeTime: time.Minute, MaxEntries: 10})
+	defer cache.Close()
+
+	for key := 0; key < 100; key++ {
+		cache.Set(key, "value")
+	}
+	if entries := cache.Stats().Entries; entries != 10 {
+		t.Fatalf("cache keeps %d entries, want 10", entries)
+	}
+	if _, found := cache.Get(99); !found {
+		t.Fatal("the last entry was evicted")
+	}
+}
+
+func TestMaxBytesIsGlobal(t *testing.T) {
+	cache := New(Options[int, string]{
+		LifeTime: time.Minute,
+		MaxBytes: 1000,
+		Size: func(key int, value string) int64 {
+			return int64(len(value))
+		},
+	})
+	defer cache.Close()
+
+	// Larger than the part of the limit a shard would have, but fits the whole limit
+	large := string(make([]byte, 600))
+	cache.Set(1, large)
+	if _, found := cache.Get(1); !found {
+		t.Fatal("entry fitting the limit was not cached")
+	}
+	cache.Set(2, large)
+	stats := cache.Stats()
+	if stats.Bytes > 1000 || stats.Entries != 1 {
+		t.Fatalf("cache keeps %d entries of %d bytes", stats.Entries, stats.Bytes)
+	}
+	if _, found := cache.Get(2); !found {
+		t.Fatal("the last entry was evicted")
+	}
+	cache.Set(3, string(make([]byte, 1001)))
+	if _, found := cache.Get(3); found {
+		t.Fatal("entry larger than the limit was cached")
+	}
+}
+
+func TestLimitsUnderConcurrentWrites(t *testing.T) {
+	cache := New(Options[int, int]{LifeTime: time.Minute, MaxEntries: 50})
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := worker*1000 + i
+				cache.Set(key, key)
+				cache.Update(key-1, func(value int) int { return value + 1 })
+				cache.Get(key - 2)
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if entries := cache.Stats().Entries; entries > 50 {
+		t.Fatalf("cache keeps %d entries, want at most 50", entries)
+	}
+}
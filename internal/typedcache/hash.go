@@ -0,0 +1,34 @@
+package typedcache
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Hash of the key used when the options have no hash function. The integers are used as they are,
+// so the sequential ids are spread evenly, the other keys are hashed by their text
+func defaultHash[K comparable](key K) uint64 {
+	switch value := any(key).(type) {
+	case int:
+		return uint64(value)
+	case int64:
+		return uint64(value)
+	case int32:
+		return uint64(value)
+	case uint:
+		return uint64(value)
+	case uint64:
+		return value
+	case uint32:
+		return uint64(value)
+	case string:
+		return hashString(value)
+	}
+	return hashString(fmt.Sprint(key))
+}
+
+func hashString(value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	return hash.Sum64()
+}
@@ -0,0 +1,106 @@
+package typedcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of the missing keys a shard keeps if the number of the entries is not limited
+const defaultMissingLimit = 4096
+
+// Load of one key, the concurrent loads of the key wait for it
+type flight[V any] struct {
+	sync.WaitGroup
+	value V
+	found bool
+}
+
+type flightGroup[K comparable, V any] struct {
+	sync.Mutex
+	calls map[K]*flight[V]
+}
+
+// Calling the load once for the concurrent calls with the same key. Reports whether the result was shared
+func (group *flightGroup[K, V]) do(key K, load func() (V, bool)) (V, bool, bool) {
+	group.Lock()
+	if call, exist := group.calls[key]; exist {
+		group.Unlock()
+		call.Wait()
+		return call.value, call.found, true
+	}
+	call := &flight[V]{}
+	call.Add(1)
+	group.calls[key] = call
+	group.Unlock()
+
+	defer func() {
+		group.Lock()
+		delete(group.calls, key)
+		group.Unlock()
+		call.Done()
+	}()
+	call.value, call.found = load()
+	return call.value, call.found, false
+}
+
+// Returning the value from the cache or loading it. Concurrent calls for the same key wait for
+// one load. The keys that are not found are remembered for the negative lifetime. The loaded value
+// is not cached if the key was updated or deleted during the load
+func (cache *Cache[K, V]) Load(key K, load func() (V, bool)) (V, bool) {
+	if value, found := cache.Get(key); found {
+		return value, true
+	}
+	part := cache.shard(key)
+	if part.isMissing(key, time.Now().UnixNano()) {
+		atomic.AddUint64(&cache.negativeHits, 1)
+		var empty V
+		return empty, false
+	}
+	value, found, shared := cache.flights.do(key, func() (V, bool) {
+		part.RLock()
+		generation := part.generation
+		part.RUnlock()
+		value, found := load()
+		if cache.store(part, key, value, found, generation) {
+			cache.evict(key)
+		}
+		return value, found
+	})
+	if shared {
+		atomic.AddUint64(&cache.coalesced, 1)
+	}
+	return value, found
+}
+
+// Reports whether the value was put to the cache
+func (cache *Cache[K, V]) store(part *shard[K, V], key K, value V, found bool, generation uint64) bool {
+	part.Lock()
+	defer part.Unlock()
+	if part.generation != generation {
+		return false
+	}
+	if found {
+		delete(part.missing, key)
+		return cache.put(part, cache.newItem(key, value))
+	}
+	if cache.negativeTtl <= 0 {
+		return false
+	}
+	if _, exist := part.missing[key]; !exist && len(part.missing) >= part.missingLimit {
+		// Any key is dropped, they all expire soon
+		for missingKey := range part.missing {
+			delete(part.missing, missingKey)
+			break
+		}
+	}
+	part.missing[key] = time.Now().Add(cache.negativeTtl).UnixNano()
+	return false
+}
+
+func (part *shard[K, V]) isMissing(key K, now int64) bool {
+	part.RLock()
+	defer part.RUnlock()
+	expiration, exist := part.missing[key]
+	return exist && expiration >= now
+}
@@ -0,0 +1,48 @@
+package typedcache
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Rule of choosing the entry to evict when the cache is full
+type Policy string
+
+const (
+	// Evicting the entry that was read the longest time ago
+	LRU Policy = "lru"
+	// Evicting the entry that was read the least number of times
+	LFU Policy = "lfu"
+	// Evicting the entry that expires the soonest, reads do not prolong the lifetime
+	TTL Policy = "ttl"
+)
+
+// Number of the entries of the shard compared to choose the one to evict.
+// The eviction is approximate, but does not need to keep the entries ordered on every read
+const evictionSamples = 8
+
+// Reports whether the first entry has to be evicted before the second one
+type evictionOrder func(first, second *meta) bool
+
+var policies = map[Policy]evictionOrder{
+	LRU: func(first, second *meta) bool {
+		return atomic.LoadInt64(&first.lastAccess) < atomic.LoadInt64(&second.lastAccess)
+	},
+	LFU: func(first, second *meta) bool {
+		return atomic.LoadInt64(&first.hits) < atomic.LoadInt64(&second.hits)
+	},
+	TTL: func(first, second *meta) bool {
+		return atomic.LoadInt64(&first.expiration) < atomic.LoadInt64(&second.expiration)
+	},
+}
+
+// Checking the name of the policy, the empty name means LRU
+func ParsePolicy(name string) (Policy, error) {
+	if name == "" {
+		return LRU, nil
+	}
+	if _, exist := policies[Policy(name)]; !exist {
+		return "", fmt.Errorf("Unknown cache eviction policy: %s", name)
+	}
+	return Policy(name), nil
+}
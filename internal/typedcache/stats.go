@@ -0,0 +1,136 @@
+package typedcache
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Hits, Misses: reads of the keys found and not found in the cache
+//
+// Evictions, Expirations: entries removed because of the limits and because of the lifetime
+//
+// AverageAge: average time in seconds since the entries were put to the cache
+//
+// NegativeHits, NegativeEntries: reads answered by the remembered missing keys and the number of such keys
+//
+// Coalesced: reads that waited for the load of the same key by another read
+type Stats struct {
+	Hits            uint64  `json:"Hits" yaml:"Hits"`
+	Misses          uint64  `json:"Misses" yaml:"Misses"`
+	HitRatio        float64 `json:"HitRatio" yaml:"HitRatio"`
+	Evictions       uint64  `json:"Evictions" yaml:"Evictions"`
+	Expirations     uint64  `json:"Expirations" yaml:"Expirations"`
+	Entries         int     `json:"Entries" yaml:"Entries"`
+	Bytes           int64   `json:"Bytes" yaml:"Bytes"`
+	AverageAge      float64 `json:"AverageAge" yaml:"AverageAge"`
+	MaxEntries      int     `json:"MaxEntries" yaml:"MaxEntries"`
+	MaxBytes        int64   `json:"MaxBytes" yaml:"MaxBytes"`
+	Policy          Policy  `json:"Policy" yaml:"Policy"`
+	NegativeHits    uint64  `json:"NegativeHits" yaml:"NegativeHits"`
+	NegativeEntries int     `json:"NegativeEntries" yaml:"NegativeEntries"`
+	Coalesced       uint64  `json:"Coalesced" yaml:"Coalesced"`
+}
+
+// State of the cached entry, the times are in unix nanoseconds
+type Entry[V any] struct {
+	Value      V
+	Size       int64
+	Hits       int64
+	CreatedAt  int64
+	LastAccess int64
+	ExpiresAt  int64
+}
+
+func (cache *Cache[K, V]) Stats() Stats {
+	stats := Stats{
+		Hits:         atomic.LoadUint64(&cache.hits),
+		Misses:       atomic.LoadUint64(&cache.misses),
+		Evictions:    atomic.LoadUint64(&cache.evictions),
+		Expirations:  atomic.LoadUint64(&cache.expirations),
+		NegativeHits: atomic.LoadUint64(&cache.negativeHits),
+		Coalesced:    atomic.LoadUint64(&cache.coalesced),
+		MaxEntries:   cache.maxEntries,
+		MaxBytes:     cache.maxBytes,
+		Policy:       cache.policy,
+	}
+	if reads := stats.Hits + stats.Misses; reads > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(reads)
+	}
+	now := time.Now().UnixNano()
+	var totalAge float64
+	for _, part := range cache.shards {
+		part.RLock()
+		stats.Entries += len(part.items)
+		stats.Bytes += part.bytes
+		stats.NegativeEntries += len(part.missing)
+		for _, entry := range part.items {
+			totalAge += float64(now - entry.created)
+		}
+		part.RUnlock()
+	}
+	if stats.Entries > 0 {
+		stats.AverageAge = totalAge / float64(stats.Entries) / float64(time.Second)
+	}
+	return stats
+}
+
+// Inspecting the cached entry, the read is not counted and does not prolong its lifetime
+func (cache *Cache[K, V]) Entry(key K) (Entry[V], bool) {
+	part := cache.shard(key)
+	part.RLock()
+	defer part.RUnlock()
+	entry, exist := part.items[key]
+	if !exist {
+		return Entry[V]{}, false
+	}
+	return Entry[V]{
+		Value:      entry.value,
+		Size:       entry.size,
+		Hits:       atomic.LoadInt64(&entry.hits),
+		CreatedAt:  entry.created,
+		LastAccess: atomic.LoadInt64(&entry.lastAccess),
+		ExpiresAt:  atomic.LoadInt64(&entry.expiration),
+	}, true
+}
+
+// Removing all entries and the missing keys, the counters are kept
+func (cache *Cache[K, V]) Flush() {
+	for _, part := range cache.shards {
+		part.Lock()
+		part.missing = make(map[K]int64)
+		part.generation++
+		atomic.AddInt64(&cache.entries, -int64(len(part.items)))
+		atomic.AddInt64(&cache.bytes, -part.bytes)
+		part.items = make(map[K]*item[K, V])
+		part.bytes = 0
+		part.Unlock()
+	}
+}
+
+// Keys of the entries from the most recently used, not more than the limit
+func (cache *Cache[K, V]) Recent(limit int) []K {
+	type access struct {
+		key        K
+		lastAccess int64
+	}
+	accesses := []access{}
+	for _, part := range cache.shards {
+		part.RLock()
+		for key, entry := range part.items {
+			accesses = append(accesses, access{key: key, lastAccess: atomic.LoadInt64(&entry.lastAccess)})
+		}
+		part.RUnlock()
+	}
+	sort.Slice(accesses, func(i, j int) bool {
+		return accesses[i].lastAccess > accesses[j].lastAccess
+	})
+	if len(accesses) > limit {
+		accesses = accesses[:limit]
+	}
+	keys := make([]K, 0, len(accesses))
+	for _, access := range accesses {
+		keys = append(keys, access.key)
+	}
+	return keys
+}
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"encoding/xml"
+	"reflect"
+	"sort"
+)
+
+// Map that encoding/xml can write: every key becomes an element with the value, in the order of the keys.
+// Nested maps with string keys are written the same way
+type XMLMap map[string]interface{}
+
+var xmlMapType = reflect.TypeOf(XMLMap(nil))
+
+func (data XMLMap) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := data[key]
+		if nested := reflect.ValueOf(value); nested.IsValid() && nested.Type().ConvertibleTo(xmlMapType) {
+			value = nested.Convert(xmlMapType).Interface()
+		}
+		if err := encoder.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
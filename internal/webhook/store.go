@@ -0,0 +1,153 @@
+package webhook
+
+import (
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Storage of the webhooks, the outbox and the dead-letter list
+type store interface {
+	open() error
+	insertWebhook(webhook *models.Webhook) error
+	listWebhooks() ([]*models.Webhook, error)
+	// Removing the webhook together with its outbox entries, false if it does not exist
+	deleteWebhook(id int64) (bool, error)
+	// Writing the entries in one transaction
+	insertEntries(entries []*models.OutboxEntry) error
+	// Entries with the next attempt not later than the time, in the order of their ids
+	dueEntries(now int64, limit int) ([]*models.OutboxEntry, error)
+	// Setting the owner and the next attempt of the entry only if its next attempt is still the one
+	// that was read, false if another dispatcher has claimed or delivered it
+	claim(entry *models.OutboxEntry, owner string, until int64) (bool, error)
+	saveEntry(entry *models.OutboxEntry) error
+	deleteEntry(entry *models.OutboxEntry) error
+	listDeadLetters() ([]*models.OutboxEntry, error)
+	deadLetter(id int64) (*models.OutboxEntry, bool)
+	// Moving the entry from the outbox to the dead-letter list
+	bury(entry *models.OutboxEntry) error
+	// Moving the entry from the dead-letter list to the outbox
+	revive(entry *models.OutboxEntry) error
+}
+
+type reindexerStore struct {
+	db          *reindexer.Reindexer
+	hooks       string
+	outbox      string
+	deadLetters string
+}
+
+func (store *reindexerStore) open() error {
+	if err := store.db.OpenNamespace(store.hooks, reindexer.DefaultNamespaceOptions(), models.Webhook{}); err != nil {
+		return err
+	}
+	if err := store.db.OpenNamespace(store.outbox, reindexer.DefaultNamespaceOptions(), models.OutboxEntry{}); err != nil {
+		return err
+	}
+	return store.db.OpenNamespace(store.deadLetters, reindexer.DefaultNamespaceOptions(), models.OutboxEntry{})
+}
+
+func (store *reindexerStore) insertWebhook(webhook *models.Webhook) error {
+	_, err := store.db.Insert(store.hooks, webhook, "id=serial()")
+	return err
+}
+
+func (store *reindexerStore) listWebhooks() ([]*models.Webhook, error) {
+	iterator := store.db.Query(store.hooks).Sort("id", false).Exec()
+	defer iterator.Close()
+	result := []*models.Webhook{}
+	for iterator.Next() {
+		result = append(result, iterator.Object().(*models.Webhook))
+	}
+	return result, iterator.Error()
+}
+
+func (store *reindexerStore) deleteWebhook(id int64) (bool, error) {
+	count, err := store.db.Query(store.hooks).WhereInt64("id", reindexer.EQ, id).Delete()
+	if err != nil || count == 0 {
+		return false, err
+	}
+	_, err = store.db.Query(store.outbox).WhereInt64("webhook_id", reindexer.EQ, id).Delete()
+	return true, err
+}
+
+func (store *reindexerStore) insertEntries(entries []*models.OutboxEntry) error {
+	tx, err := store.db.BeginTx(store.outbox)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := tx.Insert(entry, "id=serial()"); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *reindexerStore) dueEntries(now int64, limit int) ([]*models.OutboxEntry, error) {
+	iterator := store.db.Query(store.outbox).
+		WhereInt64("next_attempt", reindexer.LE, now).
+		Sort("id", false).
+		Limit(limit).
+		Exec()
+	defer iterator.Close()
+	entries := []*models.OutboxEntry{}
+	for iterator.Next() {
+		entries = append(entries, iterator.Object().(*models.OutboxEntry))
+	}
+	return entries, iterator.Error()
+}
+
+func (store *reindexerStore) claim(entry *models.OutboxEntry, owner string, until int64) (bool, error) {
+	iterator := store.db.Query(store.outbox).
+		WhereInt64("id", reindexer.EQ, entry.Id).
+		WhereInt64("next_attempt", reindexer.EQ, entry.NextAttempt).
+		Set("next_attempt", until).
+		Set("owner", owner).
+		Update()
+	defer iterator.Close()
+	if err := iterator.Error(); err != nil {
+		return false, err
+	}
+	return iterator.Count() == 1, nil
+}
+
+func (store *reindexerStore) saveEntry(entry *models.OutboxEntry) error {
+	return store.db.Upsert(store.outbox, entry)
+}
+
+func (store *reindexerStore) deleteEntry(entry *models.OutboxEntry) error {
+	return store.db.Delete(store.outbox, entry)
+}
+
+func (store *reindexerStore) listDeadLetters() ([]*models.OutboxEntry, error) {
+	iterator := store.db.Query(store.deadLetters).Sort("id", false).Exec()
+	defer iterator.Close()
+	result := []*models.OutboxEntry{}
+	for iterator.Next() {
+		result = append(result, iterator.Object().(*models.OutboxEntry))
+	}
+	return result, iterator.Error()
+}
+
+func (store *reindexerStore) deadLetter(id int64) (*models.OutboxEntry, bool) {
+	item, found := store.db.Query(store.deadLetters).WhereInt64("id", reindexer.EQ, id).Get()
+	if !found {
+		return nil, false
+	}
+	return item.(*models.OutboxEntry), true
+}
+
+func (store *reindexerStore) bury(entry *models.OutboxEntry) error {
+	if err := store.db.Upsert(store.deadLetters, entry); err != nil {
+		return err
+	}
+	return store.db.Delete(store.outbox, entry)
+}
+
+func (store *reindexerStore) revive(entry *models.OutboxEntry) error {
+	if err := store.db.Upsert(store.outbox, entry); err != nil {
+		return err
+	}
+	return store.db.Delete(store.deadLetters, entry)
+}
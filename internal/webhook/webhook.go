@@ -0,0 +1,335 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/models"
+	"github.com/restream/reindexer/v3"
+)
+
+// Headers of the delivery request
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+)
+
+// Number of entries taken from the outbox at once
+const batchSize = 100
+
+// Time added to the request timeout while the claimed entry is kept from other dispatchers
+const claimMargin = time.Minute
+
+var (
+	InvalidUrl      = errors.New("Webhook url must be an absolute http or https url")
+	UnknownType     = errors.New("Unknown event type")
+	WebhookNotExist = errors.New("Webhook doesnt exist")
+	EntryNotExist   = errors.New("Dead letter doesnt exist")
+)
+
+type Options struct {
+	// Number of delivery attempts before the entry is moved to the dead-letter list
+	MaxAttempts int
+	// Delay after the first failed attempt, doubled after each next one
+	Backoff time.Duration
+	// Upper limit of the delay
+	MaxBackoff time.Duration
+	// Timeout of one delivery request
+	Timeout time.Duration
+	// Interval of checking the outbox for due entries
+	PollInterval time.Duration
+}
+
+// Dispatcher keeps the registered webhooks, the outbox and the dead-letter list in its own namespaces
+// and delivers the entries of the outbox in the order of their ids
+type Dispatcher struct {
+	store   store
+	options Options
+	client  *http.Client
+	wake    chan struct{}
+	now     func() time.Time
+	// Random id written to the claimed entries
+	owner string
+}
+
+func NewDispatcher(db *reindexer.Reindexer, prefix string, options Options) *Dispatcher {
+	return newDispatcher(&reindexerStore{
+		db:          db,
+		hooks:       prefix + "_webhooks",
+		outbox:      prefix + "_outbox",
+		deadLetters: prefix + "_dead_letters",
+	}, options)
+}
+
+func newDispatcher(store store, options Options) *Dispatcher {
+	owner := make([]byte, 8)
+	rand.Read(owner)
+	return &Dispatcher{
+		store:   store,
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+		owner:   hex.EncodeToString(owner),
+	}
+}
+
+func (dispatcher *Dispatcher) OpenNamespaces() error {
+	return dispatcher.store.open()
+}
+
+// Delivering the outbox until the context is done
+func (dispatcher *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(dispatcher.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		dispatcher.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-dispatcher.wake:
+		}
+	}
+}
+
+func (dispatcher *Dispatcher) Register(webhook *models.Webhook) error {
+	parsed, err := url.Parse(webhook.Url)
+	if err != nil || !parsed.IsAbs() || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return InvalidUrl
+	}
+	for _, eventType := range webhook.Types {
+		switch events.Type(eventType) {
+		case events.CREATE, events.UPDATE, events.DELETE:
+		default:
+			return fmt.Errorf("%w: %s", UnknownType, eventType)
+		}
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	webhook.Id = 0
+	return dispatcher.store.insertWebhook(webhook)
+}
+
+func (dispatcher *Dispatcher) List() ([]*models.Webhook, error) {
+	return dispatcher.store.listWebhooks()
+}
+
+// Removing the webhook together with its pending entries
+func (dispatcher *Dispatcher) Unregister(id int64) error {
+	exist, err := dispatcher.store.deleteWebhook(id)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return WebhookNotExist
+	}
+	return nil
+}
+
+// Writing an outbox entry for every pair of the event and the webhook subscribed to it. All entries
+// are written in one transaction
+func (dispatcher *Dispatcher) Enqueue(list []*events.Event) error {
+	if len(list) == 0 {
+		return nil
+	}
+	hooks, err := dispatcher.List()
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	now := dispatcher.now().UnixNano()
+	entries := []*models.OutboxEntry{}
+	for _, event := range list {
+		payload, _ := json.Marshal(event)
+		for _, hook := range hooks {
+			if !matchWebhook(hook, event) {
+				continue
+			}
+			entries = append(entries, &models.OutboxEntry{
+				WebhookId:   hook.Id,
+				EventType:   string(event.Type),
+				Payload:     string(payload),
+				NextAttempt: now,
+			})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := dispatcher.store.insertEntries(entries); err != nil {
+		return err
+	}
+	dispatcher.notify()
+	return nil
+}
+
+func matchWebhook(hook *models.Webhook, event *events.Event) bool {
+	if !event.InSubtree(hook.Root) {
+		return false
+	}
+	if len(hook.Types) == 0 {
+		return true
+	}
+	for _, eventType := range hook.Types {
+		if events.Type(eventType) == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func (dispatcher *Dispatcher) DeadLetters() ([]*models.OutboxEntry, error) {
+	return dispatcher.store.listDeadLetters()
+}
+
+// Moving the dead letter back to the outbox with a fresh number of attempts
+func (dispatcher *Dispatcher) Retry(id int64) error {
+	entry, found := dispatcher.store.deadLetter(id)
+	if !found {
+		return EntryNotExist
+	}
+	entry.Attempts = 0
+	entry.Owner = ""
+	entry.NextAttempt = dispatcher.now().UnixNano()
+	if err := dispatcher.store.revive(entry); err != nil {
+		return err
+	}
+	dispatcher.notify()
+	return nil
+}
+
+// Waking the delivery loop without waiting for the next poll
+func (dispatcher *Dispatcher) notify() {
+	select {
+	case dispatcher.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (dispatcher *Dispatcher) deliverDue(ctx context.Context) {
+	entries, err := dispatcher.store.dueEntries(dispatcher.now().UnixNano(), batchSize)
+	if err != nil {
+		log.Printf("Can not read the outbox: %s", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	hooks, err := dispatcher.List()
+	if err != nil {
+		log.Printf("Can not read webhooks: %s", err)
+		return
+	}
+	hookById := make(map[int64]*models.Webhook, len(hooks))
+	for _, hook := range hooks {
+		hookById[hook.Id] = hook
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		hook, exist := hookById[entry.WebhookId]
+		if !exist {
+			dispatcher.store.deleteEntry(entry)
+			continue
+		}
+		// Other replicas read the same outbox, the entry is delivered by the one that claims it first
+		claimed, err := dispatcher.claim(entry)
+		if err != nil {
+			log.Printf("Can not claim entry %d: %s", entry.Id, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := dispatcher.deliver(ctx, hook, entry); err != nil {
+			dispatcher.fail(entry, err)
+			continue
+		}
+		dispatcher.store.deleteEntry(entry)
+	}
+}
+
+// Keeping the entry from other dispatchers until the delivery request times out
+func (dispatcher *Dispatcher) claim(entry *models.OutboxEntry) (bool, error) {
+	until := dispatcher.now().Add(dispatcher.options.Timeout + claimMargin).UnixNano()
+	claimed, err := dispatcher.store.claim(entry, dispatcher.owner, until)
+	if err != nil || !claimed {
+		return false, err
+	}
+	entry.NextAttempt = until
+	entry.Owner = dispatcher.owner
+	return true, nil
+}
+
+func (dispatcher *Dispatcher) deliver(ctx context.Context, hook *models.Webhook, entry *models.OutboxEntry) error {
+	body := []byte(entry.Payload)
+	timestamp := strconv.FormatInt(dispatcher.now().Unix(), 10)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, body))
+	request.Header.Set(TimestampHeader, timestamp)
+	request.Header.Set(DeliveryHeader, strconv.FormatInt(entry.Id, 10))
+	request.Header.Set(EventHeader, entry.EventType)
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Scheduling the next attempt with exponential backoff or moving the entry to the dead-letter list
+func (dispatcher *Dispatcher) fail(entry *models.OutboxEntry, err error) {
+	entry.Attempts++
+	entry.LastError = err.Error()
+	if entry.Attempts >= dispatcher.options.MaxAttempts {
+		if err := dispatcher.store.bury(entry); err != nil {
+			log.Printf("Can not move entry %d to dead letters: %s", entry.Id, err)
+		}
+		return
+	}
+	delay := dispatcher.options.Backoff << (entry.Attempts - 1)
+	if delay > dispatcher.options.MaxBackoff || delay <= 0 {
+		delay = dispatcher.options.MaxBackoff
+	}
+	entry.NextAttempt = dispatcher.now().Add(delay).UnixNano()
+	if err := dispatcher.store.saveEntry(entry); err != nil {
+		log.Printf("Can not reschedule entry %d: %s", entry.Id, err)
+	}
+}
+
+// HMAC-SHA256 of the timestamp and the body joined with a dot, the receiver checks it with the shared secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
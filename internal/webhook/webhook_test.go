@@ -0,0 +1,345 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EwvwGeN/assignment/internal/events"
+	"github.com/EwvwGeN/assignment/internal/models"
+)
+
+// Webhooks, outbox and dead letters kept in memory, the ids are given like serial() does
+type memoryStore struct {
+	hooks       map[int64]*models.Webhook
+	outbox      map[int64]*models.OutboxEntry
+	deadLetters map[int64]*models.OutboxEntry
+	lastHook    int64
+	lastEntry   int64
+	// Called once after the due entries are read
+	afterRead func()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		hooks:       map[int64]*models.Webhook{},
+		outbox:      map[int64]*models.OutboxEntry{},
+		deadLetters: map[int64]*models.OutboxEntry{},
+	}
+}
+
+func (store *memoryStore) open() error { return nil }
+
+func (store *memoryStore) insertWebhook(webhook *models.Webhook) error {
+	store.lastHook++
+	webhook.Id = store.lastHook
+	store.hooks[webhook.Id] = webhook
+	return nil
+}
+
+func (store *memoryStore) listWebhooks() ([]*models.Webhook, error) {
+	result := []*models.Webhook{}
+	for _, hook := range store.hooks {
+		result = append(result, hook)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result, nil
+}
+
+func (store *memoryStore) deleteWebhook(id int64) (bool, error) {
+	if _, exist := store.hooks[id]; !exist {
+		return false, nil
+	}
+	delete(store.hooks, id)
+	for entryId, entry := range store.outbox {
+		if entry.WebhookId == id {
+			delete(store.outbox, entryId)
+		}
+	}
+	return true, nil
+}
+
+func (store *memoryStore) insertEntries(entries []*models.OutboxEntry) error {
+	for _, entry := range entries {
+		store.lastEntry++
+		entry.Id = store.lastEntry
+		copied := *entry
+		store.outbox[entry.Id] = &copied
+	}
+	return nil
+}
+
+func sortedEntries(entries map[int64]*models.OutboxEntry, due func(*models.OutboxEntry) bool) []*models.OutboxEntry {
+	result := []*models.OutboxEntry{}
+	for _, entry := range entries {
+		if due(entry) {
+			copied := *entry
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result
+}
+
+func (store *memoryStore) dueEntries(now int64, limit int) ([]*models.OutboxEntry, error) {
+	result := sortedEntries(store.outbox, func(entry *models.OutboxEntry) bool { return entry.NextAttempt <= now })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	if afterRead := store.afterRead; afterRead != nil {
+		store.afterRead = nil
+		afterRead()
+	}
+	return result, nil
+}
+
+func (store *memoryStore) claim(entry *models.OutboxEntry, owner string, until int64) (bool, error) {
+	stored, exist := store.outbox[entry.Id]
+	if !exist || stored.NextAttempt != entry.NextAttempt {
+		return false, nil
+	}
+	stored.NextAttempt = until
+	stored.Owner = owner
+	return true, nil
+}
+
+func (store *memoryStore) saveEntry(entry *models.OutboxEntry) error {
+	copied := *entry
+	store.outbox[entry.Id] = &copied
+	return nil
+}
+
+func (store *memoryStore) deleteEntry(entry *models.OutboxEntry) error {
+	delete(store.outbox, entry.Id)
+	return nil
+}
+
+func (store *memoryStore) listDeadLetters() ([]*models.OutboxEntry, error) {
+	return sortedEntries(store.deadLetters, func(*models.OutboxEntry) bool { return true }), nil
+}
+
+func (store *memoryStore) deadLetter(id int64) (*models.OutboxEntry, bool) {
+	entry, exist := store.deadLetters[id]
+	if !exist {
+		return nil, false
+	}
+	copied := *entry
+	return &copied, true
+}
+
+func (store *memoryStore) bury(entry *models.OutboxEntry) error {
+	copied := *entry
+	store.deadLetters[entry.Id] = &copied
+	delete(store.outbox, entry.Id)
+	return nil
+}
+
+func (store *memoryStore) revive(entry *models.OutboxEntry) error {
+	copied := *entry
+	store.outbox[entry.Id] = &copied
+	delete(store.deadLetters, entry.Id)
+	return nil
+}
+
+// Receiver answering with the status set by the test and keeping the received requests
+type receiver struct {
+	sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (receiver *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	receiver.Lock()
+	defer receiver.Unlock()
+	receiver.requests = append(receiver.requests, r)
+	receiver.bodies = append(receiver.bodies, body)
+	w.WriteHeader(receiver.status)
+}
+
+func (receiver *receiver) count() int {
+	receiver.Lock()
+	defer receiver.Unlock()
+	return len(receiver.requests)
+}
+
+// Dispatcher on the memory store with the clock moved by the test and a webhook pointing to the receiver
+func newTestDispatcher(t *testing.T, options Options) (*Dispatcher, *memoryStore, *receiver, *time.Time) {
+	t.Helper()
+	target := &receiver{status: http.StatusOK}
+	httpServer := httptest.NewServer(target)
+	t.Cleanup(httpServer.Close)
+	store := newMemoryStore()
+	dispatcher := newDispatcher(store, options)
+	now := time.Unix(1700000000, 0)
+	dispatcher.now = func() time.Time { return now }
+	if err := dispatcher.Register(&models.Webhook{Url: httpServer.URL, Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	return dispatcher, store, target, &now
+}
+
+func enqueue(t *testing.T, dispatcher *Dispatcher, ids ...int64) {
+	t.Helper()
+	list := []*events.Event{}
+	for _, id := range ids {
+		list = append(list, &events.Event{Type: events.CREATE, DocId: id})
+	}
+	if err := dispatcher.Enqueue(list); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliveryIsSigned(t *testing.T) {
+	dispatcher, store, target, _ := newTestDispatcher(t, Options{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Minute})
+	enqueue(t, dispatcher, 1, 2)
+	dispatcher.deliverDue(context.Background())
+	if target.count() != 2 {
+		t.Fatalf("got %d deliveries, want 2", target.count())
+	}
+	for i, request := range target.requests {
+		timestamp := request.Header.Get(TimestampHeader)
+		if timestamp != strconv.FormatInt(1700000000, 10) {
+			t.Errorf("delivery %d has timestamp %q", i+1, timestamp)
+		}
+		if signature := request.Header.Get(SignatureHeader); signature != Sign("secret", timestamp, target.bodies[i]) {
+			t.Errorf("delivery %d has signature %q that does not match the body", i+1, signature)
+		}
+		if request.Header.Get(EventHeader) != string(events.CREATE) {
+			t.Errorf("delivery %d has event type %q", i+1, request.Header.Get(EventHeader))
+		}
+		if delivery := request.Header.Get(DeliveryHeader); delivery != strconv.Itoa(i+1) {
+			t.Errorf("delivery %d has id %q, the outbox order is broken", i+1, delivery)
+		}
+	}
+	if len(store.outbox) != 0 {
+		t.Fatalf("%d delivered entries stay in the outbox", len(store.outbox))
+	}
+	if Sign("other", "1700000000", target.bodies[0]) == target.requests[0].Header.Get(SignatureHeader) {
+		t.Fatal("signature does not depend on the secret")
+	}
+}
+
+func TestFailedDeliveryIsRetriedWithBackoff(t *testing.T) {
+	dispatcher, store, target, now := newTestDispatcher(t, Options{MaxAttempts: 5, Backoff: time.Second, MaxBackoff: 3 * time.Second})
+	target.status = http.StatusInternalServerError
+	enqueue(t, dispatcher, 1)
+	start := *now
+	// The delay doubles after each attempt until it reaches the upper limit
+	for attempt, delay := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		dispatcher.deliverDue(context.Background())
+		if target.count() != attempt+1 {
+			t.Fatalf("got %d deliveries after attempt %d", target.count(), attempt+1)
+		}
+		entry := store.outbox[1]
+		if entry.Attempts != attempt+1 || entry.LastError == "" {
+			t.Fatalf("entry has %d attempts and error %q after attempt %d", entry.Attempts, entry.LastError, attempt+1)
+		}
+		if next := time.Unix(0, entry.NextAttempt).Sub(*now); next != delay {
+			t.Fatalf("next attempt in %v after attempt %d, want %v", next, attempt+1, delay)
+		}
+		// Not due before the delay passes
+		*now = now.Add(delay - time.Nanosecond)
+		dispatcher.deliverDue(context.Background())
+		if target.count() != attempt+1 {
+			t.Fatalf("entry is delivered before its next attempt")
+		}
+		*now = now.Add(time.Nanosecond)
+	}
+	target.status = http.StatusNoContent
+	dispatcher.deliverDue(context.Background())
+	if len(store.outbox) != 0 || len(store.deadLetters) != 0 {
+		t.Fatalf("entry is not removed after the successful delivery at %v", now.Sub(start))
+	}
+}
+
+func TestUndeliveredEntryBecomesDeadLetter(t *testing.T) {
+	dispatcher, store, target, now := newTestDispatcher(t, Options{MaxAttempts: 2, Backoff: time.Second, MaxBackoff: time.Minute})
+	target.status = http.StatusBadGateway
+	enqueue(t, dispatcher, 1)
+	dispatcher.deliverDue(context.Background())
+	*now = now.Add(time.Second)
+	dispatcher.deliverDue(context.Background())
+	deadLetters, _ := dispatcher.DeadLetters()
+	if len(store.outbox) != 0 || len(deadLetters) != 1 {
+		t.Fatalf("got %d outbox entries and %d dead letters after the last attempt", len(store.outbox), len(deadLetters))
+	}
+	if deadLetters[0].Attempts != 2 || deadLetters[0].LastError != "Unexpected status 502" {
+		t.Fatalf("dead letter has %d attempts and error %q", deadLetters[0].Attempts, deadLetters[0].LastError)
+	}
+
+	// Moving the dead letter back gives it a fresh number of attempts
+	if err := dispatcher.Retry(deadLetters[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.Retry(deadLetters[0].Id); err != EntryNotExist {
+		t.Fatalf("got error %v for the retried dead letter", err)
+	}
+	target.status = http.StatusOK
+	dispatcher.deliverDue(context.Background())
+	if target.count() != 3 || len(store.outbox) != 0 || len(store.deadLetters) != 0 {
+		t.Fatalf("got %d deliveries, %d outbox entries and %d dead letters after the retry", target.count(), len(store.outbox), len(store.deadLetters))
+	}
+}
+
+func TestEnqueueMatchesWebhooks(t *testing.T) {
+	dispatcher, store, _, _ := newTestDispatcher(t, Options{MaxAttempts: 1})
+	if err := dispatcher.Register(&models.Webhook{Url: "http://example.com/hook", Types: []string{string(events.DELETE)}, Root: 5}); err != nil {
+		t.Fatal(err)
+	}
+	err := dispatcher.Enqueue([]*events.Event{
+		{Type: events.DELETE, DocId: 6, Ancestors: []int64{5}},
+		{Type: events.DELETE, DocId: 7},
+		{Type: events.CREATE, DocId: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first webhook gets all three events, the second one only the removal in its subtree
+	if len(store.outbox) != 4 {
+		t.Fatalf("got %d outbox entries, want 4", len(store.outbox))
+	}
+	if err := dispatcher.Unregister(2); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.outbox) != 3 {
+		t.Fatalf("entries of the removed webhook stay in the outbox")
+	}
+	if err := dispatcher.Unregister(2); err != WebhookNotExist {
+		t.Fatalf("got error %v for the removed webhook", err)
+	}
+	if err := dispatcher.Register(&models.Webhook{Url: "ftp://example.com"}); err != InvalidUrl {
+		t.Fatalf("got error %v for the ftp url", err)
+	}
+}
+
+func TestEntryIsDeliveredByOneDispatcher(t *testing.T) {
+	dispatcher, store, target, now := newTestDispatcher(t, Options{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Minute})
+	target.status = http.StatusInternalServerError
+	enqueue(t, dispatcher, 1, 2)
+	// The other replica delivers the entries between reading the outbox and claiming them
+	other := newDispatcher(store, dispatcher.options)
+	other.now = dispatcher.now
+	store.afterRead = func() { other.deliverDue(context.Background()) }
+	dispatcher.deliverDue(context.Background())
+	if target.count() != 2 {
+		t.Fatalf("got %d deliveries of 2 entries read by two dispatchers", target.count())
+	}
+	if store.outbox[1].Attempts != 1 || store.outbox[1].Owner != other.owner {
+		t.Fatalf("entry has %d attempts and owner %q after one failed delivery", store.outbox[1].Attempts, store.outbox[1].Owner)
+	}
+	// The failed entry is due again after the backoff and is claimed by whoever reads it first
+	*now = now.Add(time.Second)
+	target.status = http.StatusOK
+	dispatcher.deliverDue(context.Background())
+	if target.count() != 4 || len(store.outbox) != 0 {
+		t.Fatalf("got %d deliveries and %d outbox entries after the retry", target.count(), len(store.outbox))
+	}
+}